@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/plainid/git-backup/plainid"
+	"github.com/rs/zerolog/log"
+)
+
+// applicationsIndexFileName maps application IDs to their directory in every workspace directory
+const applicationsIndexFileName = "applications-index.json"
+
+// appIndexEntry is an application of the workspace index, applications removed from PlainID are kept as deleted
+type appIndexEntry struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Dir     string `json:"dir"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// readApplicationsIndex reads the index of the previous backup in wsDir, nil if there is none
+func readApplicationsIndex(wsDir string) ([]appIndexEntry, error) {
+	data, err := os.ReadFile(filepath.Join(wsDir, applicationsIndexFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applications index: %w", err)
+	}
+
+	var index []appIndexEntry
+	// A damaged index is rebuilt from the applications of this backup
+	if err := json.Unmarshal(data, &index); err != nil {
+		log.Warn().Err(err).Str("wsDir", wsDir).Msg("Applications index is not valid JSON, starting a new one")
+		return nil, nil
+	}
+	return index, nil
+}
+
+// mergeApplicationsIndex lists the backed up applications and marks the ones of the previous index
+// that are no longer in PlainID as deleted. The skipped applications keep their previous entry.
+// Entries are sorted by ID for stable diffs.
+func mergeApplicationsIndex(previous []appIndexEntry, apps, skipped []plainid.Application) []appIndexEntry {
+	index := make([]appIndexEntry, 0, len(apps)+len(previous))
+	current := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		current[app.ID] = true
+		index = append(index, appIndexEntry{
+			ID:   app.ID,
+			Name: app.Name,
+			Dir:  cfg.PlainID.AppDirName(app.ID, app.Name),
+		})
+	}
+	for _, entry := range previous {
+		switch {
+		case current[entry.ID]:
+		case slices.ContainsFunc(skipped, func(app plainid.Application) bool { return app.ID == entry.ID }):
+			entry.Deleted = false
+			index = append(index, entry)
+		default:
+			entry.Deleted = true
+			index = append(index, entry)
+		}
+	}
+
+	slices.SortFunc(index, func(a, b appIndexEntry) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return index
+}
+
+// writeApplicationsIndex writes the merged applications index to wsDir
+func writeApplicationsIndex(wsDir string, previous []appIndexEntry, apps, skipped []plainid.Application) error {
+	data, err := json.Marshal(mergeApplicationsIndex(previous, apps, skipped))
+	if err != nil {
+		return fmt.Errorf("failed to encode applications index: %w", err)
+	}
+	if err := writeBackupFile(filepath.Join(wsDir, applicationsIndexFileName), formatJSON(string(data))); err != nil {
+		return fmt.Errorf("failed to write applications index: %w", err)
+	}
+	return nil
+}
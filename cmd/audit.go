@@ -0,0 +1,332 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/plainid/git-backup/repository"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// auditOptions holds command-specific options
+type auditOptions struct {
+	tagA   string
+	tagB   string
+	envID  string
+	wsID   string
+	format string
+}
+
+var auditOpts auditOptions
+
+// auditFormatText is the default output format of audit, a changelog for people to read
+const auditFormatText = "text"
+
+// auditChangelog lists the files changed between two backups, by environment and workspace
+type auditChangelog struct {
+	TagA     string         `json:"tag_a"`
+	TagB     string         `json:"tag_b"`
+	Sections []auditSection `json:"sections"`
+}
+
+// auditSection holds the changed files of a workspace, or of the environment itself when the
+// workspace is empty. Paths are relative to the section directory.
+type auditSection struct {
+	Instance    string              `json:"instance,omitempty"`
+	Environment string              `json:"environment"`
+	Workspace   string              `json:"workspace,omitempty"`
+	Added       []string            `json:"added"`
+	Removed     []string            `json:"removed"`
+	Modified    []auditModifiedFile `json:"modified"`
+}
+
+// auditModifiedFile is a file present in both backups with a different content
+type auditModifiedFile struct {
+	Path         string `json:"path"`
+	LinesAdded   int    `json:"lines_added"`
+	LinesRemoved int    `json:"lines_removed"`
+}
+
+// dir returns the directory of the section in the backup
+func (s auditSection) dir() string {
+	return filepath.ToSlash(filepath.Join(s.Instance, s.Environment, s.Workspace))
+}
+
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "Summarize the changes between two backups",
+	Long: `List the files added, removed and modified between two backup tags, by environment and
+workspace, with the number of lines changed in every modified file. Without --tag-a and --tag-b
+the two most recent backups are compared.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if (auditOpts.tagA == "") != (auditOpts.tagB == "") {
+			return errors.New("both tag-a and tag-b must be provided together if one is specified")
+		}
+		if (auditOpts.envID == "") != (auditOpts.wsID == "") {
+			return errors.New("both env-id and ws-id must be provided together if one is specified")
+		}
+		switch auditOpts.format {
+		case auditFormatText, outputFormatJSON:
+		default:
+			return fmt.Errorf("format must be one of %s or %s: %s", auditFormatText, outputFormatJSON, auditOpts.format)
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Msg("Executing audit command")
+
+		tagA, tagB := auditOpts.tagA, auditOpts.tagB
+		if tagA == "" {
+			var err error
+			if tagA, tagB, err = latestBackupPair(); err != nil {
+				return err
+			}
+		}
+
+		dirA, err := checkoutForDiff(tagA)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(dirA)
+
+		dirB, err := checkoutForDiff(tagB)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(dirB)
+
+		changelog := auditChangelog{TagA: tagA, TagB: tagB}
+		if changelog.Sections, err = auditBackups(dirA, dirB); err != nil {
+			return err
+		}
+		return writeChangelog(cmd.OutOrStdout(), auditOpts.format, changelog)
+	},
+}
+
+// latestBackupPair returns the second most recent and the most recent backup tags
+func latestBackupPair() (string, string, error) {
+	tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return "", "", err
+	}
+	defer repository.CleanupTempDir(tempDir)
+
+	tags, err := listBackupTags(tempDir, "", "", nil)
+	if err != nil {
+		return "", "", err
+	}
+	if len(tags) < 2 {
+		return "", "", fmt.Errorf("at least two backups are needed, found %d", len(tags))
+	}
+	return tags[1].Name, tags[0].Name, nil
+}
+
+// auditBackups compares the backups checked out in dirA and dirB, or only the workspace given
+// with --env-id and --ws-id, and returns the changes by section
+func auditBackups(dirA, dirB string) ([]auditSection, error) {
+	var scopeA, scopeB string
+	scoped := auditOpts.envID != ""
+	if scoped {
+		var err error
+		if scopeA, err = workspaceBackupDir(dirA, auditOpts.envID, auditOpts.wsID); err != nil {
+			return nil, err
+		}
+		if scopeB, err = workspaceBackupDir(dirB, auditOpts.envID, auditOpts.wsID); err != nil {
+			return nil, err
+		}
+		if scopeA == "" && scopeB == "" {
+			return nil, fmt.Errorf("workspace '%s' of environment '%s' is in neither backup", auditOpts.wsID, auditOpts.envID)
+		}
+	}
+
+	filesA, err := backupFiles(dirA, scopeA, scoped)
+	if err != nil {
+		return nil, err
+	}
+	filesB, err := backupFiles(dirB, scopeB, scoped)
+	if err != nil {
+		return nil, err
+	}
+
+	// The files of a scoped audit are grouped under the workspace, as named in the later backup
+	scope := cmp.Or(scopeB, scopeA)
+	sections := make(map[string]*auditSection)
+	section := func(path string) *auditSection {
+		s, ok := newAuditSection(filepath.Join(scope, path))
+		if !ok {
+			return nil
+		}
+		if existing, ok := sections[s.dir()]; ok {
+			return existing
+		}
+		sections[s.dir()] = &s
+		return &s
+	}
+	relPath := func(s *auditSection, path string) string {
+		rel, _ := filepath.Rel(filepath.FromSlash(s.dir()), filepath.Join(scope, path))
+		return filepath.ToSlash(rel)
+	}
+
+	for path := range filesA {
+		s := section(path)
+		if s == nil {
+			continue
+		}
+		if !filesB[path] {
+			s.Removed = append(s.Removed, relPath(s, path))
+			continue
+		}
+		contentA, err := os.ReadFile(filepath.Join(dirA, scopeA, path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		contentB, err := os.ReadFile(filepath.Join(dirB, scopeB, path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if added, removed := lineChanges(string(contentA), string(contentB)); added > 0 || removed > 0 {
+			s.Modified = append(s.Modified, auditModifiedFile{Path: relPath(s, path), LinesAdded: added, LinesRemoved: removed})
+		}
+	}
+	for path := range filesB {
+		if s := section(path); s != nil && !filesA[path] {
+			s.Added = append(s.Added, relPath(s, path))
+		}
+	}
+
+	var result []auditSection
+	for _, s := range sections {
+		if len(s.Added)+len(s.Removed)+len(s.Modified) == 0 {
+			continue
+		}
+		slices.Sort(s.Added)
+		slices.Sort(s.Removed)
+		slices.SortFunc(s.Modified, func(a, b auditModifiedFile) int { return strings.Compare(a.Path, b.Path) })
+		result = append(result, *s)
+	}
+	slices.SortFunc(result, func(a, b auditSection) int { return strings.Compare(a.dir(), b.dir()) })
+	return result, nil
+}
+
+// newAuditSection returns the section of a backup file, given by its path in the backup. Files at
+// the root of the repository or of an instance, such as the backup history, change with every
+// backup and belong to no section.
+func newAuditSection(path string) (auditSection, bool) {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	var s auditSection
+	if len(parts) > 1 && slices.ContainsFunc(plainIDInstances, func(inst plainIDInstance) bool { return inst.alias == parts[0] }) {
+		s.Instance, parts = parts[0], parts[1:]
+	}
+	switch {
+	case len(parts) < 2:
+		return auditSection{}, false
+	case len(parts) == 2:
+		// Environment files, such as identity templates
+		s.Environment = parts[0]
+	default:
+		s.Environment, s.Workspace = parts[0], parts[1]
+	}
+	return s, true
+}
+
+// lineChanges counts the lines added and removed from a to b
+func lineChanges(a, b string) (added, removed int) {
+	matcher := difflib.NewMatcher(splitLines(a), splitLines(b))
+	for _, op := range matcher.GetOpCodes() {
+		switch op.Tag {
+		case 'r':
+			removed += op.I2 - op.I1
+			added += op.J2 - op.J1
+		case 'd':
+			removed += op.I2 - op.I1
+		case 'i':
+			added += op.J2 - op.J1
+		}
+	}
+	return added, removed
+}
+
+// splitLines splits s into lines, unlike difflib.SplitLines a final newline does not start a line
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writeChangelog writes the changelog to out as text or JSON
+func writeChangelog(out io.Writer, format string, changelog auditChangelog) error {
+	if format == outputFormatJSON {
+		// Empty lists rather than null
+		if changelog.Sections == nil {
+			changelog.Sections = []auditSection{}
+		}
+		for i := range changelog.Sections {
+			s := &changelog.Sections[i]
+			s.Added = nonNil(s.Added)
+			s.Removed = nonNil(s.Removed)
+			if s.Modified == nil {
+				s.Modified = []auditModifiedFile{}
+			}
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(changelog); err != nil {
+			return fmt.Errorf("failed to write changelog as JSON: %w", err)
+		}
+		return nil
+	}
+
+	if len(changelog.Sections) == 0 {
+		_, err := fmt.Fprintf(out, "No changes from %s to %s\n", changelog.TagA, changelog.TagB)
+		return err
+	}
+	fmt.Fprintf(out, "Changes from %s to %s\n", changelog.TagA, changelog.TagB)
+	for _, s := range changelog.Sections {
+		fmt.Fprintf(out, "\n%s\n", s.dir())
+		for _, path := range s.Added {
+			fmt.Fprintf(out, "  added:    %s\n", path)
+		}
+		for _, path := range s.Removed {
+			fmt.Fprintf(out, "  removed:  %s\n", path)
+		}
+		for _, file := range s.Modified {
+			fmt.Fprintf(out, "  modified: %s: %s added, %s removed\n", file.Path, pluralLines(file.LinesAdded), pluralLines(file.LinesRemoved))
+		}
+	}
+	return nil
+}
+
+// nonNil returns an empty slice instead of nil
+func nonNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+// pluralLines returns "1 line" or "n lines"
+func pluralLines(n int) string {
+	if n == 1 {
+		return "1 line"
+	}
+	return fmt.Sprintf("%d lines", n)
+}
+
+func init() {
+	auditCmd.Flags().StringVar(&auditOpts.tagA, "tag-a", "", "Tag of the earlier backup, the second most recent by default")
+	auditCmd.Flags().StringVar(&auditOpts.tagB, "tag-b", "", "Tag of the later backup, the most recent by default")
+	auditCmd.Flags().StringVar(&auditOpts.envID, "env-id", "", "Environment ID to audit (optional, requires ws-id)")
+	auditCmd.Flags().StringVar(&auditOpts.wsID, "ws-id", "", "Workspace ID to audit (optional, requires env-id)")
+	auditCmd.Flags().StringVar(&auditOpts.format, "format", auditFormatText, "Output format: text or json")
+}
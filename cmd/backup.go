@@ -1,268 +1,891 @@
 package cmd
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	gitconfig "github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/internal/metrics"
+	"github.com/plainid/git-backup/internal/worker"
+	"github.com/plainid/git-backup/plainid"
 	"github.com/plainid/git-backup/repository"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// configSnapshotFileName is the resolved configuration written at the root of every backup
+	configSnapshotFileName = "config-snapshot.yaml"
+	// conditionalCacheFileName kept the PlainID responses for conditional requests at the root of
+	// older backups, they are now kept outside of the repository, see conditionalCachePath
+	conditionalCacheFileName = "etag-cache.json"
+)
+
+var (
+	backupNoCommit  bool
+	backupLabels    []string
+	backupNoGroups  bool
+	backupNoSchemas bool
+	// backupNoEnvSettings skips the global settings of the environments
+	backupNoEnvSettings bool
+	backupBundle        string
+	backupConcurrency   int
+	// backupSemVer is the semantic version component incremented for the backup tag, timestamp tags are used if empty
+	backupSemVer string
+	// backupWatch is a file whose changes trigger backups, a single backup is run if empty
+	backupWatch string
+	// backupCommitPerEnv commits every environment on its own, the tag points to the last commit
+	backupCommitPerEnv bool
+	// backupTagMessageFile holds the tag message, "-" reads it from stdin
+	backupTagMessageFile string
+	// backupRetryOnConflict is how many times a backup rejected by the remote is retried
+	backupRetryOnConflict int
+	// backupForce commits and tags the backup even when nothing changed since the previous one
+	backupForce bool
+	// backupContinueOnError skips the workspaces that fail instead of failing the backup
+	backupContinueOnError bool
+	// backupIncludeApps and backupExcludeApps scope the backup to applications matching name or ID patterns
+	backupIncludeApps []string
+	backupExcludeApps []string
 )
 
 var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Backup PlainID configuration to git",
 	Long:  `Backup PlainID configuration to git and create a new tagged version.`,
-	RunE: func(cmd *cobra.Command, args []string) (err error) {
+	RunE: func(cmd *cobra.Command, args []string) error {
 		log.Info().Msg("Executing backup command")
-		if cfg.DryRun {
-			log.Info().Msg("Dry run mode: will download configuration but won't push to git")
+
+		if backupWatch != "" {
+			return watchBackups(cmd, backupWatch)
 		}
+		return backupWithRetries(cmd)
+	},
+}
 
-		// Use the new helper functions for temp directory management
-		tempDir, err := repository.CreateTempDir()
-		if err != nil {
+// backupWithRetries runs a backup, retrying it when the push is rejected, and emits its summary
+func backupWithRetries(cmd *cobra.Command) (err error) {
+	start := time.Now()
+	stats := &backupStats{}
+	defer func() {
+		emitBackupSummary(stats, start, err)
+		metrics.BackupDuration.Observe(time.Since(start).Seconds())
+		if err == nil {
+			metrics.LastBackupTimestamp.SetToCurrentTime()
+		}
+	}()
+
+	for attempt := 1; ; attempt++ {
+		err = runBackup(cmd, stats)
+		if !errors.Is(err, errPushRejected) || attempt > backupRetryOnConflict {
 			return err
 		}
-		defer func() {
-			if err == nil && cfg.Git.DeleteTempOnSuccess {
-				repository.CleanupTempDir(tempDir)
-			}
+		log.Warn().Err(err).Msgf("Retrying backup from a fresh clone (retry %d of %d)", attempt, backupRetryOnConflict)
+		stats = &backupStats{}
+	}
+}
 
-		}()
+// errPushRejected is returned when the remote branch has moved on since it was cloned
+var errPushRejected = errors.New("push rejected")
 
-		log.Info().Msgf("Temporary directory created: %s", tempDir)
+// nextSemVerTag fetches all tags of the remote and returns the latest semantic version tag
+// with the component incremented
+func nextSemVerTag(repo *git.Repository, component string) (string, error) {
+	err := repo.Fetch(&git.FetchOptions{
+		Auth:         gitAuth,
+		Tags:         git.AllTags,
+		ProxyOptions: repository.ProxyOptions(cfg.Git.SOCKSProxy),
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return "", fmt.Errorf("failed to fetch tags: %w", err)
+	}
 
-		repo, err := repository.CloneRemote(cfg.Git.Repo, cfg.Git.Branch, cfg.Git.Token, tempDir)
-		if err != nil {
+	latest, err := repository.LatestSemVerTag(repo)
+	if err != nil {
+		return "", err
+	}
+	next, err := latest.Bump(component)
+	if err != nil {
+		return "", err
+	}
+	return next.String(), nil
+}
+
+// isPushRejected checks if a push failed because the remote branch is not an ancestor of the pushed one.
+// go-git does not wrap ErrNonFastForwardUpdate for all transports, so the message is checked as well.
+func isPushRejected(err error) bool {
+	return err != nil && (errors.Is(err, git.ErrForceNeeded) ||
+		errors.Is(err, git.ErrNonFastForwardUpdate) ||
+		strings.Contains(err.Error(), "non-fast-forward"))
+}
+
+// runBackup clones the backup repository, writes the PlainID configuration to it and pushes a tagged commit
+func runBackup(cmd *cobra.Command, stats *backupStats) (err error) {
+	start := time.Now()
+	// In-flight PlainID requests are cancelled when the backup fails or the command is interrupted
+	ctx, cancel := context.WithCancel(cmd.Context())
+	defer cancel()
+
+	if backupConcurrency < 1 {
+		return errors.New("concurrency must be at least 1")
+	}
+	if backupSemVer != "" {
+		if _, err := (repository.SemVer{}).Bump(backupSemVer); err != nil {
 			return err
 		}
+	}
+	for _, pattern := range slices.Concat(backupIncludeApps, backupExcludeApps) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid application pattern %q: %w", pattern, err)
+		}
+	}
 
-		worktree, err := repo.Worktree()
-		if err != nil {
-			return fmt.Errorf("failed to get worktree: %w", err)
+	labels, err := parseLabels(backupLabels)
+	if err != nil {
+		return err
+	}
+	stats.labels = labels
+	customTagMessage, err := readTagMessage(cmd.InOrStdin(), backupTagMessageFile)
+	if err != nil {
+		return err
+	}
+	if cfg.DryRun {
+		log.Info().Msg("Dry run mode: will download configuration but won't push to git")
+	}
+
+	// Count the problems logged during the backup for its history record
+	logger := log.Logger
+	log.Logger = log.Logger.Hook(stats)
+	defer func() { log.Logger = logger }()
+
+	// Use the new helper functions for temp directory management
+	tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// Staged changes must survive so they can be reviewed and committed manually
+		if err == nil && cfg.Git.DeleteTempOnSuccess && !backupNoCommit {
+			repository.CleanupTempDir(tempDir)
 		}
 
-		// Process all environments and workspaces
-		timestamp := time.Now().Format("20060102-150405")
-		commitMsg := "Backup PlainID configuration for:"
+	}()
+	// The workspaces skipped with --continue-on-error fail the backup once the rest is committed
+	defer func() {
+		if err == nil {
+			err = stats.failuresError()
+		}
+	}()
 
-		for _, env := range cfg.PlainID.Envs {
-			envID := env.ID
-			envName := env.Name
-			log.Info().Msgf("Processing environment %s (%s) ...", envName, envID)
-			envDir := fmt.Sprintf("%s/%s_%s", tempDir, envName, envID)
+	log.Info().Msgf("Temporary directory created: %s", tempDir)
 
-			// please create a directory if it doesn't exist
-			if err = os.MkdirAll(envDir, 0755); err != nil {
-				return fmt.Errorf("failed to create environment directory: %w", err)
-			}
+	repo, err := repository.CloneRemoteViaProxy(cfg.Git.Repo, cfg.Git.Branch, gitAuth, tempDir, cfg.Git.SOCKSProxy, cfg.Git.Depth)
+	if err != nil {
+		return err
+	}
 
-			// remove all files (identity files) from the directory first (except directories)
-			err = removeFilesOnly(envDir)
-			if err != nil {
-				return fmt.Errorf("failed to remove files from env directory: %w", err)
-			}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
 
-			err := fetchPlainIDEnvStuff(envDir, envID)
-			if err != nil {
-				return fmt.Errorf("failed to fetch PlainID Env configuration for env:%s: %w", envID, err)
-			}
+	// Check for current HEAD reference, before any commit per environment
+	head, err := repo.Head()
+	isNewRepo := errors.Is(err, plumbing.ErrReferenceNotFound)
+	if err != nil && !isNewRepo {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
 
-			log.Info().Msgf("Number workspaces %d for %s", len(env.Workspaces), envID)
-			for _, ws := range env.Workspaces {
-				wsID := ws.ID     // unique
-				wsName := ws.Name // unique and required
-
-				log.Info().Msgf("Processing workspace %s (%s) ...", wsName, wsID)
-				wsDir := fmt.Sprintf("%s/%s", envDir, wsName)
-				// delete workspace content first
-				err = os.RemoveAll(wsDir)
-				if err != nil {
-					return fmt.Errorf("failed to remove workspace directory: %w", err)
-				}
-				if err := os.MkdirAll(wsDir, 0755); err != nil {
-					return fmt.Errorf("failed to create workspace directory: %w", err)
-				}
+	// Process all instances, environments and workspaces
+	backupTime := time.Now()
+	writtenFiles = newFileSet()
+	commitMsg := "Backup PlainID configuration for:"
+	var (
+		envs   []config.Environment
+		envIDs []string
+	)
 
-				err := fetchPlainIDWSStuff(wsDir, envID, wsID)
-				if err != nil {
-					return fmt.Errorf("failed to fetch PlainID WS configuration for env:%s ws:%s: %w", envID, wsID, err)
-				}
-				// Add to commit message
-				commitMsg += fmt.Sprintf(" env:%s ws:%s", envID, wsID)
+	// Every instance is backed up in the directory named after its alias, the plainid section at the root
+	instances := backupInstances()
+	shared := currentInstance("")
+	defer useInstance(shared)
+	for _, instance := range instances {
+		useInstance(instance)
+		instanceMsg, err := backupInstance(ctx, stats, worktree, instanceDir(tempDir, instance), instance.alias, backupTime)
+		if err != nil {
+			if instance.alias != "" {
+				return fmt.Errorf("failed to back up instance %s: %w", instance.alias, err)
 			}
+			return err
 		}
+		commitMsg += instanceMsg
+		envs = append(envs, cfg.PlainID.Envs...)
+	}
+	// The config snapshot holds the plainid section rather than the last instance
+	useInstance(shared)
+	for _, env := range envs {
+		envIDs = append(envIDs, env.ID)
+	}
 
-		// Check for current HEAD reference
-		_, err = repo.Head()
-		isNewRepo := errors.Is(err, plumbing.ErrReferenceNotFound)
+	if len(stats.failures) > 0 {
+		commitMsg += " skipped:"
+		for _, f := range stats.failures {
+			commitMsg += " " + f.String()
+		}
+	}
+
+	for _, label := range labels {
+		commitMsg += " " + labelPrefix + label
+	}
+
+	// Keep the configuration used for this backup next to it, without secrets
+	configSnapshot, err := cfg.SnapshotYAML()
+	if err != nil {
+		return err
+	}
+	if err := writeBackupFile(filepath.Join(tempDir, configSnapshotFileName), configSnapshot); err != nil {
+		return fmt.Errorf("failed to write config snapshot: %w", err)
+	}
 
-		// Instead of adding files one by one, use git's more comprehensive methods
-		// that will handle both additions, modifications, and deletions
-		worktree, err = repo.Worktree()
+	// The history, manifest and restore commands change with every backup, so they are left out of the check
+	if !isNewRepo && !backupForce && !backupNoCommit {
+		unchanged, err := backupUnchanged(repo, worktree, head.Hash())
 		if err != nil {
-			return fmt.Errorf("failed to get worktree: %w", err)
+			return err
 		}
+		if unchanged {
+			log.Info().Msg("No changes detected, skipping commit")
+			return nil
+		}
+	}
 
-		// First add all files to the index - this will ensure any deleted files are tracked
-		_, err = worktree.Add(".")
-		if err != nil {
-			return fmt.Errorf("failed to add all files to worktree: %w", err)
+	// The tag is recorded in the backup history before it is created, its name is checked first
+	tagName, err := cfg.Git.TagName(backupTime)
+	if err != nil {
+		return err
+	}
+	if backupSemVer != "" && !backupNoCommit {
+		if tagName, err = nextSemVerTag(repo, backupSemVer); err != nil {
+			return err
 		}
+	}
+	if backupNoCommit {
+		tagName = ""
+	}
 
-		// Commit the changes
-		commitHash, err := worktree.Commit(commitMsg, &git.CommitOptions{
-			Author: &object.Signature{
-				Name:  "PlainID Git Backup",
-				Email: "git-backup@plainid.com",
-				When:  time.Now(),
-			},
-			AllowEmptyCommits: true, // Set the branch reference if this is a new repository
-		})
+	// Without a tag there is nothing to restore from
+	if tagName != "" {
+		if err := writeRestoreCommands(tempDir, tagName, instances); err != nil {
+			return err
+		}
+	}
+
+	fileCount, err := countBackupFiles(tempDir)
+	if err != nil {
+		return err
+	}
+	err = updateBackupHistory(tempDir, backupRecord{
+		Tag:          tagName,
+		Timestamp:    backupTime.UTC(),
+		Environments: envIDs,
+		FileCount:    fileCount,
+		WsCount:      stats.workspaces.Load(),
+		AppCount:     stats.apps.Load(),
+		PolicyCount:  stats.policies.Load(),
+		DurationMs:   time.Since(start).Milliseconds(),
+		Errors:       stats.problems.Load() > 0,
+		Labels:       labels,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The manifest covers every other file, so it is written last
+	if err := writeManifest(tempDir, backupTime, envs, labels); err != nil {
+		return err
+	}
+
+	// Instead of adding files one by one, use git's more comprehensive methods
+	// that will handle both additions, modifications, and deletions
+	worktree, err = repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	// First add all files to the index - this will ensure any deleted files are tracked
+	_, err = worktree.Add(".")
+	if err != nil {
+		return fmt.Errorf("failed to add all files to worktree: %w", err)
+	}
+
+	// Leave the changes staged for manual review if requested
+	if backupNoCommit {
+		log.Info().Msgf("No-commit mode: changes staged in %s, review them with 'git diff --cached'", tempDir)
+		return nil
+	}
+
+	// Commit the changes
+	commitHash, err := worktree.Commit(commitMsg, &git.CommitOptions{
+		Author:            commitSignature(),
+		AllowEmptyCommits: true, // Set the branch reference if this is a new repository
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	log.Info().Msgf("Changes committed: %s", commitHash.String())
+
+	// For a new repository, create the branch reference
+	if isNewRepo {
+		// Create a reference for the branch
+		branchRef := plumbing.NewHashReference(
+			plumbing.NewBranchReferenceName(cfg.Git.Branch),
+			commitHash,
+		)
+
+		// Set the reference in the repository
+		if err := repo.Storer.SetReference(branchRef); err != nil {
+			return fmt.Errorf("failed to set branch reference: %w", err)
+		}
+		log.Info().Msgf("Created branch: %s", cfg.Git.Branch)
+	}
+
+	// The generated tag message is JSON metadata that list parses back
+	tagMessage := customTagMessage
+	if tagMessage == "" {
+		metadata := newBackupTagMetadata(stats, backupTime, envs, fileCount, labels)
+		if tagMessage, err = metadata.message(); err != nil {
+			return err
+		}
+	}
+	_, err = repo.CreateTag(tagName, commitHash, &git.CreateTagOptions{
+		Tagger:  commitSignature(),
+		Message: tagMessage,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create tag: %w", err)
+	}
+
+	log.Info().Msgf("Created tag: %s", tagName)
+	stats.tag = tagName
+
+	refSpecs := []gitconfig.RefSpec{
+		gitconfig.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", cfg.Git.Branch, cfg.Git.Branch)),
+		gitconfig.RefSpec(fmt.Sprintf("refs/tags/%s:refs/tags/%s", tagName, tagName)),
+	}
+
+	// Attach the labels to the backup commit as a git note
+	if len(labels) > 0 {
+		if err = repository.FetchNotes(repo, gitAuth, cfg.Git.SOCKSProxy); err != nil {
+			return err
+		}
+		err = repository.AddNote(repo, commitHash, strings.Join(labels, "\n")+"\n", *commitSignature())
 		if err != nil {
-			return fmt.Errorf("failed to commit changes: %w", err)
+			return fmt.Errorf("failed to add labels note: %w", err)
 		}
+		refSpecs = append(refSpecs, repository.NotesRefSpec)
+		log.Info().Msgf("Added labels note: %s", strings.Join(labels, ", "))
+	}
 
-		log.Info().Msgf("Changes committed: %s", commitHash.String())
+	// Skip pushing if dry run is enabled
+	if cfg.DryRun {
+		log.Info().Msg("Dry run mode: skipping push to remote repository")
+		return nil
+	}
 
-		// For a new repository, create the branch reference
-		if isNewRepo {
-			// Create a reference for the branch
-			branchRef := plumbing.NewHashReference(
-				plumbing.NewBranchReferenceName(cfg.Git.Branch),
-				commitHash,
-			)
+	// Push changes to remote
+	log.Info().Msg("Pushing changes to remote repository...")
+	err = repo.Push(&git.PushOptions{
+		Auth:         gitAuth,
+		RefSpecs:     refSpecs,
+		Force:        isNewRepo, // Force push for new repositories
+		ProxyOptions: repository.ProxyOptions(cfg.Git.SOCKSProxy),
+	})
+	if isPushRejected(err) {
+		return fmt.Errorf("%w: remote has newer commits. Another backup may have run concurrently. Re-run to fetch latest changes", errPushRejected)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to push changes: %w", err)
+	}
 
-			// Set the reference in the repository
-			if err := repo.Storer.SetReference(branchRef); err != nil {
-				return fmt.Errorf("failed to set branch reference: %w", err)
-			}
-			log.Info().Msgf("Created branch: %s", cfg.Git.Branch)
+	if backupBundle != "" {
+		if err = createBundle(backupBundle); err != nil {
+			return err
 		}
+	}
+
+	log.Info().Msg("Backup completed successfully")
+
+	return nil
+}
+
+// backupInstance backs up the environments of the current PlainID instance into dir, along with
+// its conditional cache, and returns their part of the commit message
+func backupInstance(ctx context.Context, stats *backupStats, worktree *git.Worktree, dir, alias string, backupTime time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create instance directory: %w", err)
+	}
+
+	// Response bodies would double the size of the repository, the cache of older backups is dropped
+	if err := os.Remove(filepath.Join(dir, conditionalCacheFileName)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("failed to remove %s: %w", conditionalCacheFileName, err)
+	}
+	var cachePath string
+	if conditionalCache != nil {
+		var err error
+		if cachePath, err = conditionalCachePath(alias); err != nil {
+			return "", err
+		}
+		if err := conditionalCache.Load(cachePath); err != nil {
+			return "", err
+		}
+	}
 
-		_, err = repo.CreateTag(timestamp, commitHash, &git.CreateTagOptions{
-			Tagger: &object.Signature{
-				Name:  "PlainID Git Backup",
-				Email: "git-backup@plainid.com",
-				When:  time.Now(),
-			},
-			Message: fmt.Sprintf("Backup tag for %s", commitMsg),
+	var commitMsg string
+	for _, env := range cfg.PlainID.Envs {
+		envID := env.ID
+		envName := env.Name
+		log.Info().Msgf("Processing environment %s (%s) ...", envName, envID)
+		stats.envs.Add(1)
+		envDir := fmt.Sprintf("%s/%s_%s", dir, envName, envID)
+
+		// please create a directory if it doesn't exist
+		if err := os.MkdirAll(envDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create environment directory: %w", err)
+		}
+
+		// Files are rewritten only when they change, those not written by this backup are removed below
+		err := fetchPlainIDEnvStuff(ctx, envDir, envID, backupTime)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch PlainID Env configuration for env:%s: %w", envID, err)
+		}
+
+		// Workspace details are only available from PlainID, the configuration holds IDs and names
+		wsDetails, err := plainIDService.Workspaces(ctx, envID)
+		if err != nil {
+			return "", fmt.Errorf("failed to fetch workspaces for env:%s: %w", envID, err)
+		}
+
+		log.Info().Msgf("Number workspaces %d for %s", len(env.Workspaces), envID)
+		// Workspaces are written to separate directories, so they can be backed up concurrently
+		pool := worker.NewWorkerPool[config.Workspace](cfg.WorkerCount)
+		err = pool.Run(ctx, env.Workspaces, func(ctx context.Context, ws config.Workspace) error {
+			err := backupWorkspace(ctx, stats, envDir, envID, ws, wsDetails)
+			if err != nil && backupContinueOnError && ctx.Err() == nil {
+				log.Warn().Err(err).Msgf("Skipping workspace %s (%s) of env %s", ws.Name, ws.ID, envID)
+				stats.addFailure(envID, ws.ID, err)
+				return nil
+			}
+			return err
 		})
 		if err != nil {
-			return fmt.Errorf("failed to create tag: %w", err)
+			return "", err
+		}
+		if err := removeStaleFiles(envDir); err != nil {
+			return "", err
+		}
+		for _, ws := range env.Workspaces {
+			if stats.failed(envID, ws.ID) {
+				// The skipped workspace keeps the content of the previous backup
+				if err := restorePreviousWorkspace(worktree, fmt.Sprintf("%s/%s", envDir, ws.Name)); err != nil {
+					return "", err
+				}
+				continue
+			}
+			// Add to commit message
+			commitMsg += fmt.Sprintf(" env:%s ws:%s", envID, ws.ID)
 		}
 
-		log.Info().Msgf("Created tag: %s", timestamp)
+		if backupCommitPerEnv && !backupNoCommit {
+			if err := commitEnvironment(worktree, envID, envName); err != nil {
+				return "", err
+			}
+		}
+	}
 
-		// Skip pushing if dry run is enabled
-		if cfg.DryRun {
-			log.Info().Msg("Dry run mode: skipping push to remote repository")
-			return nil
+	if conditionalCache != nil {
+		if err := conditionalCache.Save(cachePath); err != nil {
+			return "", err
 		}
+	}
+	return commitMsg, nil
+}
 
-		// Push changes to remote
-		log.Info().Msg("Pushing changes to remote repository...")
-		err = repo.Push(&git.PushOptions{
-			Auth: &http.BasicAuth{
-				Username: "oauth2",
-				Password: cfg.Git.Token,
-			},
-			RefSpecs: []gitconfig.RefSpec{
-				gitconfig.RefSpec(fmt.Sprintf("refs/heads/%s:refs/heads/%s", cfg.Git.Branch, cfg.Git.Branch)),
-				gitconfig.RefSpec(fmt.Sprintf("refs/tags/%s:refs/tags/%s", timestamp, timestamp)),
-			},
-			Force: isNewRepo, // Force push for new repositories
-		})
+// conditionalCachePath returns the file keeping the PlainID responses of the previous backup of the
+// instance, outside of the backup repository: under git.temp-dir when it is set, the user cache
+// directory otherwise. Backups of other repositories, branches or instances get their own file.
+func conditionalCachePath(alias string) (string, error) {
+	dir := cfg.Git.TempDir
+	if dir == "" {
+		userCacheDir, err := os.UserCacheDir()
 		if err != nil {
-			return fmt.Errorf("failed to push changes: %w", err)
+			userCacheDir = os.TempDir()
 		}
+		dir = userCacheDir
+	}
+	dir = filepath.Join(dir, "git-backup")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("failed to create cache directory: %w", err)
+	}
 
-		log.Info().Msg("Backup completed successfully")
+	key := sha256.Sum256([]byte(strings.Join([]string{cfg.Git.Repo, cfg.Git.Branch, alias}, "\n")))
+	name := fmt.Sprintf("%s-%x.json", strings.TrimSuffix(conditionalCacheFileName, ".json"), key[:8])
+	return filepath.Join(dir, name), nil
+}
 
+// restorePreviousWorkspace replaces the partially written workspace directory with its content in
+// the previous backup, the directory is removed if the workspace was not backed up before
+func restorePreviousWorkspace(worktree *git.Worktree, wsDir string) error {
+	if err := os.RemoveAll(wsDir); err != nil {
+		return fmt.Errorf("failed to remove workspace directory: %w", err)
+	}
+	relDir, err := filepath.Rel(worktree.Filesystem.Root(), wsDir)
+	if err != nil {
+		return fmt.Errorf("failed to locate workspace directory: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	// Only the committed files of the workspace show up as deleted
+	prefix := filepath.ToSlash(relDir) + "/"
+	var files []string
+	for path, fileStatus := range status {
+		if strings.HasPrefix(path, prefix) && fileStatus.Worktree == git.Deleted {
+			files = append(files, path)
+		}
+	}
+	if len(files) == 0 {
 		return nil
-	},
+	}
+	if err := worktree.Restore(&git.RestoreOptions{Staged: true, Worktree: true, Files: files}); err != nil {
+		return fmt.Errorf("failed to restore workspace directory %s: %w", relDir, err)
+	}
+	return nil
 }
 
-func fetchPlainIDWSStuff(wsDir, envID, wsID string) error {
-	apps, err := plainIDService.Applications(envID, wsID)
+// createBundle writes the full history of the remote repository to an offline git bundle.
+// The backup clone is shallow, so a complete mirror is fetched for the bundle.
+func createBundle(outputPath string) error {
+	log.Info().Msgf("Creating git bundle %s ...", outputPath)
+
+	mirrorDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return err
+	}
+	defer repository.CleanupTempDir(mirrorDir)
+
+	mirror, err := repository.CloneMirror(cfg.Git.Repo, cfg.Git.Branch, gitAuth, mirrorDir, cfg.Git.SOCKSProxy)
+	if err != nil {
+		return err
+	}
+
+	if err := repository.CreateBundle(mirror, outputPath); err != nil {
+		return err
+	}
+
+	log.Info().Msgf("Git bundle created: %s", outputPath)
+	return nil
+}
+
+// backupWorkspace replaces the directory of the workspace in envDir with its current PlainID configuration
+func backupWorkspace(ctx context.Context, stats *backupStats, envDir, envID string, ws config.Workspace, wsDetails []plainid.Workspace) error {
+	wsID := ws.ID     // unique
+	wsName := ws.Name // unique and required
+
+	log.Info().Msgf("Processing workspace %s (%s) ...", wsName, wsID)
+	wsDir := fmt.Sprintf("%s/%s", envDir, wsName)
+	// The applications index of the previous backup keeps the deleted applications
+	appsIndex, err := readApplicationsIndex(wsDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(wsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create workspace directory: %w", err)
+	}
+
+	if err := writeWorkspaceMetadata(wsDir, wsID, wsDetails); err != nil {
+		return err
+	}
+
+	stats.workspaces.Add(1)
+	err = fetchPlainIDWSStuff(ctx, stats, wsDir, envID, wsID, appsIndex)
+	if err != nil {
+		return fmt.Errorf("failed to fetch PlainID WS configuration for env:%s ws:%s: %w", envID, wsID, err)
+	}
+	return nil
+}
+
+func fetchPlainIDWSStuff(ctx context.Context, stats *backupStats, wsDir, envID, wsID string, appsIndex []appIndexEntry) error {
+	apps, err := plainIDService.Applications(ctx, envID, wsID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch apps: %w", err)
 	}
+	apps, skipped := filterApplications(apps)
+	// Applications left out of the backup are not deleted ones, their previous backup is kept
+	if err := keepSkippedApplications(wsDir, skipped, appsIndex); err != nil {
+		return err
+	}
 
-	assetTemplatesIDs, err := plainIDService.AssetTemplateIDs(wsID)
+	assetTemplatesIDs, err := plainIDService.AssetTemplateIDs(ctx, wsID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch asset template IDs: %w", err)
 	}
 
 	for i, assetTemplateID := range assetTemplatesIDs {
-		assetTemplate, err := plainIDService.AssetTemplate(envID, assetTemplateID)
+		assetTemplate, err := plainIDService.AssetTemplate(ctx, envID, assetTemplateID)
 		if err != nil {
 			return fmt.Errorf("failed to fetch asset template %s : %w", assetTemplateID, err)
 		}
 
 		path := fmt.Sprintf("%s/asset-template_%d.json", wsDir, i)
-		if err := os.WriteFile(path, []byte(assetTemplate), 0600); err != nil {
+		if err := writeBackupFile(path, formatJSON(assetTemplate)); err != nil {
 			return fmt.Errorf("failed to write asset template %s: %w", assetTemplateID, err)
 		}
 	}
 
+	wsAPIMapperSet, err := plainIDService.WsAPIMapper(ctx, envID, wsID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch workspace api mapper: %w", err)
+	}
+	if wsAPIMapperSet != "" {
+		path := fmt.Sprintf("%s/ws-api-mapper-set.json", wsDir)
+		if err := writeBackupFile(path, formatJSON(wsAPIMapperSet)); err != nil {
+			return fmt.Errorf("failed to write workspace api mapper: %w", err)
+		}
+	}
+
 	// Get the environment configuration
 	env := cfg.PlainID.FindEnvironment(envID)
 	if env == nil {
 		return fmt.Errorf("environment %s not found in configuration", envID)
 	}
 
+	// Applications are written to separate directories, so they can be fetched concurrently.
+	// The first failure cancels the requests of the other applications.
+	g, appCtx := errgroup.WithContext(ctx)
+	g.SetLimit(workspaceConcurrency(env, wsID))
+	for _, app := range apps {
+		g.Go(func() error {
+			return fetchPlainIDApp(appCtx, stats, wsDir, envID, wsID, app)
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	if err := writeApplicationsIndex(wsDir, appsIndex, apps, skipped); err != nil {
+		return err
+	}
+
+	// Skipped groups keep their previous backup
+	if backupNoGroups {
+		return keepPreviousFiles(fmt.Sprintf("%s/groups", wsDir))
+	}
+	return fetchPlainIDGroups(ctx, wsDir, envID, wsID)
+}
+
+// filterApplications splits apps into those backed up and those left out by --include-app and --exclude-app
+func filterApplications(apps []plainid.Application) (kept, skipped []plainid.Application) {
 	for _, app := range apps {
-		appDir := fmt.Sprintf("%s/%s", wsDir, app.Name)
-		if err := os.MkdirAll(appDir, 0755); err != nil {
-			return fmt.Errorf("failed to create application directory: %w", err)
+		if (len(backupIncludeApps) > 0 && !matchesApplication(backupIncludeApps, app)) ||
+			matchesApplication(backupExcludeApps, app) {
+			log.Debug().Str("appId", app.ID).Msgf("Skipping application %s, filtered out", app.Name)
+			skipped = append(skipped, app)
+			continue
 		}
+		kept = append(kept, app)
+	}
+	return kept, skipped
+}
 
-		log.Info().Msgf("Processing application %s (%s) ...", app.Name, app.ID)
+// keepSkippedApplications keeps the files of the previous backup of the skipped applications, found
+// in their directory of the previous applications index
+func keepSkippedApplications(wsDir string, skipped []plainid.Application, previous []appIndexEntry) error {
+	for _, app := range skipped {
+		dir := cfg.PlainID.AppDirName(app.ID, app.Name)
+		if i := slices.IndexFunc(previous, func(entry appIndexEntry) bool { return entry.ID == app.ID }); i >= 0 && previous[i].Dir != "" {
+			dir = previous[i].Dir
+		}
+		if err := keepPreviousFiles(filepath.Join(wsDir, dir)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-		path := fmt.Sprintf("%s/application.json", appDir)
-		appJSON, err := app.AsJSON()
-		if err != nil {
-			return fmt.Errorf("failed to convert app to JSON: %w", err)
+// matchesApplication reports whether the name or ID of app matches one of the glob patterns
+func matchesApplication(patterns []string, app plainid.Application) bool {
+	for _, pattern := range patterns {
+		// Patterns are validated before the backup starts
+		if matched, _ := path.Match(pattern, app.Name); matched {
+			return true
 		}
-		if err := os.WriteFile(path, []byte(appJSON), 0600); err != nil {
-			return fmt.Errorf("failed to write app: %w", err)
+		if matched, _ := path.Match(pattern, app.ID); matched {
+			return true
 		}
+	}
+	return false
+}
 
-		policies, err := plainIDService.AppPolicies(envID, wsID, app.ID)
-		if err != nil {
-			return fmt.Errorf("failed to fetch app policies: %w", err)
+// fetchPlainIDApp writes the application definition, policies and API mapper to the application directory
+func fetchPlainIDApp(ctx context.Context, stats *backupStats, wsDir, envID, wsID string, app plainid.Application) error {
+	appDir := fmt.Sprintf("%s/%s", wsDir, cfg.PlainID.AppDirName(app.ID, app.Name))
+	if err := os.MkdirAll(appDir, 0755); err != nil {
+		return fmt.Errorf("failed to create application directory: %w", err)
+	}
+
+	log.Info().Msgf("Processing application %s (%s) ...", app.Name, app.ID)
+	stats.apps.Add(1)
+
+	path := fmt.Sprintf("%s/application.json", appDir)
+	appJSON, err := app.AsJSON()
+	if err != nil {
+		return fmt.Errorf("failed to convert app to JSON: %w", err)
+	}
+	if err := writeBackupFile(path, formatJSON(appJSON)); err != nil {
+		return fmt.Errorf("failed to write app: %w", err)
+	}
+
+	policies, err := plainIDService.AppPolicies(ctx, envID, wsID, app.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch app policies: %w", err)
+	}
+	stats.policies.Add(int64(len(policies)))
+
+	for i, fileName := range policyFileNames(policies) {
+		path := fmt.Sprintf("%s/%s", appDir, fileName)
+		if err := writeBackupFile(path, []byte(policies[i].Content)); err != nil {
+			return fmt.Errorf("failed to write policy: %w", err)
 		}
+	}
 
-		for i, policy := range policies {
-			path := fmt.Sprintf("%s/policy_%d.srego", appDir, i)
-			if err := os.WriteFile(path, []byte(policy), 0600); err != nil {
-				return fmt.Errorf("failed to write policy: %w", err)
-			}
+	apiMapperSet, err := plainIDService.AppAPIMapper(ctx, envID, app.ID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch app api mapper: %w", err)
+	}
+	// An application without API mapper is backed up with an empty one
+	if apiMapperSet == "" {
+		apiMapperSet = "{}"
+	}
+	path = fmt.Sprintf("%s/api-mapper-set.json", appDir)
+	if err := writeBackupFile(path, formatJSON(apiMapperSet)); err != nil {
+		return fmt.Errorf("failed to write policy: %w", err)
+	}
+	return nil
+}
+
+// writeWorkspaceMetadata writes the PlainID details of the workspace to workspace-metadata.json
+func writeWorkspaceMetadata(wsDir, wsID string, workspaces []plainid.Workspace) error {
+	for _, ws := range workspaces {
+		if ws.ID != wsID {
+			continue
+		}
+		wsJSON, err := ws.AsJSON()
+		if err != nil {
+			return err
 		}
+		path := fmt.Sprintf("%s/workspace-metadata.json", wsDir)
+		if err := writeBackupFile(path, formatJSON(wsJSON)); err != nil {
+			return fmt.Errorf("failed to write workspace metadata: %w", err)
+		}
+		return nil
+	}
+
+	log.Warn().Msgf("Workspace %s not found in PlainID, skipping workspace metadata", wsID)
+	return nil
+}
 
-		apiMapperSet, err := plainIDService.AppAPIMapper(envID, app.ID)
+// workspaceConcurrency returns how many applications of the workspace are processed at once
+func workspaceConcurrency(env *config.Environment, wsID string) int {
+	if ws := env.FindWorkspace(wsID); ws != nil && ws.MaxConcurrency > 0 {
+		return ws.MaxConcurrency
+	}
+	return backupConcurrency
+}
+
+// fetchPlainIDGroups writes the workspace authorization groups as groups/<name>.json
+func fetchPlainIDGroups(ctx context.Context, wsDir, envID, wsID string) error {
+	groups, err := plainIDService.Groups(ctx, envID, wsID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch groups: %w", err)
+	}
+
+	log.Info().Msgf("Number of groups %d for %s", len(groups), wsID)
+	if len(groups) == 0 {
+		return nil
+	}
+
+	groupsDir := fmt.Sprintf("%s/groups", wsDir)
+	if err := os.MkdirAll(groupsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create groups directory: %w", err)
+	}
+
+	for _, group := range groups {
+		groupJSON, err := group.AsJSON()
 		if err != nil {
-			return fmt.Errorf("failed to fetch app api mapper: %w", err)
+			return fmt.Errorf("failed to convert group to JSON: %w", err)
 		}
-		path = fmt.Sprintf("%s/api-mapper-set.json", appDir)
-		if err := os.WriteFile(path, []byte(apiMapperSet), 0600); err != nil {
-			return fmt.Errorf("failed to write policy: %w", err)
+		path := fmt.Sprintf("%s/%s.json", groupsDir, sanitizeFileName(group.Name))
+		if err := writeBackupFile(path, formatJSON(groupJSON)); err != nil {
+			return fmt.Errorf("failed to write group %s: %w", group.ID, err)
 		}
 	}
 	return nil
 }
 
-func fetchPlainIDEnvStuff(envDir, envID string) error {
+// sanitizeFileName makes a PlainID resource name safe to use as a file name
+func sanitizeFileName(name string) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(name)
+}
+
+// maxPolicyNameLength is the maximum length of the policy name in policy file names
+const maxPolicyNameLength = 64
+
+// policyFileNames returns the file names of the policies, in order. Files are named after the
+// policy so they stay the same when policies are added or removed, policies whose names only
+// differ in the replaced characters or beyond the truncation get their ID appended.
+func policyFileNames(policies []plainid.PolicyContent) []string {
+	names := make([]string, len(policies))
+	count := make(map[string]int, len(policies))
+	for i, policy := range policies {
+		name := strings.ReplaceAll(sanitizeFileName(policy.Name), " ", "_")
+		if runes := []rune(name); len(runes) > maxPolicyNameLength {
+			name = string(runes[:maxPolicyNameLength])
+		}
+		names[i] = name
+		count[name]++
+	}
+
+	for i, name := range names {
+		if count[name] > 1 {
+			name += "_" + sanitizeFileName(policies[i].ID)
+		}
+		names[i] = fmt.Sprintf("policy_%s.rego", name)
+	}
+	return names
+}
+
+func fetchPlainIDEnvStuff(ctx context.Context, envDir, envID string, backupTime time.Time) error {
 	// Get the environment configuration
 	env := cfg.PlainID.FindEnvironment(envID)
 	if env == nil {
@@ -274,18 +897,18 @@ func fetchPlainIDEnvStuff(envDir, envID string) error {
 	// Process identity templates using identities from the environment config
 	log.Info().Msgf("Number of identities %d for %s", len(env.Identities), envID)
 	for _, identity := range env.Identities {
-		identityTemplates, err := plainIDService.IdentityTemplates(envID, identity)
+		identityTemplates, err := plainIDService.IdentityTemplates(ctx, envID, identity)
 		if err != nil {
 			return fmt.Errorf("failed to fetch app identity templates: %w", err)
 		}
 		path := fmt.Sprintf("%s/identity-template-%s.json", envDir, identity)
-		if err := os.WriteFile(path, []byte(identityTemplates), 0600); err != nil {
+		if err := writeBackupFile(path, formatJSON(identityTemplates)); err != nil {
 			return fmt.Errorf("failed to write identity template: %w", err)
 		}
 	}
 
 	// Fetch PAA groups
-	paaGroups, err := plainIDService.PAAGroups(envID)
+	paaGroups, err := plainIDService.PAAGroups(ctx, envID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch PAA groups: %w", err)
 	}
@@ -299,39 +922,337 @@ func fetchPlainIDEnvStuff(envDir, envID string) error {
 			return fmt.Errorf("failed to convert PAA group to JSON: %w", err)
 		}
 
-		if err := os.WriteFile(path, []byte(paaGroupJSON), 0600); err != nil {
+		if err := writeBackupFile(path, formatJSON(paaGroupJSON)); err != nil {
 			return fmt.Errorf("failed to write identity template: %w", err)
 		}
 	}
 
+	// Skipped attribute schemas keep their previous backup
+	if backupNoSchemas {
+		if err := keepPreviousFiles(fmt.Sprintf("%s/attribute-schemas.json", envDir)); err != nil {
+			return err
+		}
+	} else {
+		log.Info().Msgf("Fetching attribute schemas for %s ...", envID)
+		schemas, err := plainIDService.AttributeSchemas(ctx, envID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch attribute schemas: %w", err)
+		}
+		if schemas != "" {
+			path := fmt.Sprintf("%s/attribute-schemas.json", envDir)
+			if err := writeBackupFile(path, formatJSON(schemas)); err != nil {
+				return fmt.Errorf("failed to write attribute schemas: %w", err)
+			}
+		}
+	}
+
+	// Skipped environment settings keep their previous backup
+	if backupNoEnvSettings {
+		if err := keepPreviousFiles(fmt.Sprintf("%s/environment-settings.json", envDir)); err != nil {
+			return err
+		}
+	} else {
+		log.Info().Msgf("Fetching environment settings for %s ...", envID)
+		settings, err := plainIDService.EnvironmentSettings(ctx, envID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch environment settings: %w", err)
+		}
+		if settings != "" {
+			path := fmt.Sprintf("%s/environment-settings.json", envDir)
+			if err := writeBackupFile(path, formatJSON(settings)); err != nil {
+				return fmt.Errorf("failed to write environment settings: %w", err)
+			}
+		}
+	}
+
+	// The logs of the previous backups make the time series of decisions, they are kept
+	previousAuditLogs, err := filepath.Glob(fmt.Sprintf("%s/audit-log-*.json", envDir))
+	if err != nil {
+		return fmt.Errorf("failed to list audit logs: %w", err)
+	}
+	for _, path := range previousAuditLogs {
+		writtenFiles.add(path)
+	}
+
+	if cfg.PlainID.BackupAuditLog {
+		since := backupTime.Add(-cfg.PlainID.AuditLogWindow)
+		log.Info().Msgf("Fetching audit logs since %s for %s ...", since.Format(time.RFC3339), envID)
+		auditLogs, err := plainIDService.AuditLogs(ctx, envID, since, backupTime)
+		if err != nil {
+			return fmt.Errorf("failed to fetch audit logs: %w", err)
+		}
+
+		path := fmt.Sprintf("%s/audit-log-%s.json", envDir, backupTime.UTC().Format(auditLogTimeFormat))
+		if err := writeBackupFile(path, formatJSON(auditLogs)); err != nil {
+			return fmt.Errorf("failed to write audit logs: %w", err)
+		}
+	}
+
 	return nil
 }
 
-func removeFilesOnly(dir string) error {
-	log.Info().Msgf("cleaning up directory %s ...", dir)
-	entries, err := os.ReadDir(dir)
+// auditLogTimeFormat is the layout of the backup time, in UTC, in the audit log file names
+const auditLogTimeFormat = "20060102-150405"
+
+// formatJSON indents JSON content with the configured indentation so a changed field
+// shows as a single line in git diffs. Content that is not JSON is kept as is.
+func formatJSON(content string) []byte {
+	var buf bytes.Buffer
+	var err error
+	if cfg.Git.JSONIndent == "" {
+		err = json.Compact(&buf, []byte(content))
+	} else {
+		err = json.Indent(&buf, []byte(content), "", cfg.Git.JSONIndent)
+	}
+	if err != nil {
+		log.Debug().Err(err).Msg("Content is not valid JSON, writing it unformatted")
+		return []byte(content)
+	}
+	return buf.Bytes()
+}
+
+// commitEnvironment commits the changes of an environment on their own, nothing is committed if it is unchanged
+func commitEnvironment(worktree *git.Worktree, envID, envName string) error {
+	if _, err := worktree.Add("."); err != nil {
+		return fmt.Errorf("failed to add environment %s to worktree: %w", envID, err)
+	}
+	status, err := worktree.Status()
 	if err != nil {
-		// If directory doesn't exist, no need to remove anything
-		if os.IsNotExist(err) {
-			return nil
+		return fmt.Errorf("failed to get worktree status: %w", err)
+	}
+	if status.IsClean() {
+		log.Info().Msgf("Environment %s unchanged, no commit created", envID)
+		return nil
+	}
+
+	commitHash, err := worktree.Commit(fmt.Sprintf("Backup env:%s (%s)", envID, envName), &git.CommitOptions{
+		Author: commitSignature(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to commit environment %s: %w", envID, err)
+	}
+	log.Info().Msgf("Environment %s committed: %s", envID, commitHash.String())
+	return nil
+}
+
+// backupUnchanged checks if the backup in the worktree is identical to the cloned commit: no
+// environment was committed on its own and no file is staged after adding all of them
+func backupUnchanged(repo *git.Repository, worktree *git.Worktree, clonedHead plumbing.Hash) (bool, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return false, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	if head.Hash() != clonedHead {
+		return false, nil
+	}
+
+	if _, err := worktree.Add("."); err != nil {
+		return false, fmt.Errorf("failed to add all files to worktree: %w", err)
+	}
+	status, err := worktree.Status()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+	for _, file := range status {
+		if file.Staging != git.Unmodified && file.Staging != git.Untracked {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// readTagMessage reads the tag message from path, or from in if path is "-".
+// An empty path or a missing file gives an empty message, the generated one is used instead.
+func readTagMessage(in io.Reader, path string) (string, error) {
+	var (
+		data []byte
+		err  error
+	)
+	switch path {
+	case "":
+		return "", nil
+	case "-":
+		data, err = io.ReadAll(in)
+	default:
+		data, err = os.ReadFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Str("path", path).Msg("Tag message file not found, using the generated tag message")
+			return "", nil
 		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read tag message: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// writtenFiles records the files of the backup, written or unchanged, the other files of the
+// environment directories are stale once they are backed up
+var writtenFiles = newFileSet()
+
+// fileSet is a set of file paths, recorded concurrently by the workspace backups
+type fileSet struct {
+	mu    sync.Mutex
+	paths map[string]struct{}
+}
+
+func newFileSet() *fileSet {
+	return &fileSet{paths: make(map[string]struct{})}
+}
+
+func (f *fileSet) add(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.paths[filepath.Clean(path)] = struct{}{}
+}
+
+func (f *fileSet) contains(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.paths[filepath.Clean(path)]
+	return ok
+}
+
+// writeBackupFile writes fetched content to path, verifying it was written intact when enabled
+func writeBackupFile(path string, data []byte) error {
+	if err := validateBackupContent(path, data); err != nil {
 		return err
 	}
+	written, err := writeIfChanged(path, data)
+	if err != nil {
+		return err
+	}
+	writtenFiles.add(path)
+	if written && cfg.VerifyWrites {
+		return verifyWrittenFile(path, data)
+	}
+	return nil
+}
+
+// maxContentExcerpt is how much of invalid content is quoted in the error, to aid diagnosis
+const maxContentExcerpt = 200
+
+// validateBackupContent detects content truncated by an interrupted PlainID response before it is
+// written: JSON files must hold valid JSON and policies must declare their package
+func validateBackupContent(path string, data []byte) error {
+	excerpt := data[:min(len(data), maxContentExcerpt)]
+	switch filepath.Ext(path) {
+	case ".json":
+		if !json.Valid(data) {
+			return fmt.Errorf("invalid JSON content for %s, the response may be truncated: %q", path, excerpt)
+		}
+	case ".rego":
+		if len(bytes.TrimSpace(data)) == 0 {
+			return fmt.Errorf("empty policy content for %s", path)
+		}
+		if !bytes.Contains(data, []byte("package")) {
+			return fmt.Errorf("invalid policy content for %s, no package declaration: %q", path, excerpt)
+		}
+	}
+	return nil
+}
+
+// writeIfChanged writes content to path unless the file already holds exactly that content,
+// so unchanged resources are left untouched in the working tree. It reports whether it wrote the file.
+func writeIfChanged(path string, content []byte) (bool, error) {
+	existing, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(existing, content) {
+		log.Debug().Str("path", path).Msg("File unchanged, not rewriting it")
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// verifyWrittenFile re-reads the file and compares it byte-for-byte with the expected content
+func verifyWrittenFile(path string, expected []byte) error {
+	written, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to verify %s: %w", path, err)
+	}
+	if !bytes.Equal(written, expected) {
+		log.Warn().Str("path", path).Int("expected", len(expected)).Int("written", len(written)).
+			Msg("Written file does not match fetched content")
+		return fmt.Errorf("failed to verify %s: written content does not match fetched content", path)
+	}
+	return nil
+}
 
-	for _, entry := range entries {
-		path := filepath.Join(dir, entry.Name())
+// keepPreviousFiles records the files of the previous backup under path as written, so the
+// resources left out of this backup on purpose are not removed as stale
+func keepPreviousFiles(path string) error {
+	err := filepath.WalkDir(path, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			writtenFiles.add(path)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to keep the previous backup of %s: %w", path, err)
+	}
+	return nil
+}
+
+// removeStaleFiles removes the files under dir that the backup did not write, resources deleted
+// from PlainID since the previous backup, along with the directories left empty
+func removeStaleFiles(dir string) error {
+	var dirs []string
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 		if entry.IsDir() {
-			// Recursively clean subdirectories
-			if err := removeFilesOnly(path); err != nil {
-				return err
-			}
-		} else {
-			// Remove files
-			if err := os.Remove(path); err != nil {
-				return err
+			if path != dir {
+				dirs = append(dirs, path)
 			}
+			return nil
+		}
+		if writtenFiles.contains(path) {
+			return nil
 		}
+		log.Debug().Str("path", path).Msg("Removing file no longer in PlainID")
+		return os.Remove(path)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to remove stale files from %s: %w", dir, err)
 	}
 
+	// Subdirectories come after their parent, so the deepest ones are removed first
+	for _, subDir := range slices.Backward(dirs) {
+		if entries, err := os.ReadDir(subDir); err == nil && len(entries) == 0 {
+			if err := os.Remove(subDir); err != nil {
+				return fmt.Errorf("failed to remove empty directory %s: %w", subDir, err)
+			}
+		}
+	}
 	return nil
 }
+
+func init() {
+	// Add backup-specific flags
+	backupCmd.Flags().StringArrayVar(&backupLabels, "label", nil, "Label the backup with key=value metadata (can be repeated)")
+	backupCmd.Flags().BoolVar(&backupNoGroups, "no-groups", false, "Skip backing up authorization groups")
+	backupCmd.Flags().BoolVar(&backupNoSchemas, "no-attribute-schemas", false, "Skip backing up attribute schemas")
+	backupCmd.Flags().BoolVar(&backupCommitPerEnv, "commit-per-env", false, "Commit every environment separately, the tag points to the last commit")
+	backupCmd.Flags().StringVar(&backupTagMessageFile, "tag-message-file", "", "File whose content is used as tag message, - to read it from stdin")
+	backupCmd.Flags().BoolVar(&backupNoEnvSettings, "no-env-settings", false, "Skip backing up environment settings")
+	backupCmd.Flags().StringVar(&backupBundle, "bundle-output", "", "Write a git bundle of the whole backup repository to this path after pushing")
+	backupCmd.Flags().StringVar(&backupSemVer, "semantic-version", "", "Tag the backup with the latest vX.Y.Z tag incremented by patch, minor or major instead of a timestamp")
+	backupCmd.Flags().StringVar(&backupWatch, "watch", "", "Keep running and back up whenever this file changes (e.g. a file written by a PlainID webhook handler)")
+	backupCmd.Flags().IntVar(&backupRetryOnConflict, "retry-on-conflict", 0, "Number of times to re-clone and retry a backup whose push is rejected because the remote has newer commits")
+	backupCmd.Flags().IntVar(&backupConcurrency, "concurrency", 1, "Number of applications processed concurrently per workspace")
+	backupCmd.Flags().BoolVar(&backupContinueOnError, "continue-on-error", false, "Skip the workspaces that fail to back up, commit the others and fail once the backup is pushed")
+	backupCmd.Flags().StringSliceVar(&backupIncludeApps, "include-app", nil, "Only back up the applications whose name or ID matches one of these glob patterns (comma-separated)")
+	backupCmd.Flags().StringSliceVar(&backupExcludeApps, "exclude-app", nil, "Skip the applications whose name or ID matches one of these glob patterns (comma-separated)")
+	backupCmd.Flags().BoolVar(&backupForce, "force", false, "Commit and tag the backup even when nothing changed since the previous one")
+	backupCmd.Flags().BoolVar(&backupNoCommit, "no-commit", false, "Stage fetched files in the working tree without committing, tagging or pushing")
+}
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// backupHistoryFileName lists the most recent backups at the root of every backup
+	backupHistoryFileName = "backup-history.json"
+	// maxBackupHistory is the number of backups kept in the history, older ones are dropped
+	maxBackupHistory = 100
+)
+
+// backupRecord describes a backup in the history file
+type backupRecord struct {
+	Tag          string    `json:"tag"`
+	Timestamp    time.Time `json:"timestamp"`
+	Environments []string  `json:"environments"`
+	FileCount    int       `json:"file_count"`
+	WsCount      int64     `json:"ws_count"`
+	AppCount     int64     `json:"app_count"`
+	PolicyCount  int64     `json:"policy_count"`
+	DurationMs   int64     `json:"duration_ms"`
+	// Errors is set when warnings or errors were logged that did not fail the backup
+	Errors bool `json:"errors"`
+	// Labels are the key=value labels given with --label
+	Labels []string `json:"labels,omitempty"`
+}
+
+// Run counts the warnings and errors logged during a backup, backupStats is installed as a zerolog hook
+func (s *backupStats) Run(_ *zerolog.Event, level zerolog.Level, _ string) {
+	if level >= zerolog.WarnLevel && level < zerolog.NoLevel {
+		s.problems.Add(1)
+	}
+}
+
+// updateBackupHistory prepends the record to the history file of the backup in dir,
+// keeping the most recent maxBackupHistory backups
+func updateBackupHistory(dir string, record backupRecord) error {
+	path := filepath.Join(dir, backupHistoryFileName)
+
+	history := make([]backupRecord, 0, maxBackupHistory)
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read backup history: %w", err)
+	default:
+		// A damaged history must not prevent new backups
+		if err := json.Unmarshal(data, &history); err != nil {
+			log.Warn().Err(err).Msg("Backup history is not valid JSON, starting a new one")
+			history = history[:0]
+		}
+	}
+
+	history = append([]backupRecord{record}, history...)
+	if len(history) > maxBackupHistory {
+		history = history[:maxBackupHistory]
+	}
+
+	data, err = json.Marshal(history)
+	if err != nil {
+		return fmt.Errorf("failed to encode backup history: %w", err)
+	}
+	if err := writeFileAtomic(path, formatJSON(string(data))); err != nil {
+		return fmt.Errorf("failed to write backup history: %w", err)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames it, so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), path); err != nil {
+		return err
+	}
+
+	if cfg.VerifyWrites {
+		return verifyWrittenFile(path, data)
+	}
+	return nil
+}
+
+// countBackupFiles counts the files of the backup in dir, without the git metadata
+func countBackupFiles(dir string) (int, error) {
+	count := 0
+	err := filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == git.GitDirName {
+			return filepath.SkipDir
+		}
+		if !d.IsDir() {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to count backup files: %w", err)
+	}
+	return count, nil
+}
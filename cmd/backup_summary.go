@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// summaryOutput receives the machine-readable backup summary, apart from the progress logs on stdout
+var summaryOutput io.Writer = os.Stderr
+
+// backupStats counts what a backup processed, applications are counted concurrently
+type backupStats struct {
+	envs       atomic.Int64
+	workspaces atomic.Int64
+	apps       atomic.Int64
+	policies   atomic.Int64
+	// problems counts the warnings and errors logged during the backup
+	problems atomic.Int64
+	// tag is set once the backup is tagged
+	tag string
+	// labels are the key=value labels given with --label
+	labels []string
+
+	// failures are the workspaces skipped with --continue-on-error, recorded concurrently
+	failuresMu sync.Mutex
+	failures   []workspaceFailure
+}
+
+// workspaceFailure is a workspace that could not be backed up
+type workspaceFailure struct {
+	envID string
+	wsID  string
+	err   error
+}
+
+func (f workspaceFailure) String() string {
+	return fmt.Sprintf("env:%s ws:%s", f.envID, f.wsID)
+}
+
+// addFailure records a workspace that could not be backed up
+func (s *backupStats) addFailure(envID, wsID string, err error) {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	s.failures = append(s.failures, workspaceFailure{envID: envID, wsID: wsID, err: err})
+}
+
+// failed reports whether the workspace was skipped
+func (s *backupStats) failed(envID, wsID string) bool {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	for _, f := range s.failures {
+		if f.envID == envID && f.wsID == wsID {
+			return true
+		}
+	}
+	return false
+}
+
+// failuresError returns an error listing the skipped workspaces, nil if there are none
+func (s *backupStats) failuresError() error {
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	if len(s.failures) == 0 {
+		return nil
+	}
+	skipped := make([]string, 0, len(s.failures))
+	for _, f := range s.failures {
+		skipped = append(skipped, f.String())
+	}
+	return fmt.Errorf("failed to back up %d workspace(s): %s", len(s.failures), strings.Join(skipped, ", "))
+}
+
+// backupSummary is the single JSON event emitted when a backup completes, for log aggregation
+type backupSummary struct {
+	Event       string   `json:"event"`
+	Status      string   `json:"status"`
+	Tag         string   `json:"tag"`
+	DurationMs  int64    `json:"duration_ms"`
+	EnvCount    int64    `json:"env_count"`
+	WsCount     int64    `json:"ws_count"`
+	AppCount    int64    `json:"app_count"`
+	PolicyCount int64    `json:"policy_count"`
+	Labels      []string `json:"labels,omitempty"`
+	Error       *string  `json:"error"`
+}
+
+// emitBackupSummary writes the summary event of a backup started at start to summaryOutput
+func emitBackupSummary(stats *backupStats, start time.Time, backupErr error) {
+	summary := backupSummary{
+		Event:       "backup_complete",
+		Status:      "success",
+		Tag:         stats.tag,
+		DurationMs:  time.Since(start).Milliseconds(),
+		EnvCount:    stats.envs.Load(),
+		WsCount:     stats.workspaces.Load(),
+		AppCount:    stats.apps.Load(),
+		PolicyCount: stats.policies.Load(),
+		Labels:      stats.labels,
+	}
+	if backupErr != nil {
+		summary.Status = "failure"
+		message := backupErr.Error()
+		summary.Error = &message
+	}
+
+	if err := json.NewEncoder(summaryOutput).Encode(summary); err != nil {
+		log.Warn().Err(err).Msg("Failed to write backup summary")
+	}
+}
@@ -0,0 +1,212 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// cloneOptions holds command-specific options
+type cloneOptions struct {
+	srcTag   string
+	srcEnvID string
+	srcWsID  string
+	dstEnvID string
+	dstWsID  string
+	yes      bool
+}
+
+var cloneOpts cloneOptions
+
+// cloneTemplate is an asset or identity template file of the cloned backup
+type cloneTemplate struct {
+	id   string
+	path string
+}
+
+// clonePlan lists the configuration of the source workspace pushed to the target workspace, in
+// the order it is pushed: templates, then applications, then their policies
+type clonePlan struct {
+	identityTemplates []cloneTemplate
+	assetTemplates    []cloneTemplate
+	apps              []backupApplication
+	// policies hold the source application IDs until the target applications are known
+	policies []backupPolicy
+}
+
+var cloneCmd = &cobra.Command{
+	Use:   "clone",
+	Short: "Copy the backup of a workspace to another environment or workspace",
+	Long: `Push the configuration of a workspace, as backed up in --src-tag, to another workspace through
+the PlainID API, for instance to promote the staging configuration to production. The identity
+templates of the source environment, the asset templates, applications and policies of the
+workspace are created or updated in the target. With --dry-run, the configuration is only listed.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Str("tag", cloneOpts.srcTag).Msg("Executing clone command")
+
+		tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(tempDir)
+
+		if _, err := cloneAndCheckoutTag(tempDir, cloneOpts.srcTag); err != nil {
+			return err
+		}
+		wsDir, err := workspaceBackupDir(tempDir, cloneOpts.srcEnvID, cloneOpts.srcWsID)
+		if err != nil {
+			return err
+		}
+		if wsDir == "" {
+			return fmt.Errorf("workspace '%s' of environment '%s' not found in backup %s", cloneOpts.srcWsID, cloneOpts.srcEnvID, cloneOpts.srcTag)
+		}
+
+		plan, err := newClonePlan(filepath.Join(tempDir, wsDir))
+		if err != nil {
+			return err
+		}
+		plan.write(cmd.OutOrStdout())
+
+		if cfg.DryRun {
+			log.Info().Msg("Dry run mode: Nothing pushed to PlainID")
+			return nil
+		}
+		if !cloneOpts.yes {
+			prompter := &initPrompter{scanner: bufio.NewScanner(cmd.InOrStdin()), out: cmd.ErrOrStderr()}
+			confirmed, err := prompter.confirm(fmt.Sprintf("Overwrite the configuration of workspace %s in environment %s?", cloneOpts.dstWsID, cloneOpts.dstEnvID))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				log.Info().Msg("Clone cancelled, nothing pushed")
+				return nil
+			}
+		}
+
+		if err := plan.push(cmd.Context()); err != nil {
+			return err
+		}
+		log.Info().Msg("Clone completed")
+		return nil
+	},
+}
+
+// newClonePlan reads the configuration of the workspace backed up in wsDir, along with the
+// identity templates of its environment
+func newClonePlan(wsDir string) (clonePlan, error) {
+	var plan clonePlan
+
+	identityPaths, err := filepath.Glob(filepath.Join(filepath.Dir(wsDir), "identity-template-*.json"))
+	if err != nil {
+		return clonePlan{}, fmt.Errorf("failed to list identity templates: %w", err)
+	}
+	for _, path := range identityPaths {
+		id := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "identity-template-"), ".json")
+		plan.identityTemplates = append(plan.identityTemplates, cloneTemplate{id: id, path: path})
+	}
+
+	// Asset template files are numbered, their ID is in the template
+	assetPaths, err := filepath.Glob(filepath.Join(wsDir, "asset-template_*.json"))
+	if err != nil {
+		return clonePlan{}, fmt.Errorf("failed to list asset templates: %w", err)
+	}
+	for _, path := range assetPaths {
+		var template struct {
+			ID string `json:"id"`
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return clonePlan{}, fmt.Errorf("failed to read asset template: %w", err)
+		}
+		if err := json.Unmarshal(data, &template); err != nil || template.ID == "" {
+			return clonePlan{}, fmt.Errorf("asset template %s has no id", filepath.Base(path))
+		}
+		plan.assetTemplates = append(plan.assetTemplates, cloneTemplate{id: template.ID, path: path})
+	}
+
+	if plan.apps, err = workspaceApplications(wsDir, cloneOpts.dstEnvID, cloneOpts.dstWsID); err != nil {
+		return clonePlan{}, err
+	}
+	if plan.policies, err = workspacePolicies(wsDir, cloneOpts.dstEnvID, cloneOpts.dstWsID); err != nil {
+		return clonePlan{}, err
+	}
+	return plan, nil
+}
+
+// write lists the configuration pushed by the clone to out
+func (p clonePlan) write(out io.Writer) {
+	fmt.Fprintf(out, "Clone workspace %s of environment %s from backup %s to workspace %s of environment %s:\n",
+		cloneOpts.srcWsID, cloneOpts.srcEnvID, cloneOpts.srcTag, cloneOpts.dstWsID, cloneOpts.dstEnvID)
+	for _, template := range p.identityTemplates {
+		fmt.Fprintf(out, "  identity template %s\n", template.id)
+	}
+	for _, template := range p.assetTemplates {
+		fmt.Fprintf(out, "  asset template %s\n", template.id)
+	}
+	for _, app := range p.apps {
+		fmt.Fprintf(out, "  application %s\n", app.app.Name)
+	}
+	for _, policy := range p.policies {
+		fmt.Fprintf(out, "  policy %s/%s\n", filepath.Base(filepath.Dir(policy.path)), filepath.Base(policy.path))
+	}
+}
+
+// push creates or updates the configuration in the target workspace. Policies are imported into
+// the target applications, which are found by name or created by pushApplications.
+func (p clonePlan) push(ctx context.Context) error {
+	if err := pushTemplates(ctx, "identity templates", p.identityTemplates, plainIDService.ImportIdentityTemplate); err != nil {
+		return err
+	}
+	if err := pushTemplates(ctx, "asset templates", p.assetTemplates, plainIDService.ImportAssetTemplate); err != nil {
+		return err
+	}
+	appIDs, err := pushApplications(ctx, p.apps)
+	if err != nil {
+		return err
+	}
+	return pushPolicies(ctx, pushedPolicies(p.policies, appIDs))
+}
+
+// pushTemplates imports the templates into the target environment one by one. A failed template
+// does not stop the others, all failures are returned together.
+func pushTemplates(ctx context.Context, kind string, templates []cloneTemplate, push func(ctx context.Context, envID, id, content string) error) error {
+	var errs []error
+	for _, template := range templates {
+		content, err := os.ReadFile(template.path)
+		if err == nil {
+			err = push(ctx, cloneOpts.dstEnvID, template.id, string(content))
+		}
+		if err != nil {
+			log.Error().Err(err).Str("envID", cloneOpts.dstEnvID).Msgf("Failed to push %s %s to PlainID", kind, template.id)
+			errs = append(errs, fmt.Errorf("%s: %w", template.id, err))
+		}
+	}
+
+	log.Info().Msgf("%d %s pushed to PlainID, %d failed", len(templates)-len(errs), kind, len(errs))
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to push %d of %d %s: %w", len(errs), len(templates), kind, errors.Join(errs...))
+	}
+	return nil
+}
+
+func init() {
+	cloneCmd.Flags().StringVar(&cloneOpts.srcTag, "src-tag", "", "Tag of the backup to clone from")
+	cloneCmd.Flags().StringVar(&cloneOpts.srcEnvID, "src-env-id", "", "Environment ID of the workspace in the backup")
+	cloneCmd.Flags().StringVar(&cloneOpts.srcWsID, "src-ws-id", "", "Workspace ID in the backup")
+	cloneCmd.Flags().StringVar(&cloneOpts.dstEnvID, "dst-env-id", "", "Environment ID to push the configuration to")
+	cloneCmd.Flags().StringVar(&cloneOpts.dstWsID, "dst-ws-id", "", "Workspace ID to push the configuration to")
+	cloneCmd.Flags().BoolVarP(&cloneOpts.yes, "yes", "y", false, "Overwrite the target configuration without asking for confirmation")
+	for _, name := range []string{"src-tag", "src-env-id", "src-ws-id", "dst-env-id", "dst-ws-id"} {
+		_ = cloneCmd.MarkFlagRequired(name)
+	}
+}
@@ -0,0 +1,1688 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/plainid"
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog"
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/suite"
+)
+
+// CmdTestSuite runs commands against a fake PlainID API and a local bare git remote
+type CmdTestSuite struct {
+	suite.Suite
+	plainIDServer *httptest.Server
+	remoteDir     string
+	remote        *git.Repository
+}
+
+func TestCmdSuite(t *testing.T) {
+	suite.Run(t, new(CmdTestSuite))
+}
+
+// SetupTest prepares a fresh PlainID API, git remote and configuration for every test
+func (s *CmdTestSuite) SetupTest() {
+	s.plainIDServer = httptest.NewServer(fakePlainIDAPI())
+
+	s.remoteDir = s.T().TempDir()
+	remote, err := git.PlainInit(s.remoteDir, true)
+	s.Require().NoError(err)
+	s.Require().NoError(remote.Storer.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+	s.remote = remote
+
+	cfg = &config.Config{
+		Git: config.GitConfig{
+			Repo:                s.remoteDir,
+			Branch:              "main",
+			DeleteTempOnSuccess: true,
+		},
+		PlainID: config.PlainIDConfig{
+			BaseURL:      s.plainIDServer.URL,
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+			Envs: []config.Environment{{
+				ID:         "env1",
+				Name:       "Env1",
+				Workspaces: []config.Workspace{{ID: "ws1", Name: "WS1"}},
+				Identities: []string{"User"},
+			}},
+		},
+	}
+	plainIDService = plainid.NewService(*cfg)
+	gitAuth = nil
+
+	summaryOutput = io.Discard
+
+	backupCmd.SetContext(context.Background())
+	restoreCmd.SetContext(context.Background())
+	cloneCmd.SetContext(context.Background())
+}
+
+// TearDownTest stops the fake PlainID API and resets command state
+func (s *CmdTestSuite) TearDownTest() {
+	s.plainIDServer.Close()
+	cfg = nil
+	plainIDService = nil
+	restoreTag, restoreTargetDir, restoreEnvID, restoreWsID = "", "", "", ""
+	restorePush, restoreAppsOnly = false, false
+	backupBundle = ""
+	backupTagMessageFile = ""
+	backupCommitPerEnv = false
+	backupForce = false
+	backupContinueOnError = false
+	backupIncludeApps, backupExcludeApps = nil, nil
+	plainIDInstances = nil
+	summaryOutput = os.Stderr
+}
+
+// backupTags returns the tags pushed to the remote
+func (s *CmdTestSuite) backupTags() []string {
+	iter, err := s.remote.Tags()
+	s.Require().NoError(err)
+
+	var tags []string
+	s.Require().NoError(iter.ForEach(func(ref *plumbing.Reference) error {
+		tags = append(tags, ref.Name().Short())
+		return nil
+	}))
+	return tags
+}
+
+func (s *CmdTestSuite) TestBackupThenRestore() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tags := s.backupTags()
+	s.Require().Len(tags, 1)
+
+	restoreTag = tags[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	expected := map[string]string{
+		"Env1_env1/identity-template-User.json":  `{"id":"User"}`,
+		"Env1_env1/attribute-schemas.json":       `{"data":[{"name":"department","type":"STRING"}]}`,
+		"Env1_env1/environment-settings.json":    `{"enforcementMode":"ENFORCE"}`,
+		"Env1_env1/WS1/asset-template_0.json":    `{"id":"at1"}`,
+		"Env1_env1/WS1/App1/policy_Pol1.rego":    "package policy1",
+		"Env1_env1/WS1/App1/api-mapper-set.json": `{"mappers":[]}`,
+		"Env1_env1/WS1/ws-api-mapper-set.json":   `{"mappers":[{"id":"m1"}]}`,
+		"Env1_env1/WS1/App1/application.json":    "",
+		"Env1_env1/WS1/groups/Admins_Ops.json":   `{"id":"g1","name":"Admins/Ops","description":"","authWsId":"ws1"}`,
+		"Env1_env1/WS1/workspace-metadata.json":  `{"id":"ws1","name":"WS1","description":"Main","type":"AUTHZ","ownerId":"u1"}`,
+		"Env1_env1/WS1/applications-index.json":  `[{"id":"app1","name":"App1","dir":"App1"}]`,
+	}
+	for path, content := range expected {
+		data, err := os.ReadFile(filepath.Join(restoreTargetDir, path))
+		s.Require().NoError(err, path)
+		if content != "" {
+			s.Assert().Equal(content, string(data), path)
+		}
+	}
+
+	snapshot, err := os.ReadFile(filepath.Join(restoreTargetDir, configSnapshotFileName))
+	s.Require().NoError(err)
+	s.Assert().Contains(string(snapshot), "client-secret: '***'")
+	s.Assert().NotContains(string(snapshot), "client-secret: client-secret")
+
+	script, err := os.ReadFile(filepath.Join(restoreTargetDir, restoreCommandsFileName))
+	s.Require().NoError(err)
+	s.Assert().Contains(string(script), fmt.Sprintf("git-backup restore --dry-run --tag '%s' --target-dir 'restore/%s/Env1_env1/WS1' --env-id 'env1' --ws-id 'ws1'\n", restoreTag, restoreTag))
+	s.Assert().Contains(string(script), fmt.Sprintf("git-backup restore --tag '%s' --target-dir 'restore/%s/Env1_env1/WS1' --env-id 'env1' --ws-id 'ws1'\n", restoreTag, restoreTag))
+
+	s.Assert().NoDirExists(filepath.Join(restoreTargetDir, git.GitDirName), "git metadata should not be restored")
+}
+
+func (s *CmdTestSuite) TestBackupInstances() {
+	// Both instances are served by the fake PlainID API
+	for _, alias := range []string{"retail", "banking"} {
+		plainIDInstances = append(plainIDInstances, plainIDInstance{alias: alias, plainID: cfg.PlainID, service: plainIDService})
+	}
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tags := s.backupTags()
+	s.Require().Len(tags, 1)
+	restoreTag = tags[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	for _, alias := range []string{"retail", "banking"} {
+		s.Assert().FileExists(filepath.Join(restoreTargetDir, alias, "Env1_env1/WS1/App1/policy_Pol1.rego"))
+	}
+	s.Assert().NoDirExists(filepath.Join(restoreTargetDir, "Env1_env1"), "instances are backed up in their own directory")
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, configSnapshotFileName))
+
+	script, err := os.ReadFile(filepath.Join(restoreTargetDir, restoreCommandsFileName))
+	s.Require().NoError(err)
+	s.Assert().Contains(string(script), fmt.Sprintf("git-backup restore --tag '%s' --target-dir 'restore/%s/banking/Env1_env1/WS1' --env-id 'env1' --ws-id 'ws1' --instance 'banking'\n", restoreTag, restoreTag))
+
+	// A filtered restore copies the workspace of the instance it is found in
+	restoreTargetDir = s.T().TempDir()
+	restoreEnvID, restoreWsID = "env1", "ws1"
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "retail", "App1/policy_Pol1.rego"))
+}
+
+func (s *CmdTestSuite) TestSelectInstances() {
+	instances := []config.PlainIDInstanceConfig{{Alias: "retail"}, {Alias: "banking"}}
+
+	selected, err := selectInstances(instances, "")
+	s.Require().NoError(err)
+	s.Assert().Equal(instances, selected)
+
+	selected, err = selectInstances(instances, "banking")
+	s.Require().NoError(err)
+	s.Assert().Equal([]config.PlainIDInstanceConfig{{Alias: "banking"}}, selected)
+
+	_, err = selectInstances(instances, "insurance")
+	s.Assert().EqualError(err, "instance insurance not found in the configuration")
+}
+
+// fakePlainIDAPI serves a single environment with one workspace, application and policy
+func fakePlainIDAPI() http.Handler {
+	responses := map[string]string{
+		"/api/1.0/api-key/token":                            `{"access_token":"test-token","token_type":"bearer","expires_in":3600}`,
+		"/api/1.0/identity-templates/env1/User":             `{"id":"User"}`,
+		"/api/1.0/attribute-schemas/env1":                   `{"data":[{"name":"department","type":"STRING"}]}`,
+		"/env-mgmt/environment/env1/settings":               `{"enforcementMode":"ENFORCE"}`,
+		"/env-mgmt/environment":                             `{"data":[{"id":"env1","name":"Env1"}]}`,
+		"/env-mgmt/1.0-int.1/authorization-workspaces/env1": `{"data":[{"id":"ws1","name":"WS1","description":"Main","type":"AUTHZ","ownerId":"u1"}]}`,
+		"/api/1.0/paa-groups/env1":                          `{"data":[]}`,
+		"/policy-mgmt/1.0/applications/env1":                `{"data":[{"id":"app1","name":"App1","authWsId":"ws1"}],"total":1}`,
+		"/api/1.0/applications/env1/app1":                   `{"data":{"applicationId":"app1","displayName":"App1"}}`,
+		"/internal-assets/4.0/asset-types":                  `{"data":[{"id":"1","externalId":"at1"}]}`,
+		"/api/1.0/asset-templates/env1/at1":                 `{"id":"at1"}`,
+		"/policy-mgmt/1.0/policies/env1":                    `{"data":[{"id":"pol1","name":"Pol1","state":"Active"}]}`,
+		"/api/2.0/policies/env1":                            "package policy1",
+		"/api/1.0/api-mapper-sets/env1/app1":                `{"mappers":[]}`,
+		"/api/1.0/api-mapper-sets/env1/workspace/ws1":       `{"mappers":[{"id":"m1"}]}`,
+		"/policy-mgmt/1.0/groups/env1":                      `{"data":[{"id":"g1","name":"Admins/Ops","authWsId":"ws1"}],"meta":{"total":1}}`,
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	})
+}
+
+func (s *CmdTestSuite) TestBackupBundle() {
+	backupBundle = filepath.Join(s.T().TempDir(), "backup.bundle")
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	data, err := os.ReadFile(backupBundle)
+	s.Require().NoError(err)
+
+	header, _, found := strings.Cut(string(data), "\n\n")
+	s.Require().True(found, "bundle header should end with an empty line")
+	s.Assert().True(strings.HasPrefix(header, "# v2 git bundle\n"))
+	s.Assert().Contains(header, " HEAD\n")
+	s.Assert().Contains(header, " refs/heads/main\n")
+	s.Assert().Contains(header, " refs/tags/"+s.backupTags()[0])
+	s.Assert().NotContains(header, "\n-", "bundle should not have prerequisites")
+}
+
+func (s *CmdTestSuite) TestVerifyWrittenFile() {
+	path := filepath.Join(s.T().TempDir(), "policy.rego")
+	s.Require().NoError(os.WriteFile(path, []byte("package policy"), 0600))
+
+	s.Assert().NoError(verifyWrittenFile(path, []byte("package policy")))
+	s.Assert().ErrorContains(verifyWrittenFile(path, []byte("package policy1")), "written content does not match")
+}
+
+func (s *CmdTestSuite) TestValidateBackupContent() {
+	dir := s.T().TempDir()
+	s.Assert().NoError(validateBackupContent(filepath.Join(dir, "application.json"), []byte(`{"id":"app1"}`)))
+	s.Assert().NoError(validateBackupContent(filepath.Join(dir, "policy_Pol1.rego"), []byte("package policy1")))
+	s.Assert().NoError(validateBackupContent(filepath.Join(dir, "restore-commands.sh"), []byte("#!/bin/sh")))
+
+	truncated := `{"data":[` + strings.Repeat(`{"id":"app1"},`, 50)
+	err := validateBackupContent(filepath.Join(dir, "application.json"), []byte(truncated))
+	s.Require().ErrorContains(err, "invalid JSON content for "+filepath.Join(dir, "application.json"))
+	s.Assert().Contains(err.Error(), fmt.Sprintf("%q", truncated[:maxContentExcerpt]), "the error should quote the start of the content")
+	s.Assert().NotContains(err.Error(), truncated[:maxContentExcerpt+1])
+
+	s.Assert().ErrorContains(validateBackupContent(filepath.Join(dir, "policy_Pol1.rego"), []byte(" \n")), "empty policy content")
+	s.Assert().ErrorContains(validateBackupContent(filepath.Join(dir, "policy_Pol1.rego"), []byte("allow { true }")), "no package declaration")
+
+	// Invalid content is not written
+	path := filepath.Join(dir, "asset-template_0.json")
+	s.Require().Error(writeBackupFile(path, []byte(`{"id":`)))
+	s.Assert().NoFileExists(path)
+}
+
+func (s *CmdTestSuite) TestFilterApplications() {
+	apps := []plainid.Application{
+		{ID: "app1", Name: "Payments"},
+		{ID: "app2", Name: "Payment Gateway"},
+		{ID: "app3", Name: "Orders"},
+	}
+	names := func(apps []plainid.Application) []string {
+		var names []string
+		for _, app := range apps {
+			names = append(names, app.Name)
+		}
+		return names
+	}
+
+	kept, skipped := filterApplications(apps)
+	s.Assert().Equal(apps, kept)
+	s.Assert().Empty(skipped)
+
+	backupIncludeApps = []string{"Payment*", "app3"}
+	backupExcludeApps = []string{"*Gateway"}
+	kept, skipped = filterApplications(apps)
+	s.Assert().Equal([]string{"Payments", "Orders"}, names(kept))
+	s.Assert().Equal([]string{"Payment Gateway"}, names(skipped))
+
+	backupIncludeApps, backupExcludeApps = nil, []string{"app1"}
+	kept, _ = filterApplications(apps)
+	s.Assert().Equal([]string{"Payment Gateway", "Orders"}, names(kept))
+
+	backupExcludeApps = []string{"[Pay"}
+	s.Assert().ErrorContains(backupCmd.RunE(backupCmd, nil), `invalid application pattern "[Pay"`)
+}
+
+func (s *CmdTestSuite) TestBackupKeepsFilteredApplications() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	backupExcludeApps = []string{"App1"}
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	for _, path := range []string{"App1/application.json", "App1/policy_Pol1.rego", "App1/api-mapper-set.json"} {
+		s.Assert().FileExists(filepath.Join(restoreTargetDir, "Env1_env1/WS1", path))
+	}
+	index, err := readApplicationsIndex(filepath.Join(restoreTargetDir, "Env1_env1/WS1"))
+	s.Require().NoError(err)
+	s.Assert().Equal([]appIndexEntry{{ID: "app1", Name: "App1", Dir: "App1"}}, index)
+}
+
+func (s *CmdTestSuite) TestBackupNoGroupsKeepsPreviousGroups() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer, backupNoGroups = "", false }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	backupNoGroups = true
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "Env1_env1/WS1/groups/Admins_Ops.json"))
+}
+
+func (s *CmdTestSuite) TestBackupNoAttributeSchemasKeepsPreviousSchemas() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer, backupNoSchemas = "", false }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	backupNoSchemas = true
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "Env1_env1/attribute-schemas.json"))
+}
+
+func (s *CmdTestSuite) TestBackupNoEnvSettingsKeepsPreviousSettings() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer, backupNoEnvSettings = "", false }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	backupNoEnvSettings = true
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "Env1_env1/environment-settings.json"))
+}
+
+func (s *CmdTestSuite) TestSetupPlainIDKeepsConfiguredEnvName() {
+	cfg.PlainID.Envs[0].Name = "Production"
+	s.Require().NoError(setupPlainID(context.Background(), "test"))
+	s.Require().Len(cfg.PlainID.Envs, 1)
+	s.Assert().Equal("Production", cfg.PlainID.Envs[0].Name, "the configured name should not be overwritten")
+	s.Assert().Equal("WS1", cfg.PlainID.Envs[0].Workspaces[0].Name)
+
+	// Without a configured name, the PlainID one is used
+	cfg.PlainID.Envs[0].Name = ""
+	s.Require().NoError(setupPlainID(context.Background(), "test"))
+	s.Assert().Equal("Env1", cfg.PlainID.Envs[0].Name)
+}
+
+func (s *CmdTestSuite) TestWorkspaceConcurrency() {
+	defer func(concurrency int) { backupConcurrency = concurrency }(backupConcurrency)
+	backupConcurrency = 8
+	env := &config.Environment{
+		ID:         "env1",
+		Workspaces: []config.Workspace{{ID: "ws1", MaxConcurrency: 2}, {ID: "ws2"}},
+	}
+
+	s.Assert().Equal(2, workspaceConcurrency(env, "ws1"), "max-concurrency should override --concurrency")
+	s.Assert().Equal(8, workspaceConcurrency(env, "ws2"), "--concurrency should be the default")
+	s.Assert().Equal(8, workspaceConcurrency(env, "ws3"))
+}
+
+func (s *CmdTestSuite) TestMergeApplicationsIndex() {
+	cfg.PlainID.AppDirStrategy = config.AppDirStrategyIDName
+	previous := []appIndexEntry{
+		{ID: "app1", Name: "Old Name", Dir: "app1_Old Name"},
+		{ID: "app0", Name: "Removed", Dir: "app0_Removed"},
+	}
+	apps := []plainid.Application{{ID: "app2", Name: "New"}, {ID: "app1", Name: "Renamed"}}
+
+	s.Assert().Equal([]appIndexEntry{
+		{ID: "app0", Name: "Removed", Dir: "app0_Removed", Deleted: true},
+		{ID: "app1", Name: "Renamed", Dir: "app1_Renamed"},
+		{ID: "app2", Name: "New", Dir: "app2_New"},
+	}, mergeApplicationsIndex(previous, apps, nil))
+
+	// Skipped applications keep their previous entry
+	skipped := []plainid.Application{{ID: "app0", Name: "Removed"}}
+	s.Assert().Equal([]appIndexEntry{
+		{ID: "app0", Name: "Removed", Dir: "app0_Removed"},
+		{ID: "app1", Name: "Renamed", Dir: "app1_Renamed"},
+		{ID: "app2", Name: "New", Dir: "app2_New"},
+	}, mergeApplicationsIndex(previous, apps, skipped))
+}
+
+func (s *CmdTestSuite) TestBackupTagMessageFile() {
+	backupTagMessageFile = "-"
+	backupCmd.SetIn(strings.NewReader("CHG-1234 approved by ops\n"))
+	defer backupCmd.SetIn(nil)
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tag, err := s.remote.TagObject(s.mustTagHash(s.backupTags()[0]))
+	s.Require().NoError(err)
+	s.Assert().Equal("CHG-1234 approved by ops\n", tag.Message)
+
+	message, err := readTagMessage(nil, filepath.Join(s.T().TempDir(), "missing.txt"))
+	s.Require().NoError(err)
+	s.Assert().Empty(message, "missing file should fall back to the generated message")
+}
+
+func (s *CmdTestSuite) TestBackupCommitPerEnv() {
+	backupCommitPerEnv = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tag, err := s.remote.TagObject(s.mustTagHash(s.backupTags()[0]))
+	s.Require().NoError(err)
+	last, err := tag.Commit()
+	s.Require().NoError(err)
+	s.Assert().True(strings.HasPrefix(last.Message, "Backup PlainID configuration for:"), "tag should point to the last commit")
+
+	s.Require().Equal(1, last.NumParents())
+	envCommit, err := last.Parent(0)
+	s.Require().NoError(err)
+	s.Assert().Equal("Backup env:env1 (Env1)", envCommit.Message)
+	_, err = envCommit.File("Env1_env1/WS1/App1/application.json")
+	s.Assert().NoError(err, "environment commit should contain the environment")
+	_, err = envCommit.File(configSnapshotFileName)
+	s.Assert().Error(err, "repository files should only be in the last commit")
+}
+
+func (s *CmdTestSuite) TestWriteIfChanged() {
+	path := filepath.Join(s.T().TempDir(), "application.json")
+	written, err := writeIfChanged(path, []byte(`{"id":"app1"}`))
+	s.Require().NoError(err)
+	s.Assert().True(written, "missing file should be written")
+
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	s.Require().NoError(os.Chtimes(path, modTime, modTime))
+
+	written, err = writeIfChanged(path, []byte(`{"id":"app1"}`))
+	s.Require().NoError(err)
+	s.Assert().False(written, "unchanged file should not be written")
+	info, err := os.Stat(path)
+	s.Require().NoError(err)
+	s.Assert().True(info.ModTime().Equal(modTime), "unchanged file should keep its modification time")
+
+	written, err = writeIfChanged(path, []byte(`{"id":"app2"}`))
+	s.Require().NoError(err)
+	s.Assert().True(written, "changed file should be written")
+	data, err := os.ReadFile(path)
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"id":"app2"}`, string(data))
+}
+
+func (s *CmdTestSuite) TestBackupRewritesChangedFilesOnly() {
+	dir := s.T().TempDir()
+	writtenFiles = newFileSet()
+	_, err := backupInstance(context.Background(), &backupStats{}, nil, dir, "", time.Now())
+	s.Require().NoError(err)
+
+	// The files of an application deleted from PlainID since are left from the previous backup
+	staleDir := filepath.Join(dir, "Env1_env1/WS1/App2")
+	s.Require().NoError(os.MkdirAll(staleDir, 0755))
+	s.Require().NoError(os.WriteFile(filepath.Join(staleDir, "policy_Pol2.rego"), []byte("package policy2"), 0600))
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	s.Require().NoError(filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		return os.Chtimes(path, modTime, modTime)
+	}))
+
+	writtenFiles = newFileSet()
+	_, err = backupInstance(context.Background(), &backupStats{}, nil, dir, "", time.Now())
+	s.Require().NoError(err)
+	for _, path := range []string{"Env1_env1/WS1/App1/policy_Pol1.rego", "Env1_env1/identity-template-User.json"} {
+		info, err := os.Stat(filepath.Join(dir, path))
+		s.Require().NoError(err)
+		s.Assert().True(info.ModTime().Equal(modTime), "unchanged %s should not be rewritten", path)
+	}
+	s.Assert().NoDirExists(staleDir)
+}
+
+func (s *CmdTestSuite) TestBackupKeepsDeletedApplications() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// App1 is deleted from PlainID before the next backup
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/policy-mgmt/1.0/applications/env1" {
+			_, _ = w.Write([]byte(`{"data":[],"total":0}`))
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	index, err := readApplicationsIndex(filepath.Join(restoreTargetDir, "Env1_env1/WS1"))
+	s.Require().NoError(err)
+	s.Assert().Equal([]appIndexEntry{{ID: "app1", Name: "App1", Dir: "App1", Deleted: true}}, index)
+	s.Assert().NoDirExists(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1"))
+}
+
+func (s *CmdTestSuite) TestBackupIndentsJSON() {
+	cfg.Git.JSONIndent = "  "
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	data, err := os.ReadFile(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1/api-mapper-set.json"))
+	s.Require().NoError(err)
+	s.Assert().Equal("{\n  \"mappers\": []\n}", string(data))
+
+	data, err = os.ReadFile(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1/policy_Pol1.rego"))
+	s.Require().NoError(err)
+	s.Assert().Equal("package policy1", string(data), "policies should not be formatted")
+}
+
+func (s *CmdTestSuite) TestRestorePreview() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	summary, err := summarizeRestore(restoreTargetDir)
+	s.Require().NoError(err)
+	s.Assert().Equal(1, summary.Environments)
+	s.Assert().Equal(1, summary.Workspaces)
+	s.Assert().Equal(1, summary.Applications)
+	s.Assert().Equal(1, summary.Policies)
+	s.Assert().Equal([]string{"Env1_env1", "    WS1", "        App1"}, summary.Tree)
+
+	var out strings.Builder
+	confirmed, err := confirmRestore(strings.NewReader("y\n"), &out, summary)
+	s.Require().NoError(err)
+	s.Assert().True(confirmed)
+	s.Assert().Contains(out.String(), "Restore 1 environments, 1 workspaces, 1 applications? [y/N]")
+
+	confirmed, err = confirmRestore(strings.NewReader("\n"), &out, summary)
+	s.Require().NoError(err)
+	s.Assert().False(confirmed, "restore should be declined by default")
+}
+
+func (s *CmdTestSuite) TestInteractiveRestore() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	tag := s.backupTags()[0]
+
+	var out, prompts strings.Builder
+	restoreCmd.SetIn(strings.NewReader("7\n1\ny\n"))
+	restoreCmd.SetOut(&out)
+	restoreCmd.SetErr(&prompts)
+	defer func() {
+		restoreCmd.SetIn(nil)
+		restoreCmd.SetOut(nil)
+		restoreCmd.SetErr(nil)
+	}()
+
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().Equal(tag+"\n", out.String(), "only the selected tag should be written to stdout")
+	s.Assert().Contains(prompts.String(), "1. "+tag)
+	s.Assert().Contains(prompts.String(), `Invalid selection "7"`)
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1/application.json"))
+
+	// Declining the preview copies nothing
+	restoreCmd.SetIn(strings.NewReader("1\nn\n"))
+	restoreTargetDir = filepath.Join(s.T().TempDir(), "declined")
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().NoDirExists(restoreTargetDir)
+}
+
+func (s *CmdTestSuite) TestBackupToolSignature() {
+	cfg.Meta = config.MetaConfig{ToolName: "Acme Backup", ToolEmail: "backup@acme.example"}
+	// Version tags, both backups may run within the same second
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tag, err := s.remote.TagObject(s.mustTagHash(s.backupTags()[0]))
+	s.Require().NoError(err)
+	s.Assert().Equal("Acme Backup", tag.Tagger.Name)
+	s.Assert().Equal("backup@acme.example", tag.Tagger.Email)
+
+	commit, err := tag.Commit()
+	s.Require().NoError(err)
+	s.Assert().Equal("Acme Backup", commit.Author.Name)
+
+	// The commit author takes precedence over the tool identity
+	cfg.Git.CommitAuthorName, cfg.Git.CommitAuthorEmail = "Backup CI", "backup-ci@acme.example"
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	tag, err = s.remote.TagObject(s.mustTagHash("v0.2.0"))
+	s.Require().NoError(err)
+	s.Assert().Equal("Backup CI", tag.Tagger.Name)
+	s.Assert().Equal("backup-ci@acme.example", tag.Tagger.Email)
+	commit, err = tag.Commit()
+	s.Require().NoError(err)
+	s.Assert().Equal("Backup CI <backup-ci@acme.example>", commit.Author.Name+" <"+commit.Author.Email+">")
+	s.Assert().Equal("Acme Backup/"+Version, userAgent(), "the user agent keeps the tool name")
+}
+
+// mustTagHash returns the hash the tag reference points to
+func (s *CmdTestSuite) mustTagHash(name string) plumbing.Hash {
+	ref, err := s.remote.Tag(name)
+	s.Require().NoError(err)
+	return ref.Hash()
+}
+
+func (s *CmdTestSuite) TestBackupSummary() {
+	var out strings.Builder
+	summaryOutput = &out
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var summary map[string]any
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &summary))
+	s.Assert().Equal("backup_complete", summary["event"])
+	s.Assert().Equal("success", summary["status"])
+	s.Assert().Equal(s.backupTags()[0], summary["tag"])
+	s.Assert().EqualValues(1, summary["env_count"])
+	s.Assert().EqualValues(1, summary["ws_count"])
+	s.Assert().EqualValues(1, summary["app_count"])
+	s.Assert().EqualValues(1, summary["policy_count"])
+	s.Assert().Contains(summary, "error")
+	s.Assert().Nil(summary["error"])
+}
+
+func (s *CmdTestSuite) TestBackupLabels() {
+	var out strings.Builder
+	summaryOutput = &out
+	backupLabels = []string{"team=ops", "ticket=CHG-1"}
+	defer func() { backupLabels = nil }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var summary backupSummary
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &summary))
+	s.Assert().Equal(backupLabels, summary.Labels)
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	manifest, err := readManifest(restoreTargetDir)
+	s.Require().NoError(err)
+	s.Assert().Equal(backupLabels, manifest.Labels)
+
+	data, err := os.ReadFile(filepath.Join(restoreTargetDir, backupHistoryFileName))
+	s.Require().NoError(err)
+	var history []backupRecord
+	s.Require().NoError(json.Unmarshal(data, &history))
+	s.Require().Len(history, 1)
+	s.Assert().Equal(backupLabels, history[0].Labels)
+}
+
+func (s *CmdTestSuite) TestBackupHistory() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	data, err := os.ReadFile(filepath.Join(restoreTargetDir, backupHistoryFileName))
+	s.Require().NoError(err)
+	var history []backupRecord
+	s.Require().NoError(json.Unmarshal(data, &history))
+	s.Require().Len(history, 1)
+	s.Assert().Equal(restoreTag, history[0].Tag)
+	s.Assert().Equal([]string{"env1"}, history[0].Environments)
+	s.Assert().EqualValues(1, history[0].PolicyCount)
+	s.Assert().Positive(history[0].FileCount)
+
+	// The history keeps the most recent backups first
+	dir := s.T().TempDir()
+	for i := range maxBackupHistory + 1 {
+		s.Require().NoError(updateBackupHistory(dir, backupRecord{Tag: fmt.Sprintf("v0.0.%d", i)}))
+	}
+	data, err = os.ReadFile(filepath.Join(dir, backupHistoryFileName))
+	s.Require().NoError(err)
+	history = nil
+	s.Require().NoError(json.Unmarshal(data, &history))
+	s.Require().Len(history, maxBackupHistory)
+	s.Assert().Equal(fmt.Sprintf("v0.0.%d", maxBackupHistory), history[0].Tag)
+	s.Assert().Equal("v0.0.1", history[maxBackupHistory-1].Tag)
+}
+
+// advanceRemote pushes a commit to the remote branch, as a concurrent backup would
+func (s *CmdTestSuite) advanceRemote() {
+	repo, err := git.PlainInit(s.T().TempDir(), false)
+	s.Require().NoError(err)
+	_, err = repo.CreateRemote(&gitconfig.RemoteConfig{Name: "origin", URLs: []string{s.remoteDir}})
+	s.Require().NoError(err)
+
+	var parents []plumbing.Hash
+	if ref, err := s.remote.Reference(plumbing.NewBranchReferenceName("main"), true); err == nil {
+		s.Require().NoError(repo.Fetch(&git.FetchOptions{}))
+		parents = append(parents, ref.Hash())
+	}
+
+	s.Require().NoError(repo.Storer.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+	wt, err := repo.Worktree()
+	s.Require().NoError(err)
+	_, err = wt.Commit("Concurrent backup", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            toolSignature(),
+		Parents:           parents,
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(repo.Push(&git.PushOptions{RefSpecs: []gitconfig.RefSpec{"refs/heads/main:refs/heads/main"}}))
+}
+
+// concurrentBackupAPI serves the fake PlainID API and advances the remote during the first backup
+func (s *CmdTestSuite) concurrentBackupAPI() {
+	advanced := false
+	api := fakePlainIDAPI()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !advanced && r.URL.Path == "/api/1.0/api-mapper-sets/env1/app1" {
+			advanced = true
+			s.advanceRemote()
+		}
+		api.ServeHTTP(w, r)
+	}))
+	s.T().Cleanup(server.Close)
+
+	cfg.PlainID.BaseURL = server.URL
+	plainIDService = plainid.NewService(*cfg)
+}
+
+func (s *CmdTestSuite) TestBackupPushRejected() {
+	s.advanceRemote()
+	s.concurrentBackupAPI()
+
+	err := backupCmd.RunE(backupCmd, nil)
+	s.Require().ErrorIs(err, errPushRejected)
+	s.Assert().Contains(err.Error(), "Another backup may have run concurrently")
+}
+
+func (s *CmdTestSuite) TestBackupRetryOnConflict() {
+	s.advanceRemote()
+	s.concurrentBackupAPI()
+
+	backupRetryOnConflict = 1
+	defer func() { backupRetryOnConflict = 0 }()
+
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+}
+
+func (s *CmdTestSuite) TestBackupSkipsUnchanged() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	head, err := s.remote.Head()
+	s.Require().NoError(err)
+
+	// Nothing changed in PlainID, so the second backup is neither committed nor tagged
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	s.Assert().Equal([]string{"v0.1.0"}, s.backupTags())
+	unchangedHead, err := s.remote.Head()
+	s.Require().NoError(err)
+	s.Assert().Equal(head.Hash(), unchangedHead.Hash())
+
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	s.Assert().ElementsMatch([]string{"v0.1.0", "v0.2.0"}, s.backupTags())
+	forcedHead, err := s.remote.Head()
+	s.Require().NoError(err)
+	s.Assert().NotEqual(head.Hash(), forcedHead.Hash())
+}
+
+func (s *CmdTestSuite) TestBackupAuditLog() {
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/1.0/audit-logs/env1" {
+			_, _ = w.Write([]byte(`{"data":[{"decision":"PERMIT"}]}`))
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+	cfg.PlainID.BackupAuditLog = true
+	cfg.PlainID.AuditLogWindow = time.Hour
+
+	// Every backup adds the logs of its window, those of the previous backups are kept
+	dir := s.T().TempDir()
+	first := time.Date(2025, time.March, 5, 7, 30, 0, 0, time.UTC)
+	for _, backupTime := range []time.Time{first, first.Add(time.Hour)} {
+		writtenFiles = newFileSet()
+		_, err := backupInstance(context.Background(), &backupStats{}, nil, dir, "", backupTime)
+		s.Require().NoError(err)
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "Env1_env1", "audit-log*.json"))
+	s.Require().NoError(err)
+	s.Assert().Equal([]string{
+		filepath.Join(dir, "Env1_env1", "audit-log-20250305-073000.json"),
+		filepath.Join(dir, "Env1_env1", "audit-log-20250305-083000.json"),
+	}, files)
+	data, err := os.ReadFile(files[1])
+	s.Require().NoError(err)
+	s.Assert().JSONEq(`{"data":[{"decision":"PERMIT"}]}`, string(data))
+
+	// The logs are kept when they are no longer backed up
+	cfg.PlainID.BackupAuditLog = false
+	writtenFiles = newFileSet()
+	_, err = backupInstance(context.Background(), &backupStats{}, nil, dir, "", first.Add(2*time.Hour))
+	s.Require().NoError(err)
+	s.Assert().FileExists(files[0])
+	s.Assert().FileExists(files[1])
+}
+
+func (s *CmdTestSuite) TestBackupConditionalCache() {
+	var notModified atomic.Int32
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/2.0/policies/env1" {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+		}
+		api.ServeHTTP(w, r)
+	})
+	cfg.Git.TempDir = s.T().TempDir()
+	conditionalCache = plainid.NewConditionalCache()
+	defer func() { conditionalCache = nil }()
+	plainIDService = plainid.NewService(*cfg, plainid.WithConditionalCache(conditionalCache))
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// The responses are cached outside of the backup repository
+	cacheFiles, err := filepath.Glob(filepath.Join(cfg.Git.TempDir, "git-backup", "etag-cache-*.json"))
+	s.Require().NoError(err)
+	s.Assert().Len(cacheFiles, 1)
+	restoreTag = "v0.1.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().NoFileExists(filepath.Join(restoreTargetDir, conditionalCacheFileName))
+
+	// The next backup reuses the cached policy
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	s.Assert().EqualValues(1, notModified.Load())
+	restoreTag = "v0.2.0"
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	policy, err := os.ReadFile(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1/policy_Pol1.rego"))
+	s.Require().NoError(err)
+	s.Assert().Equal("package policy1", string(policy))
+}
+
+func (s *CmdTestSuite) TestBackupContinueOnError() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	cfg.PlainID.Envs[0].Workspaces = append(cfg.PlainID.Envs[0].Workspaces, config.Workspace{ID: "ws2", Name: "WS2"})
+	api := s.plainIDServer.Config.Handler
+	ws2Fails, policy := false, "package policy1"
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/1.0/api-mapper-sets/env1/workspace/ws2":
+			if ws2Fails {
+				http.Error(w, "unavailable", http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write([]byte(`{"mappers":[{"id":"m2"}]}`))
+		case "/api/2.0/policies/env1":
+			_, _ = w.Write([]byte(policy))
+		default:
+			api.ServeHTTP(w, r)
+		}
+	})
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// Without the flag the failing workspace fails the whole backup
+	ws2Fails, policy = true, "package policy2"
+	s.Require().ErrorContains(backupCmd.RunE(backupCmd, nil), "env:env1 ws:ws2")
+	s.Assert().Equal([]string{"v0.1.0"}, s.backupTags())
+
+	backupContinueOnError = true
+	err := backupCmd.RunE(backupCmd, nil)
+	s.Require().Error(err)
+	s.Assert().Equal("failed to back up 1 workspace(s): env:env1 ws:ws2", err.Error())
+	s.Assert().ElementsMatch([]string{"v0.1.0", "v0.2.0"}, s.backupTags())
+
+	tag, err := s.remote.TagObject(s.mustTagHash("v0.2.0"))
+	s.Require().NoError(err)
+	commit, err := tag.Commit()
+	s.Require().NoError(err)
+	s.Assert().Contains(commit.Message, " env:env1 ws:ws1 skipped: env:env1 ws:ws2")
+	file, err := commit.File("Env1_env1/WS1/App1/policy_Pol1.rego")
+	s.Require().NoError(err)
+	content, err := file.Contents()
+	s.Require().NoError(err)
+	s.Assert().Equal("package policy2", content, "the other workspaces should be backed up")
+	file, err = commit.File("Env1_env1/WS2/ws-api-mapper-set.json")
+	s.Require().NoError(err, "the skipped workspace should keep its previous backup")
+	content, err = file.Contents()
+	s.Require().NoError(err)
+	s.Assert().JSONEq(`{"mappers":[{"id":"m2"}]}`, content)
+}
+
+func (s *CmdTestSuite) TestBackupSemanticVersion() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	s.Assert().Equal([]string{"v0.1.0"}, s.backupTags())
+
+	// Nothing changed in PlainID between the backups
+	backupSemVer = repository.SemVerPatch
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	s.Assert().ElementsMatch([]string{"v0.1.0", "v0.1.1"}, s.backupTags())
+}
+
+func (s *CmdTestSuite) TestDiff() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// The policy changes in PlainID between the backups
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/2.0/policies/env1" {
+			_, _ = w.Write([]byte("package policy2"))
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+	backupSemVer = repository.SemVerPatch
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var out strings.Builder
+	diffCmd.SetOut(&out)
+	defer diffCmd.SetOut(nil)
+	diffTagA, diffTagB = "v0.1.0", "v0.1.1"
+	diffEnvID, diffWsID = "env1", "ws1"
+	defer func() { diffTagA, diffTagB, diffEnvID, diffWsID = "", "", "", "" }()
+
+	s.Require().NoError(diffCmd.RunE(diffCmd, nil))
+	s.Assert().Equal(`diff a/Env1_env1/WS1/App1/policy_Pol1.rego b/Env1_env1/WS1/App1/policy_Pol1.rego
+--- a/Env1_env1/WS1/App1/policy_Pol1.rego
++++ b/Env1_env1/WS1/App1/policy_Pol1.rego
+@@ -1 +1 @@
+-package policy1
++package policy2
+`, out.String())
+
+	// Without scope, the files of the whole backup are compared
+	out.Reset()
+	diffEnvID, diffWsID = "", ""
+	s.Require().NoError(diffCmd.RunE(diffCmd, nil))
+	s.Assert().Contains(out.String(), "+++ b/Env1_env1/WS1/App1/policy_Pol1.rego")
+	s.Assert().Contains(out.String(), "+++ b/"+backupHistoryFileName)
+	s.Assert().NotContains(out.String(), "diff a/Env1_env1/WS1/App1/application.json")
+}
+
+func (s *CmdTestSuite) TestAudit() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// A single backup has nothing to compare to
+	s.Assert().ErrorContains(auditCmd.RunE(auditCmd, nil), "at least two backups are needed, found 1")
+
+	// The policy changes in PlainID between the backups
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/2.0/policies/env1" {
+			_, _ = w.Write([]byte("package policy2\n\ndefault allow := false\n"))
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var out strings.Builder
+	auditCmd.SetOut(&out)
+	defer auditCmd.SetOut(nil)
+	defer func() { auditOpts = auditOptions{format: auditFormatText} }()
+
+	// The two most recent backups are compared by default
+	s.Require().NoError(auditCmd.RunE(auditCmd, nil))
+	s.Assert().Equal(`Changes from v0.1.0 to v0.2.0
+
+Env1_env1/WS1
+  modified: App1/policy_Pol1.rego: 3 lines added, 1 line removed
+`, out.String())
+
+	out.Reset()
+	auditOpts.tagA, auditOpts.tagB = "v0.2.0", "v0.2.0"
+	s.Require().NoError(auditCmd.RunE(auditCmd, nil))
+	s.Assert().Equal("No changes from v0.2.0 to v0.2.0\n", out.String())
+
+	out.Reset()
+	auditOpts.tagA, auditOpts.tagB = "v0.1.0", "v0.2.0"
+	auditOpts.envID, auditOpts.wsID = "env1", "ws1"
+	auditOpts.format = outputFormatJSON
+	s.Require().NoError(auditCmd.RunE(auditCmd, nil))
+	var changelog auditChangelog
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &changelog))
+	s.Assert().Equal(auditChangelog{
+		TagA: "v0.1.0",
+		TagB: "v0.2.0",
+		Sections: []auditSection{{
+			Environment: "Env1_env1",
+			Workspace:   "WS1",
+			Added:       []string{},
+			Removed:     []string{},
+			Modified:    []auditModifiedFile{{Path: "App1/policy_Pol1.rego", LinesAdded: 3, LinesRemoved: 1}},
+		}},
+	}, changelog)
+
+	auditOpts.wsID = "ws9"
+	s.Assert().ErrorContains(auditCmd.RunE(auditCmd, nil), "workspace 'ws9' of environment 'env1' is in neither backup")
+}
+
+func (s *CmdTestSuite) TestFindWorkspaceByNameOrID() {
+	cfg.PlainID.Envs = append(cfg.PlainID.Envs, config.Environment{
+		ID:         "env2",
+		Workspaces: []config.Workspace{{ID: "*"}},
+	})
+
+	// Exact ID match
+	ws := findWorkspaceByNameOrID("env1", "ws1", "WS1")
+	s.Require().NotNil(ws)
+	s.Assert().Equal(config.Workspace{ID: "ws1", Name: "WS1"}, *ws)
+
+	// Directory named after another workspace
+	s.Assert().Nil(findWorkspaceByNameOrID("env1", "ws1", "Other"))
+
+	// Workspace not found
+	s.Assert().Nil(findWorkspaceByNameOrID("env1", "ws2", "WS2"))
+	s.Assert().Nil(findWorkspaceByNameOrID("env3", "ws1", "WS1"))
+
+	// Wildcard environment fallback
+	ws = findWorkspaceByNameOrID("env2", "ws9", "WS9")
+	s.Require().NotNil(ws)
+	s.Assert().Equal(config.Workspace{ID: "ws9", Name: "WS9"}, *ws)
+}
+
+func (s *CmdTestSuite) TestRestoreWorkspace() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	restoreEnvID, restoreWsID = "env1", "ws1"
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "App1", "application.json"))
+	s.Assert().FileExists(filepath.Join(restoreTargetDir, "identity-template-User.json"))
+}
+
+func (s *CmdTestSuite) TestRestorePushToPlainID() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var imported, updatedApps []string
+	failing := false
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPut && r.URL.Path == "/api/1.0/applications/env1/app1" {
+			body, _ := io.ReadAll(r.Body)
+			updatedApps = append(updatedApps, string(body))
+			return
+		}
+		if r.Method == http.MethodPost && r.URL.Path == "/api/2.0/policies/env1" {
+			body, _ := io.ReadAll(r.Body)
+			imported = append(imported, r.URL.RawQuery+" "+string(body))
+			if failing {
+				http.Error(w, "invalid rego", http.StatusBadRequest)
+			}
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	restorePush = true
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().Equal([]string{"authWsId=ws1&appId=app1 package policy1"}, imported)
+	s.Require().Len(updatedApps, 1, "the application should be pushed before its policies")
+	s.Assert().JSONEq(`{"applicationId":"app1","displayName":"App1","description":"","logoUrl":"","colorIndication":"","assetTemplateIds":null,"authWsId":"ws1"}`, updatedApps[0])
+
+	// Only the application definitions are pushed
+	imported, updatedApps = nil, nil
+	restoreAppsOnly = true
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().Len(updatedApps, 1)
+	s.Assert().Empty(imported)
+	restoreAppsOnly = false
+
+	// Dry run only logs the applications and policies
+	imported, updatedApps = nil, nil
+	cfg.DryRun = true
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().Empty(imported)
+	s.Assert().Empty(updatedApps)
+
+	// Failed imports are reported after all policies were tried
+	cfg.DryRun = false
+	failing = true
+	err := restoreCmd.RunE(restoreCmd, nil)
+	s.Require().ErrorContains(err, "failed to push 1 of 1 policies")
+	s.Assert().Len(imported, 1)
+
+	restorePush, restoreAppsOnly = false, true
+	s.Assert().ErrorContains(restoreCmd.PreRunE(restoreCmd, nil), "restore-apps-only requires push-to-plainid")
+}
+
+func (s *CmdTestSuite) TestRestorePushRecreatedApplication() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// App1 was deleted since the backup, PlainID creates it again as app7
+	var imported []string
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/1.0/applications/env1/app1":
+			http.NotFound(w, r)
+		case r.URL.Path == "/policy-mgmt/1.0/applications/env1":
+			_, _ = w.Write([]byte(`{"data":[],"total":0}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/1.0/applications/env1":
+			_, _ = w.Write([]byte(`{"data":{"applicationId":"app7","displayName":"App1"}}`))
+		case r.Method == http.MethodPost && r.URL.Path == "/api/2.0/policies/env1":
+			imported = append(imported, r.URL.RawQuery)
+		default:
+			api.ServeHTTP(w, r)
+		}
+	})
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	restorePush = true
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+	s.Assert().Equal([]string{"authWsId=ws1&appId=app7"}, imported)
+}
+
+func (s *CmdTestSuite) TestClone() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// The application is known under another ID in the target environment
+	var pushed []string
+	api := s.plainIDServer.Config.Handler
+	s.plainIDServer.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/policy-mgmt/1.0/applications/env2":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":[{"id":"app9","name":"App1","authWsId":"ws2"}],"total":1}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/1.0/applications/env2/app9":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":{"applicationId":"app9","displayName":"App1"}}`))
+		case r.Method == http.MethodGet:
+			api.ServeHTTP(w, r)
+		default:
+			body, _ := io.ReadAll(r.Body)
+			pushed = append(pushed, r.Method+" "+r.URL.RequestURI()+" "+string(body))
+		}
+	})
+
+	var out strings.Builder
+	cloneCmd.SetOut(&out)
+	cloneCmd.SetErr(io.Discard)
+	defer func() {
+		cloneCmd.SetOut(nil)
+		cloneCmd.SetErr(nil)
+		cloneCmd.SetIn(nil)
+		cloneOpts = cloneOptions{}
+	}()
+	cloneOpts = cloneOptions{srcTag: s.backupTags()[0], srcEnvID: "env1", srcWsID: "ws1", dstEnvID: "env2", dstWsID: "ws2"}
+
+	// Dry run only lists the configuration
+	cfg.DryRun = true
+	s.Require().NoError(cloneCmd.RunE(cloneCmd, nil))
+	s.Assert().Equal(fmt.Sprintf(`Clone workspace ws1 of environment env1 from backup %s to workspace ws2 of environment env2:
+  identity template User
+  asset template at1
+  application App1
+  policy App1/policy_Pol1.rego
+`, cloneOpts.srcTag), out.String())
+	s.Assert().Empty(pushed)
+
+	// Declining the confirmation pushes nothing
+	cfg.DryRun = false
+	cloneCmd.SetIn(strings.NewReader("n\n"))
+	s.Require().NoError(cloneCmd.RunE(cloneCmd, nil))
+	s.Assert().Empty(pushed)
+
+	cloneOpts.yes = true
+	s.Require().NoError(cloneCmd.RunE(cloneCmd, nil))
+	s.Require().Len(pushed, 4)
+	s.Assert().Equal(`PUT /api/1.0/identity-templates/env2/User {"id":"User"}`, pushed[0])
+	s.Assert().Equal(`PUT /api/1.0/asset-templates/env2/at1 {"id":"at1"}`, pushed[1])
+	s.Assert().True(strings.HasPrefix(pushed[2], `PUT /api/1.0/applications/env2/app9 `), pushed[2])
+	s.Assert().Equal("POST /api/2.0/policies/env2?authWsId=ws2&appId=app9 package policy1", pushed[3])
+
+	cloneOpts.srcWsID = "ws9"
+	s.Assert().ErrorContains(cloneCmd.RunE(cloneCmd, nil), "workspace 'ws9' of environment 'env1' not found in backup")
+}
+
+func (s *CmdTestSuite) TestInfo() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	tag := s.backupTags()[0]
+
+	var out strings.Builder
+	infoCmd.SetOut(&out)
+	defer func() {
+		infoCmd.SetOut(nil)
+		infoOpts = infoOptions{format: auditFormatText}
+	}()
+	infoOpts = infoOptions{tag: tag, format: auditFormatText}
+	s.Require().NoError(infoCmd.RunE(infoCmd, nil))
+	s.Assert().Contains(out.String(), "Tag:     "+tag+"\n")
+	s.Assert().Contains(out.String(), "(from the manifest)")
+	s.Assert().Contains(out.String(), "\nEnvironment Env1 (env1), Env1_env1\n")
+	s.Assert().Contains(out.String(), "    App1/policy_Pol1.rego\n")
+	s.Assert().Contains(out.String(), "\nMessage:\n{\"timestamp\":")
+
+	out.Reset()
+	infoOpts.format = outputFormatJSON
+	s.Require().NoError(infoCmd.RunE(infoCmd, nil))
+	var info backupInfo
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &info))
+	s.Assert().True(info.HasManifest)
+	s.Assert().Len(info.Commit, 40)
+	s.Require().Len(info.Environments, 1)
+	s.Require().Len(info.Environments[0].Workspaces, 1)
+	ws := info.Environments[0].Workspaces[0]
+	s.Assert().Equal("ws1", ws.ID)
+	s.Assert().Equal("Env1_env1/WS1", ws.Dir)
+	s.Assert().Contains(ws.Files, "App1/policy_Pol1.rego")
+	s.Assert().Equal(len(ws.Files), ws.FileCount)
+	s.Assert().Positive(info.TotalSize)
+
+	// Backups without manifest are described from their directory tree
+	dir, err := checkoutForDiff(tag)
+	s.Require().NoError(err)
+	defer repository.CleanupTempDir(dir)
+	s.Require().NoError(os.Remove(filepath.Join(dir, manifestFileName)))
+	var legacy backupInfo
+	s.Require().NoError(legacy.readContents(dir, nil))
+	s.Assert().False(legacy.HasManifest)
+	s.Assert().Equal(info.FileCount, legacy.FileCount)
+	s.Assert().Equal(info.TotalSize, legacy.TotalSize)
+	s.Assert().Equal(info.Environments, legacy.Environments)
+}
+
+func (s *CmdTestSuite) TestListFullClone() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	// The full history is cloned, the tags of the branch come with it
+	cfg.Git.Depth = 0
+	tags, err := listBackupTags(s.T().TempDir(), "", "", nil)
+	s.Require().NoError(err)
+	s.Require().Len(tags, 2)
+	s.Assert().Equal("v0.2.0", tags[0].Name)
+	s.Assert().Equal("v0.1.0", tags[1].Name)
+}
+
+func (s *CmdTestSuite) TestBackupTagFormat() {
+	cfg.Git.TagFormat = "{branch}-2006.01.02-150405"
+	cfg.Git.TagTimezone = "UTC"
+	before := time.Now().Truncate(time.Second)
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	tags, err := listBackupTags(s.T().TempDir(), "", "", nil)
+	s.Require().NoError(err)
+	s.Require().Len(tags, 1)
+	s.Assert().Regexp(`^main-\d{4}\.\d{2}\.\d{2}-\d{6}$`, tags[0].Name)
+	s.Assert().False(tags[0].CreatedAt.Before(before), "the tag should be dated from its name")
+
+	// An invalid tag name fails the backup before anything is tagged
+	cfg.Git.TagFormat = "{branch}..20060102-150405"
+	backupForce = true
+	s.Require().ErrorContains(backupCmd.RunE(backupCmd, nil), "is not a valid git tag")
+	s.Assert().Len(s.backupTags(), 1)
+}
+
+func (s *CmdTestSuite) TestPrune() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	latest := s.backupTags()[0]
+
+	// Older backups of the same commit, and a semantic version tag that is never pruned
+	head, err := s.remote.Reference(plumbing.NewBranchReferenceName("main"), true)
+	s.Require().NoError(err)
+	for _, name := range []string{"20200101-000000", "20200102-000000", "20200103-000000", "v1.0.0"} {
+		s.Require().NoError(s.remote.Storer.SetReference(plumbing.NewHashReference(plumbing.NewTagReferenceName(name), head.Hash())))
+	}
+
+	var out strings.Builder
+	pruneCmd.SetOut(&out)
+	defer pruneCmd.SetOut(nil)
+	defer func() { pruneOpts = pruneOptions{} }()
+
+	pruneOpts = pruneOptions{keepLast: 2}
+	cfg.DryRun = true
+	s.Require().NoError(pruneCmd.RunE(pruneCmd, nil))
+	s.Assert().Equal("20200102-000000\n20200101-000000\n", out.String())
+	s.Assert().Len(s.backupTags(), 5, "dry run should not delete tags")
+
+	out.Reset()
+	cfg.DryRun = false
+	s.Require().NoError(pruneCmd.RunE(pruneCmd, nil))
+	s.Assert().Equal("20200102-000000\n20200101-000000\n", out.String())
+	s.Assert().ElementsMatch([]string{latest, "20200103-000000", "v1.0.0"}, s.backupTags())
+
+	// Recent tags are kept by older-than even beyond keep-last
+	out.Reset()
+	pruneOpts = pruneOptions{olderThan: time.Hour}
+	s.Require().NoError(pruneCmd.RunE(pruneCmd, nil))
+	s.Assert().Equal("20200103-000000\n", out.String())
+	s.Assert().ElementsMatch([]string{latest, "v1.0.0"}, s.backupTags())
+
+	// Tags already deleted from the remote are not an error
+	repo, err := repository.CloneRemote(s.remoteDir, "main", nil, s.T().TempDir(), 1)
+	s.Require().NoError(err)
+	s.Assert().NoError(deleteRemoteTags(repo, []tagInfo{{Name: "20200101-000000"}}))
+}
+
+func (s *CmdTestSuite) TestValidate() {
+	path := filepath.Join(s.T().TempDir(), "config.yaml")
+	writeConfig := func(envs string) {
+		s.Require().NoError(os.WriteFile(path, []byte(fmt.Sprintf(`git:
+    repo: %q
+    token: "git-token"
+    branch: main
+plainid:
+    base-url: %q
+    client-id: client-id
+    client-secret: client-secret
+    envs:
+%s`, s.remoteDir, s.plainIDServer.URL, envs)), 0600))
+	}
+
+	var out strings.Builder
+	rootCmd.SetOut(&out)
+	defer rootCmd.SetOut(nil)
+	rootCmd.SetArgs([]string{"validate", "--file", path})
+	defer rootCmd.SetArgs(nil)
+
+	writeConfig(`        - id: env1
+          workspaces: [{id: ws1}]
+          identities: [User]
+`)
+	s.Require().NoError(rootCmd.ExecuteContext(context.Background()))
+	s.Assert().Contains(out.String(), "✓ PlainID environment env1\n")
+	s.Assert().Contains(out.String(), "✓ PlainID workspace ws1 of environment env1\n")
+	s.Assert().Contains(out.String(), "✓ Git repository access\n")
+	s.Assert().NotContains(out.String(), "✗")
+
+	out.Reset()
+	writeConfig(`        - id: env1
+          workspaces: [{id: ws1}, {id: ws2}]
+        - id: env2
+          workspaces: [{id: ws1}]
+          identities: [User]
+`)
+	s.Require().ErrorContains(rootCmd.ExecuteContext(context.Background()), "3 validation checks failed")
+	s.Assert().Contains(out.String(), "✗ Configuration field plainid.envs[0].identities: required field is empty\n")
+	s.Assert().Contains(out.String(), "✗ PlainID workspace ws2 of environment env1: workspace does not exist in the environment\n")
+	s.Assert().Contains(out.String(), "✗ PlainID environment env2: environment does not exist")
+}
+
+func (s *CmdTestSuite) TestPolicyFileNames() {
+	long := strings.Repeat("x", 70)
+	s.Assert().Equal([]string{
+		"policy_Allow_read_write.rego",
+		"policy_Deny_all_pol2.rego",
+		"policy_Deny_all_pol3.rego",
+		"policy_" + long[:maxPolicyNameLength] + ".rego",
+	}, policyFileNames([]plainid.PolicyContent{
+		{ID: "pol1", Name: "Allow read/write"},
+		{ID: "pol2", Name: "Deny all"},
+		{ID: "pol3", Name: "Deny/all"},
+		{ID: "pol4", Name: long},
+	}))
+}
+
+func (s *CmdTestSuite) TestVerify() {
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	defer func() { verifyTag, verifyDir = "", "" }()
+
+	var out strings.Builder
+	verifyCmd.SetOut(&out)
+	defer verifyCmd.SetOut(nil)
+	s.Require().NoError(verifyCmd.RunE(verifyCmd, nil))
+	s.Assert().Empty(out.String())
+
+	restoreTag = s.backupTags()[0]
+	restoreTargetDir = s.T().TempDir()
+	s.Require().NoError(restoreCmd.RunE(restoreCmd, nil))
+
+	manifest, err := readManifest(restoreTargetDir)
+	s.Require().NoError(err)
+	s.Assert().Equal([]manifestResource{{ID: "env1", Name: "Env1"}}, manifest.Environments)
+	s.Assert().Equal([]manifestResource{{ID: "ws1", Name: "WS1"}}, manifest.Workspaces["env1"])
+	s.Assert().Equal(len(manifest.Files), manifest.FileCount)
+	s.Assert().Contains(manifest.Files, backupHistoryFileName)
+	s.Assert().NotContains(manifest.Files, manifestFileName)
+
+	// A changed and a deleted file are reported, verifying a restored backup
+	s.Require().NoError(os.WriteFile(filepath.Join(restoreTargetDir, "Env1_env1/WS1/App1/policy_Pol1.rego"), []byte("package tampered"), 0600))
+	s.Require().NoError(os.Remove(filepath.Join(restoreTargetDir, "Env1_env1/environment-settings.json")))
+	verifyDir = restoreTargetDir
+	s.Require().ErrorContains(verifyCmd.RunE(verifyCmd, nil), "2 of ")
+	s.Assert().Contains(out.String(), "Env1_env1/WS1/App1/policy_Pol1.rego: checksum ")
+	s.Assert().Contains(out.String(), "Env1_env1/environment-settings.json: file is missing\n")
+}
+
+func (s *CmdTestSuite) TestTagMetadata() {
+	stats := &backupStats{}
+	stats.addFailure("env1", "ws2", errors.New("unavailable"))
+	envs := []config.Environment{{ID: "env1", Workspaces: []config.Workspace{{ID: "ws10"}, {ID: "ws2"}}}}
+	message, err := newBackupTagMetadata(stats, time.Now(), envs, 42, []string{"team=ops"}).message()
+	s.Require().NoError(err)
+
+	metadata, ok := parseTagMetadata(message)
+	s.Require().True(ok)
+	s.Assert().Equal([]string{"env1"}, metadata.Environments)
+	s.Assert().Equal(map[string][]string{"env1": {"ws10"}}, metadata.Workspaces)
+	s.Assert().Equal(map[string][]string{"env1": {"ws2"}}, metadata.Skipped)
+	s.Assert().Equal(42, metadata.FileCount)
+	s.Assert().Equal([]string{"team=ops"}, metadata.Labels)
+
+	// Workspace IDs are matched exactly, not as a substring of the message
+	tag := tagInfo{Message: message, WorkspacesByEnv: metadata.Workspaces}
+	s.Assert().True(tag.hasWorkspace("env1", "ws10"))
+	s.Assert().False(tag.hasWorkspace("env1", "ws1"))
+	s.Assert().False(tag.hasWorkspace("env1", "ws2"), "skipped workspaces are not in the backup")
+
+	_, ok = parseTagMetadata("Backup tag for Backup PlainID configuration for: env:env1 ws:ws1")
+	s.Assert().False(ok)
+	legacy := tagInfo{Message: "Backup tag for Backup PlainID configuration for: env:env1 ws:ws10"}
+	s.Assert().True(legacy.hasWorkspace("env1", "ws1"), "older tags are matched on their message")
+}
+
+func (s *CmdTestSuite) TestListOutputFormats() {
+	backupSemVer = repository.SemVerMinor
+	defer func() { backupSemVer = "" }()
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	backupForce = true
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+
+	var out strings.Builder
+	listCmd.SetOut(&out)
+	defer listCmd.SetOut(nil)
+	defer func() { listOpts = listOptions{} }()
+
+	// Both backups are tagged within the same second, their order is not checked
+	listOpts = listOptions{limit: 1, outputFormat: outputFormatJSON}
+	s.Require().NoError(listCmd.RunE(listCmd, nil))
+	var backups []map[string]any
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &backups))
+	s.Require().Len(backups, 1, "limit should apply")
+	s.Assert().Contains([]any{"v0.1.0", "v0.2.0"}, backups[0]["name"])
+	s.Assert().Equal([]any{"env1"}, backups[0]["environments"])
+	s.Assert().Equal([]any{"ws1"}, backups[0]["workspaces"])
+	s.Assert().Contains(backups[0]["message"], `"file_count":`)
+	s.Assert().Equal(map[string]any{"env1": []any{"ws1"}}, backups[0]["workspaces_by_env"])
+	_, err := time.Parse(time.RFC3339, backups[0]["created_at"].(string))
+	s.Assert().NoError(err)
+
+	out.Reset()
+	listOpts = listOptions{limit: 10, outputFormat: outputFormatYAML}
+	s.Require().NoError(listCmd.RunE(listCmd, nil))
+	s.Assert().Contains(out.String(), "- name: v0.2.0\n  created_at: ")
+	s.Assert().Contains(out.String(), "- name: v0.1.0\n  created_at: ")
+
+	out.Reset()
+	listOpts = listOptions{limit: 10, outputFormat: outputFormatTable}
+	s.Require().NoError(listCmd.RunE(listCmd, nil))
+	s.Assert().Contains(out.String(), " v0.2.0 (env: env1, ws: ws1, created: ")
+
+	listOpts.outputFormat = "xml"
+	s.Assert().ErrorContains(listCmd.PreRunE(listCmd, nil), "output-format must be one of table, json or yaml")
+}
+
+func (s *CmdTestSuite) TestListCheckStale() {
+	var out, errOut strings.Builder
+	listCmd.SetOut(&out)
+	listCmd.SetErr(&errOut)
+	defer listCmd.SetOut(nil)
+	defer listCmd.SetErr(nil)
+	defer func() { listOpts = listOptions{} }()
+
+	listOpts = listOptions{limit: 10, outputFormat: outputFormatJSON, checkStale: time.Hour}
+	err := listCmd.RunE(listCmd, nil)
+	var exitErr *exitCodeError
+	s.Require().ErrorAs(err, &exitErr)
+	s.Assert().Equal(ExitCodeBackupStale, exitErr.code)
+	s.Assert().ErrorContains(err, "no backup found")
+	s.Assert().Contains(errOut.String(), "WARNING: no backup found, expected one within 1h0m0s")
+	s.Assert().JSONEq(`[]`, out.String(), "the warning should not be mixed with the output")
+
+	// A fresh backup passes the check
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	out.Reset()
+	errOut.Reset()
+	s.Require().NoError(listCmd.RunE(listCmd, nil))
+	s.Assert().Empty(errOut.String())
+
+	errOut.Reset()
+	old := []tagInfo{{Name: "20250101-120000", CreatedAt: time.Now().Add(-2 * time.Hour)}}
+	err = checkStale(listCmd, old)
+	s.Require().ErrorAs(err, &exitErr)
+	s.Assert().Equal(ExitCodeBackupStale, exitErr.code)
+	s.Assert().ErrorContains(err, "latest backup 20250101-120000 is stale")
+	s.Assert().Contains(errOut.String(), "WARNING: latest backup 20250101-120000 is 2h0m0s old, older than 1h0m0s")
+}
+
+func (s *CmdTestSuite) TestStatus() {
+	var out strings.Builder
+	statusCmd.SetOut(&out)
+	defer statusCmd.SetOut(nil)
+	defer func() { statusOpts = statusOptions{} }()
+
+	statusOpts = statusOptions{warnAfter: time.Hour, outputFormat: outputFormatJSON}
+	s.Assert().EqualError(statusCmd.RunE(statusCmd, nil), "no backup found")
+
+	s.Require().NoError(backupCmd.RunE(backupCmd, nil))
+	head, err := s.remote.Head()
+	s.Require().NoError(err)
+
+	out.Reset()
+	s.Require().NoError(statusCmd.RunE(statusCmd, nil))
+	var status backupStatus
+	s.Require().NoError(json.Unmarshal([]byte(out.String()), &status))
+	s.Assert().Equal(s.backupTags()[0], status.Tag)
+	s.Assert().Equal(head.Hash().String(), status.Commit)
+	s.Assert().Equal("just now", status.Age)
+	s.Assert().Equal(1, status.Environments)
+	s.Assert().Equal(1, status.Workspaces)
+	s.Assert().False(status.Stale)
+
+	// The backup becomes stale as soon as it is older than warn-after
+	out.Reset()
+	statusOpts = statusOptions{warnAfter: time.Nanosecond, outputFormat: outputFormatText}
+	s.Assert().ErrorContains(statusCmd.RunE(statusCmd, nil), "is older than 1ns")
+	s.Assert().Contains(out.String(), "Latest backup: "+status.Tag)
+	s.Assert().Contains(out.String(), "WARNING: latest backup is older than 1ns")
+}
+
+func (s *CmdTestSuite) TestHumanizeAge() {
+	s.Assert().Equal("just now", humanizeAge(30*time.Second))
+	s.Assert().Equal("1 minute ago", humanizeAge(time.Minute))
+	s.Assert().Equal("2 hours ago", humanizeAge(2*time.Hour+59*time.Minute))
+	s.Assert().Equal("3 days ago", humanizeAge(75*time.Hour))
+}
+
+func (s *CmdTestSuite) TestInit() {
+	wd, err := os.Getwd()
+	s.Require().NoError(err)
+	s.Require().NoError(os.Chdir(s.T().TempDir()))
+	defer func() { s.Require().NoError(os.Chdir(wd)) }()
+
+	var out, prompts strings.Builder
+	rootCmd.SetOut(&out)
+	rootCmd.SetErr(&prompts)
+	defer rootCmd.SetOut(nil)
+	defer rootCmd.SetErr(nil)
+	defer rootCmd.SetIn(nil)
+	defer rootCmd.SetArgs(nil)
+	defer func() { s.Require().NoError(rootCmd.PersistentFlags().Set("dry-run", "false")) }()
+
+	// The default branch is kept and the client ID is asked again when left empty
+	answers := fmt.Sprintf("%s\n\nclient-id\nclient-secret\n%s\ngit-token\n\n3\n1\n", s.plainIDServer.URL, s.remoteDir)
+	rootCmd.SetIn(strings.NewReader(answers))
+	rootCmd.SetArgs([]string{"init"})
+	s.Require().NoError(rootCmd.ExecuteContext(context.Background()))
+	s.Assert().Contains(prompts.String(), "PlainID client ID is required\n")
+	s.Assert().Contains(prompts.String(), "1. Env1 (env1)\n")
+	s.Assert().Contains(prompts.String(), `invalid selection "3", expected numbers between 1 and 1`)
+
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	config.RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", initConfigFileName}))
+	written, err := config.LoadConfig(flagSet)
+	s.Require().NoError(err, "the written configuration should be valid")
+	s.Assert().Equal(s.plainIDServer.URL, written.PlainID.BaseURL)
+	s.Assert().Equal("main", written.Git.Branch)
+	s.Assert().Equal([]config.Environment{{
+		ID:         "env1",
+		Name:       "Env1",
+		Workspaces: []config.Workspace{{ID: "*"}},
+		Identities: []string{"*"},
+	}}, written.PlainID.Envs)
+
+	// An existing file is only overwritten when confirmed
+	before, err := os.ReadFile(initConfigFileName)
+	s.Require().NoError(err)
+	rootCmd.SetIn(strings.NewReader("n\n"))
+	s.Require().NoError(rootCmd.ExecuteContext(context.Background()))
+	after, err := os.ReadFile(initConfigFileName)
+	s.Require().NoError(err)
+	s.Assert().Equal(before, after)
+
+	answers = fmt.Sprintf("%s\nclient-id\nclient-secret\n%s\ngit-token\n\n\n", s.plainIDServer.URL, s.remoteDir)
+	rootCmd.SetIn(strings.NewReader(answers))
+	rootCmd.SetArgs([]string{"init", "--dry-run"})
+	s.Require().NoError(rootCmd.ExecuteContext(context.Background()))
+	s.Assert().Contains(out.String(), "plainid:\n    base-url: ")
+	s.Assert().Contains(out.String(), "    envs:\n        - id: '*'\n")
+}
+
+func (s *CmdTestSuite) TestLogFlags() {
+	defer func() {
+		s.Require().NoError(rootCmd.PersistentFlags().Set("log-format", logFormatText))
+		s.Require().NoError(rootCmd.PersistentFlags().Set("log-level", "info"))
+		configureLogging()
+	}()
+
+	s.Assert().ErrorContains(rootCmd.PersistentFlags().Set("log-format", "xml"), "must be one of text, json")
+	s.Assert().ErrorContains(rootCmd.PersistentFlags().Set("log-level", "trace"), "must be one of debug, info, warn, error")
+
+	s.Require().NoError(rootCmd.PersistentFlags().Set("log-format", logFormatJSON))
+	s.Require().NoError(rootCmd.PersistentFlags().Set("log-level", "warn"))
+	configureLogging()
+	s.Assert().Equal(zerolog.WarnLevel, zerolog.GlobalLevel())
+}
+
+func (s *CmdTestSuite) TestWatchFileDebounces() {
+	debounce := watchDebounce
+	watchDebounce = 100 * time.Millisecond
+	defer func() { watchDebounce = debounce }()
+
+	path := filepath.Join(s.T().TempDir(), "webhook.json")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes := make(chan struct{}, 10)
+	done := make(chan error)
+	go func() {
+		done <- watchFile(ctx, path, func() { changes <- struct{}{} })
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// A burst of writes triggers a single backup
+	for i := range 3 {
+		s.Require().NoError(os.WriteFile(path, []byte(fmt.Sprintf(`{"delivery":%d}`, i)), 0600))
+	}
+	select {
+	case <-changes:
+	case <-time.After(2 * time.Second):
+		s.Fail("watched file change was not reported")
+	}
+	time.Sleep(200 * time.Millisecond)
+	s.Assert().Empty(changes, "writes should be coalesced")
+
+	cancel()
+	s.Assert().NoError(<-done)
+}
@@ -0,0 +1,233 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/plainid/git-backup/repository"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffTagA  string
+	diffTagB  string
+	diffEnvID string
+	diffWsID  string
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff",
+	Short: "Compare two backups",
+	Long: `Show the changes of the PlainID configuration between two backup tags as a unified diff,
+including the files added and deleted in between.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if (diffEnvID != "" && diffWsID == "") || (diffEnvID == "" && diffWsID != "") {
+			return errors.New("both env-id and ws-id must be provided together if one is specified")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Str("tagA", diffTagA).Str("tagB", diffTagB).Msg("Executing diff command")
+
+		dirA, err := checkoutForDiff(diffTagA)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(dirA)
+
+		dirB, err := checkoutForDiff(diffTagB)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(dirB)
+
+		// Scope the diff to the workspace directory, which may be missing from one of the backups
+		var scopeA, scopeB string
+		if diffEnvID != "" {
+			if scopeA, err = workspaceBackupDir(dirA, diffEnvID, diffWsID); err != nil {
+				return err
+			}
+			if scopeB, err = workspaceBackupDir(dirB, diffEnvID, diffWsID); err != nil {
+				return err
+			}
+			if scopeA == "" && scopeB == "" {
+				return fmt.Errorf("workspace '%s' of environment '%s' is in neither backup", diffWsID, diffEnvID)
+			}
+		}
+
+		changed, err := diffBackups(cmd.OutOrStdout(), dirA, scopeA, dirB, scopeB, diffEnvID != "")
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("%d files changed between %s and %s", changed, diffTagA, diffTagB)
+		return nil
+	},
+}
+
+// checkoutForDiff checks out the backup tag into a new temporary directory
+func checkoutForDiff(tag string) (string, error) {
+	tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return "", err
+	}
+	if _, err := cloneAndCheckoutTag(tempDir, tag); err != nil {
+		repository.CleanupTempDir(tempDir)
+		return "", err
+	}
+	return tempDir, nil
+}
+
+// workspaceBackupDir returns the directory of the workspace in the backup in dir, relative to it,
+// or an empty string if the backup does not contain the workspace. The workspace is recognized by
+// its workspace-metadata.json, or by its configured name for backups without one.
+func workspaceBackupDir(dir, envID, wsID string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), "_"+envID) {
+			continue
+		}
+		wsEntries, err := os.ReadDir(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return "", fmt.Errorf("failed to read environment directory: %w", err)
+		}
+		for _, wsEntry := range wsEntries {
+			if !wsEntry.IsDir() {
+				continue
+			}
+			wsDir := filepath.Join(entry.Name(), wsEntry.Name())
+
+			var metadata struct {
+				ID string `json:"id"`
+			}
+			data, err := os.ReadFile(filepath.Join(dir, wsDir, "workspace-metadata.json"))
+			if err == nil && json.Unmarshal(data, &metadata) == nil {
+				if metadata.ID == wsID {
+					return wsDir, nil
+				}
+				continue
+			}
+			if findWorkspaceByNameOrID(envID, wsID, wsEntry.Name()) != nil {
+				return wsDir, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// diffBackups writes a unified diff of the files under scopeA of the backup in dirA and scopeB of
+// the backup in dirB to out, and returns the number of files that differ. In a scoped diff, an empty
+// scope is missing from its backup and all files of the other one are added or deleted.
+func diffBackups(out io.Writer, dirA, scopeA, dirB, scopeB string, scoped bool) (int, error) {
+	filesA, err := backupFiles(dirA, scopeA, scoped)
+	if err != nil {
+		return 0, err
+	}
+	filesB, err := backupFiles(dirB, scopeB, scoped)
+	if err != nil {
+		return 0, err
+	}
+
+	paths := make([]string, 0, len(filesA)+len(filesB))
+	for path := range filesA {
+		paths = append(paths, path)
+	}
+	for path := range filesB {
+		if !filesA[path] {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	changed := 0
+	for _, path := range paths {
+		diff := difflib.UnifiedDiff{
+			FromFile: "/dev/null",
+			ToFile:   "/dev/null",
+			Context:  3,
+		}
+		if filesA[path] {
+			content, err := os.ReadFile(filepath.Join(dirA, scopeA, path))
+			if err != nil {
+				return 0, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			diff.A = difflib.SplitLines(string(content))
+			diff.FromFile = "a/" + filepath.ToSlash(filepath.Join(scopeA, path))
+		}
+		if filesB[path] {
+			content, err := os.ReadFile(filepath.Join(dirB, scopeB, path))
+			if err != nil {
+				return 0, fmt.Errorf("failed to read %s: %w", path, err)
+			}
+			diff.B = difflib.SplitLines(string(content))
+			diff.ToFile = "b/" + filepath.ToSlash(filepath.Join(scopeB, path))
+		}
+
+		// Added and deleted files always count, even empty ones without lines to show
+		if filesA[path] && filesB[path] && slices.Equal(diff.A, diff.B) {
+			continue
+		}
+		changed++
+		if _, err := fmt.Fprintf(out, "diff %s %s\n", diff.FromFile, diff.ToFile); err != nil {
+			return 0, err
+		}
+		if err := difflib.WriteUnifiedDiff(out, diff); err != nil {
+			return 0, fmt.Errorf("failed to write diff of %s: %w", path, err)
+		}
+	}
+	return changed, nil
+}
+
+// backupFiles returns the paths of the files under scope of the backup in dir, relative to the scope,
+// without the git metadata. An empty scope of a scoped diff has no files.
+func backupFiles(dir, scope string, scoped bool) (map[string]bool, error) {
+	files := make(map[string]bool)
+	if scoped && scope == "" {
+		return files, nil
+	}
+
+	root := filepath.Join(dir, scope)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == git.GitDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backup files: %w", err)
+	}
+	return files, nil
+}
+
+func init() {
+	diffCmd.Flags().StringVar(&diffTagA, "tag-a", "", "Tag of the earlier backup")
+	diffCmd.Flags().StringVar(&diffTagB, "tag-b", "", "Tag of the later backup")
+	diffCmd.Flags().StringVar(&diffEnvID, "env-id", "", "Environment ID to compare (optional, requires ws-id)")
+	diffCmd.Flags().StringVar(&diffWsID, "ws-id", "", "Workspace ID to compare (optional, requires env-id)")
+	_ = diffCmd.MarkFlagRequired("tag-a")
+	_ = diffCmd.MarkFlagRequired("tag-b")
+}
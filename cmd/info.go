@@ -0,0 +1,283 @@
+package cmd
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// infoOptions holds command-specific options
+type infoOptions struct {
+	tag    string
+	format string
+}
+
+var infoOpts infoOptions
+
+// backupInfo describes a backup tag and the files of its backup
+type backupInfo struct {
+	Tag       string    `json:"tag"`
+	CreatedAt time.Time `json:"created_at"`
+	Commit    string    `json:"commit"`
+	Message   string    `json:"message"`
+	// HasManifest tells whether the files were listed from the manifest or the directory tree
+	HasManifest  bool              `json:"has_manifest"`
+	Environments []infoEnvironment `json:"environments"`
+	FileCount    int               `json:"file_count"`
+	TotalSize    int64             `json:"total_size"`
+}
+
+// infoEnvironment is an environment directory of the backup
+type infoEnvironment struct {
+	ID         string          `json:"id"`
+	Name       string          `json:"name"`
+	Dir        string          `json:"dir"`
+	Workspaces []infoWorkspace `json:"workspaces"`
+}
+
+// infoWorkspace is a workspace directory of the backup, its files are relative to it
+type infoWorkspace struct {
+	ID        string   `json:"id"`
+	Name      string   `json:"name"`
+	Dir       string   `json:"dir"`
+	FileCount int      `json:"file_count"`
+	Size      int64    `json:"size"`
+	Files     []string `json:"files"`
+}
+
+var infoCmd = &cobra.Command{
+	Use:   "info",
+	Short: "Show the details of a backup",
+	Long: `Show the creation time, commit and message of a backup tag, with the environments and
+workspaces of the backup and the files of every workspace. The files are listed from the
+manifest of the backup, or from the directory tree for backups taken before manifests.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch infoOpts.format {
+		case auditFormatText, outputFormatJSON:
+			return nil
+		default:
+			return fmt.Errorf("format must be one of %s or %s: %s", auditFormatText, outputFormatJSON, infoOpts.format)
+		}
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Str("tag", infoOpts.tag).Msg("Executing info command")
+
+		tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(tempDir)
+
+		repo, err := cloneAndCheckoutTag(tempDir, infoOpts.tag)
+		if err != nil {
+			return err
+		}
+		info, err := readTagInfo(repo, infoOpts.tag)
+		if err != nil {
+			return err
+		}
+
+		manifest, err := readManifest(tempDir)
+		if err != nil {
+			// Backups taken before manifests are described from their directory tree
+			log.Debug().Err(err).Msg("Listing the files of the backup without manifest")
+			manifest = nil
+		}
+		if err := info.readContents(tempDir, manifest); err != nil {
+			return err
+		}
+		return writeBackupInfo(cmd.OutOrStdout(), infoOpts.format, info)
+	},
+}
+
+// readTagInfo returns the creation time, commit and message of the tag. Lightweight tags have no
+// message and are dated by their commit.
+func readTagInfo(repo *git.Repository, tag string) (backupInfo, error) {
+	ref, err := repo.Tag(tag)
+	if err != nil {
+		return backupInfo{}, fmt.Errorf("failed to find tag '%s': %w", tag, err)
+	}
+
+	info := backupInfo{Tag: tag}
+	tagObject, err := repo.TagObject(ref.Hash())
+	switch {
+	case errors.Is(err, plumbing.ErrObjectNotFound):
+		commit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return backupInfo{}, fmt.Errorf("failed to read commit of tag '%s': %w", tag, err)
+		}
+		info.CreatedAt = commit.Committer.When
+		info.Commit = commit.Hash.String()
+	case err != nil:
+		return backupInfo{}, fmt.Errorf("failed to read tag '%s': %w", tag, err)
+	default:
+		info.CreatedAt = tagObject.Tagger.When
+		info.Commit = tagObject.Target.String()
+		info.Message = tagObject.Message
+	}
+	return info, nil
+}
+
+// readContents lists the environments, workspaces and files of the backup checked out in dir. The
+// files are those of the manifest when there is one, the names of workspaces without metadata are
+// matched to their ID with it.
+func (info *backupInfo) readContents(dir string, manifest *backupManifest) error {
+	var paths []string
+	if manifest != nil {
+		info.HasManifest = true
+		for path := range manifest.Files {
+			paths = append(paths, path)
+		}
+	} else {
+		files, err := backupFiles(dir, "", false)
+		if err != nil {
+			return err
+		}
+		for path := range files {
+			paths = append(paths, filepath.ToSlash(path))
+		}
+	}
+	slices.Sort(paths)
+
+	sizes := make(map[string]int64, len(paths))
+	for _, path := range paths {
+		stat, err := os.Stat(filepath.Join(dir, filepath.FromSlash(path)))
+		if err != nil {
+			return fmt.Errorf("failed to read backup file %s: %w", path, err)
+		}
+		sizes[path] = stat.Size()
+		info.TotalSize += stat.Size()
+	}
+	info.FileCount = len(paths)
+
+	envs, err := infoEnvironments(dir, "", manifest)
+	if err != nil {
+		return err
+	}
+	for i := range envs {
+		for j := range envs[i].Workspaces {
+			ws := &envs[i].Workspaces[j]
+			prefix := ws.Dir + "/"
+			for _, path := range paths {
+				if rel, ok := strings.CutPrefix(path, prefix); ok {
+					ws.Files = append(ws.Files, rel)
+					ws.Size += sizes[path]
+				}
+			}
+			ws.FileCount = len(ws.Files)
+		}
+	}
+	info.Environments = envs
+	return nil
+}
+
+// infoEnvironments returns the environment directories under parent in the backup in dir. Directories
+// without an environment ID suffix hold the environments of a PlainID instance.
+func infoEnvironments(dir, parent string, manifest *backupManifest) ([]infoEnvironment, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, parent))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var envs []infoEnvironment
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == git.GitDirName {
+			continue
+		}
+		envDir := filepath.ToSlash(filepath.Join(parent, entry.Name()))
+		sep := strings.LastIndex(entry.Name(), "_")
+		if sep < 0 {
+			if parent == "" {
+				instanceEnvs, err := infoEnvironments(dir, entry.Name(), manifest)
+				if err != nil {
+					return nil, err
+				}
+				envs = append(envs, instanceEnvs...)
+			}
+			continue
+		}
+
+		env := infoEnvironment{ID: entry.Name()[sep+1:], Name: entry.Name()[:sep], Dir: envDir}
+		wsEntries, err := os.ReadDir(filepath.Join(dir, envDir))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read environment directory: %w", err)
+		}
+		for _, wsEntry := range wsEntries {
+			if !wsEntry.IsDir() {
+				continue
+			}
+			ws := infoWorkspace{Name: wsEntry.Name(), Dir: envDir + "/" + wsEntry.Name()}
+			var metadata struct {
+				ID   string `json:"id"`
+				Name string `json:"name"`
+			}
+			data, err := os.ReadFile(filepath.Join(dir, ws.Dir, "workspace-metadata.json"))
+			if err == nil && json.Unmarshal(data, &metadata) == nil && metadata.ID != "" {
+				ws.ID, ws.Name = metadata.ID, metadata.Name
+			} else if manifest != nil {
+				for _, resource := range manifest.Workspaces[env.ID] {
+					if resource.Name == ws.Name {
+						ws.ID = resource.ID
+						break
+					}
+				}
+			}
+			env.Workspaces = append(env.Workspaces, ws)
+		}
+		envs = append(envs, env)
+	}
+	return envs, nil
+}
+
+// writeBackupInfo writes the details of the backup to out as text or JSON
+func writeBackupInfo(out io.Writer, format string, info backupInfo) error {
+	if format == outputFormatJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info); err != nil {
+			return fmt.Errorf("failed to write backup info as JSON: %w", err)
+		}
+		return nil
+	}
+
+	source := "manifest"
+	if !info.HasManifest {
+		source = "directory tree, the backup has no manifest"
+	}
+	fmt.Fprintf(out, "Tag:     %s\n", info.Tag)
+	fmt.Fprintf(out, "Created: %s\n", info.CreatedAt.Format("2006-01-02 15:04:05 -0700"))
+	fmt.Fprintf(out, "Commit:  %s\n", info.Commit)
+	fmt.Fprintf(out, "Files:   %d, %d bytes (from the %s)\n", info.FileCount, info.TotalSize, source)
+	for _, env := range info.Environments {
+		fmt.Fprintf(out, "\nEnvironment %s (%s), %s\n", env.Name, env.ID, env.Dir)
+		for _, ws := range env.Workspaces {
+			fmt.Fprintf(out, "  Workspace %s (%s), %d files, %d bytes\n", ws.Name, cmp.Or(ws.ID, "unknown ID"), ws.FileCount, ws.Size)
+			for _, file := range ws.Files {
+				fmt.Fprintf(out, "    %s\n", file)
+			}
+		}
+	}
+	if info.Message != "" {
+		fmt.Fprintf(out, "\nMessage:\n%s\n", strings.TrimRight(info.Message, "\n"))
+	}
+	return nil
+}
+
+func init() {
+	infoCmd.Flags().StringVar(&infoOpts.tag, "tag", "", "Tag of the backup to describe")
+	infoCmd.Flags().StringVar(&infoOpts.format, "format", auditFormatText, "Output format: text or json")
+	_ = infoCmd.MarkFlagRequired("tag")
+}
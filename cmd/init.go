@@ -0,0 +1,281 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/plainid"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// initConfigFileName is the configuration file written by init in the current directory
+const initConfigFileName = config.DefaultConfigFileName + ".yaml"
+
+// errInputEnded is returned when the input ends before every question of init is answered
+var errInputEnded = errors.New("input ended before the configuration was complete")
+
+// initConfig is the configuration file written by init, keyed like the configuration file
+type initConfig struct {
+	Git     initGitConfig     `yaml:"git"`
+	PlainID initPlainIDConfig `yaml:"plainid"`
+}
+
+type initGitConfig struct {
+	Repo   string `yaml:"repo"`
+	Token  string `yaml:"token"`
+	Branch string `yaml:"branch"`
+}
+
+type initPlainIDConfig struct {
+	BaseURL      string            `yaml:"base-url"`
+	ClientID     string            `yaml:"client-id"`
+	ClientSecret string            `yaml:"client-secret"`
+	Envs         []initEnvironment `yaml:"envs"`
+}
+
+type initEnvironment struct {
+	ID         string          `yaml:"id"`
+	Name       string          `yaml:"name,omitempty"`
+	Workspaces []initWorkspace `yaml:"workspaces"`
+	Identities []string        `yaml:"identities"`
+}
+
+type initWorkspace struct {
+	ID string `yaml:"id"`
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate a starter configuration file interactively",
+	Long: `Ask for the PlainID and git settings, list the environments available with the PlainID
+credentials, and write the selected ones to .git-backup.yaml in the current directory.
+With --dry-run the configuration is printed to stdout instead.`,
+	// The configuration does not exist yet, it is created by the command
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Msg("Executing init command")
+
+		dryRun, err := cmd.Flags().GetBool("dry-run")
+		if err != nil {
+			return err
+		}
+
+		// Questions go to stderr, stdout only gets the configuration in dry run mode
+		prompter := &initPrompter{scanner: bufio.NewScanner(cmd.InOrStdin()), out: cmd.ErrOrStderr()}
+		if !dryRun {
+			if _, err := os.Stat(initConfigFileName); err == nil {
+				overwrite, err := prompter.confirm(fmt.Sprintf("%s already exists, overwrite it?", initConfigFileName))
+				if err != nil {
+					return err
+				}
+				if !overwrite {
+					log.Info().Msg("Configuration file kept, nothing written")
+					return nil
+				}
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to check configuration file: %w", err)
+			}
+		}
+
+		initCfg, err := promptInitConfig(cmd, prompter)
+		if err != nil {
+			return err
+		}
+
+		data, err := marshalInitConfig(initCfg)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			_, err := cmd.OutOrStdout().Write(data)
+			return err
+		}
+		// The file holds the git token and the PlainID client secret
+		if err := os.WriteFile(initConfigFileName, data, 0600); err != nil {
+			return fmt.Errorf("failed to write configuration file: %w", err)
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "Configuration written to %s, check it with 'git-backup validate'\n", initConfigFileName)
+		return nil
+	},
+}
+
+// promptInitConfig asks for the settings of the configuration file and the environments to back up
+func promptInitConfig(cmd *cobra.Command, prompter *initPrompter) (initConfig, error) {
+	var (
+		initCfg initConfig
+		err     error
+	)
+	questions := []struct {
+		value        *string
+		question     string
+		defaultValue string
+	}{
+		{&initCfg.PlainID.BaseURL, "PlainID base URL", "https://api.plainid.io"},
+		{&initCfg.PlainID.ClientID, "PlainID client ID", ""},
+		{&initCfg.PlainID.ClientSecret, "PlainID client secret", ""},
+		{&initCfg.Git.Repo, "Git repository URL", ""},
+		{&initCfg.Git.Token, "Git token", ""},
+		{&initCfg.Git.Branch, "Git branch", "main"},
+	}
+	for _, q := range questions {
+		if *q.value, err = prompter.ask(q.question, q.defaultValue); err != nil {
+			return initConfig{}, err
+		}
+	}
+
+	service := plainid.NewService(config.Config{PlainID: config.PlainIDConfig{
+		BaseURL:      initCfg.PlainID.BaseURL,
+		ClientID:     initCfg.PlainID.ClientID,
+		ClientSecret: initCfg.PlainID.ClientSecret,
+	}}, plainid.WithUserAgent(userAgent()))
+	envs, err := service.Environments(cmd.Context())
+	if err != nil {
+		return initConfig{}, fmt.Errorf("failed to list environments with the PlainID credentials: %w", err)
+	}
+	if len(envs) == 0 {
+		return initConfig{}, errors.New("no PlainID environment is accessible with the client credentials")
+	}
+
+	initCfg.PlainID.Envs, err = prompter.selectEnvironments(envs)
+	if err != nil {
+		return initConfig{}, err
+	}
+	return initCfg, nil
+}
+
+// marshalInitConfig renders the configuration file with the indentation of the documented examples
+func marshalInitConfig(initCfg initConfig) ([]byte, error) {
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(4)
+	if err := encoder.Encode(initCfg); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode configuration: %w", err)
+	}
+	return []byte(out.String()), nil
+}
+
+// initPrompter asks the questions of init and reads one answer per line
+type initPrompter struct {
+	scanner *bufio.Scanner
+	out     io.Writer
+}
+
+// readLine reads the next answer, errInputEnded is returned at the end of the input
+func (p *initPrompter) readLine() (string, error) {
+	if !p.scanner.Scan() {
+		if err := p.scanner.Err(); err != nil {
+			return "", fmt.Errorf("failed to read answer: %w", err)
+		}
+		return "", errInputEnded
+	}
+	return strings.TrimSpace(p.scanner.Text()), nil
+}
+
+// ask asks question until it gets an answer, an empty answer gives defaultValue when it is set
+func (p *initPrompter) ask(question, defaultValue string) (string, error) {
+	for {
+		if defaultValue != "" {
+			fmt.Fprintf(p.out, "%s [%s]: ", question, defaultValue)
+		} else {
+			fmt.Fprintf(p.out, "%s: ", question)
+		}
+		answer, err := p.readLine()
+		if err != nil {
+			return "", err
+		}
+		if answer == "" {
+			answer = defaultValue
+		}
+		if answer != "" {
+			return answer, nil
+		}
+		fmt.Fprintf(p.out, "%s is required\n", question)
+	}
+}
+
+// confirm asks a yes or no question, anything but yes is a no
+func (p *initPrompter) confirm(question string) (bool, error) {
+	fmt.Fprintf(p.out, "%s [y/N] ", question)
+	answer, err := p.readLine()
+	if err != nil && !errors.Is(err, errInputEnded) {
+		return false, err
+	}
+	switch strings.ToLower(answer) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// selectEnvironments lists envs as a numbered menu and reads the comma-separated numbers of the
+// environments to back up, asking again until a valid selection is given. All workspaces and
+// identities of the selected environments are backed up, "*" selects all environments.
+func (p *initPrompter) selectEnvironments(envs []plainid.Environment) ([]initEnvironment, error) {
+	fmt.Fprintln(p.out, "Available environments:")
+	for i, env := range envs {
+		fmt.Fprintf(p.out, "%d. %s (%s)\n", i+1, env.Name, env.ID)
+	}
+
+	for {
+		answer, err := p.ask(fmt.Sprintf("Environments to back up, comma-separated numbers [1-%d] or * for all", len(envs)), "*")
+		if err != nil {
+			return nil, err
+		}
+		if answer == "*" {
+			return []initEnvironment{newInitEnvironment("*", "")}, nil
+		}
+
+		selected, err := parseSelection(answer, len(envs))
+		if err != nil {
+			fmt.Fprintln(p.out, err)
+			continue
+		}
+		initEnvs := make([]initEnvironment, 0, len(selected))
+		for _, i := range selected {
+			initEnvs = append(initEnvs, newInitEnvironment(envs[i].ID, envs[i].Name))
+		}
+		return initEnvs, nil
+	}
+}
+
+// newInitEnvironment returns an environment backing up all its workspaces and identities
+func newInitEnvironment(id, name string) initEnvironment {
+	return initEnvironment{
+		ID:         id,
+		Name:       name,
+		Workspaces: []initWorkspace{{ID: "*"}},
+		Identities: []string{"*"},
+	}
+}
+
+// parseSelection parses comma-separated numbers between 1 and n into distinct indexes, in order
+func parseSelection(answer string, n int) ([]int, error) {
+	var selected []int
+	seen := make(map[int]bool)
+	for _, part := range strings.Split(answer, ",") {
+		number, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || number < 1 || number > n {
+			return nil, fmt.Errorf("invalid selection %q, expected numbers between 1 and %d", strings.TrimSpace(part), n)
+		}
+		if !seen[number] {
+			seen[number] = true
+			selected = append(selected, number-1)
+		}
+	}
+	return selected, nil
+}
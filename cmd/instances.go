@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/plainid"
+)
+
+// instanceAlias selects a single PlainID instance with --instance, all of them are used if empty
+var instanceAlias string
+
+// plainIDInstance is a PlainID instance set up by the root command, with its environments resolved
+type plainIDInstance struct {
+	// alias names the directory of the instance in the backup, empty for the plainid section
+	alias   string
+	plainID config.PlainIDConfig
+	service *plainid.Service
+	cache   *plainid.ConditionalCache
+}
+
+// plainIDInstances are the instances set up by the root command
+var plainIDInstances []plainIDInstance
+
+// selectInstances returns the instance with the alias, or all instances if alias is empty
+func selectInstances(instances []config.PlainIDInstanceConfig, alias string) ([]config.PlainIDInstanceConfig, error) {
+	if alias == "" {
+		return instances, nil
+	}
+	for _, instance := range instances {
+		if instance.Alias == alias {
+			return []config.PlainIDInstanceConfig{instance}, nil
+		}
+	}
+	return nil, fmt.Errorf("instance %s not found in the configuration", alias)
+}
+
+// currentInstance returns the instance the PlainID configuration and service currently point to
+func currentInstance(alias string) plainIDInstance {
+	return plainIDInstance{
+		alias:   alias,
+		plainID: cfg.PlainID,
+		service: plainIDService,
+		cache:   conditionalCache,
+	}
+}
+
+// useInstance points the PlainID configuration and service to the instance
+func useInstance(instance plainIDInstance) {
+	cfg.PlainID = instance.plainID
+	plainIDService = instance.service
+	conditionalCache = instance.cache
+}
+
+// backupInstances returns the instances to back up or restore, the current one if the root
+// command has not set any up
+func backupInstances() []plainIDInstance {
+	if len(plainIDInstances) == 0 {
+		return []plainIDInstance{currentInstance("")}
+	}
+	return plainIDInstances
+}
+
+// instanceDir returns the directory of the instance in the backup in dir, dir itself for the
+// plainid section
+func instanceDir(dir string, instance plainIDInstance) string {
+	return filepath.Join(dir, instance.alias)
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+)
+
+// labelPrefix marks a label in commit and tag messages, like env: and ws: do
+const labelPrefix = "label:"
+
+// parseLabels validates key=value labels given on the command line
+func parseLabels(labels []string) ([]string, error) {
+	parsed := make([]string, 0, len(labels))
+	for _, label := range labels {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q: expected key=value", label)
+		}
+		// Messages are split on whitespace when listing backups
+		if strings.ContainsAny(label, " \t\n") {
+			return nil, fmt.Errorf("invalid label %q: whitespace is not allowed", label)
+		}
+		parsed = append(parsed, key+"="+value)
+	}
+	return parsed, nil
+}
+
+// messageLabels extracts the labels from a commit or tag message
+func messageLabels(message string) []string {
+	var labels []string
+	for _, part := range strings.Fields(message) {
+		if strings.HasPrefix(part, labelPrefix) {
+			labels = append(labels, strings.TrimPrefix(part, labelPrefix))
+		}
+	}
+	return labels
+}
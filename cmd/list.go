@@ -1,187 +1,327 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v5"
 	plumb "github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/plainid/git-backup/repository"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 )
 
 // listOptions holds command-specific options
 type listOptions struct {
-	envID string
-	wsID  string
+	envID        string
+	wsID         string
+	checkStale   time.Duration
+	labels       []string
+	limit        int
+	outputFormat string
 }
 
+// Output formats of the list command
+const (
+	outputFormatTable = "table"
+	outputFormatJSON  = "json"
+	outputFormatYAML  = "yaml"
+)
+
 var listOpts listOptions
 
-// tagInfo represents a filtered and parsed tag
+// recentBackups is the number of backups listed by list and offered by the interactive restore
+const recentBackups = 10
+
+// tagInfo represents a filtered and parsed tag, marshaled as a backup of the json and yaml output
 type tagInfo struct {
-	Name      string
-	EnvID     string
-	WsID      string
-	Timestamp string
-	Time      time.Time // For sorting
-	Message   string    // Tag message
+	Name         string    `json:"name" yaml:"name"`
+	Timestamp    string    `json:"-" yaml:"-"`
+	CreatedAt    time.Time `json:"created_at" yaml:"created_at"` // For sorting
+	Environments []string  `json:"environments" yaml:"environments"`
+	Workspaces   []string  `json:"workspaces" yaml:"workspaces"`
+	Message      string    `json:"message" yaml:"message"` // Tag message
+	Labels       []string  `json:"-" yaml:"-"`             // key=value labels given at backup time
+	Commit       string    `json:"-" yaml:"-"`             // Hash of the tagged commit
+	// WorkspacesByEnv and FileCount are only known for tags holding backup metadata
+	WorkspacesByEnv map[string][]string `json:"workspaces_by_env,omitempty" yaml:"workspaces_by_env,omitempty"`
+	FileCount       int                 `json:"file_count,omitempty" yaml:"file_count,omitempty"`
+}
+
+// hasWorkspace reports whether the backup holds the workspace of the environment
+func (t tagInfo) hasWorkspace(envID, wsID string) bool {
+	if t.WorkspacesByEnv != nil {
+		return slices.Contains(t.WorkspacesByEnv[envID], wsID)
+	}
+	// Older tags only have the generated message to search
+	return strings.Contains(t.Message, fmt.Sprintf("env:%s", envID)) &&
+		strings.Contains(t.Message, fmt.Sprintf("ws:%s", wsID))
 }
 
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List recent PlainID configuration backups",
 	Long:  `List last 10 backups without restoring anything.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch listOpts.outputFormat {
+		case outputFormatTable, outputFormatJSON, outputFormatYAML:
+		default:
+			return fmt.Errorf("output-format must be one of %s, %s or %s: %s",
+				outputFormatTable, outputFormatJSON, outputFormatYAML, listOpts.outputFormat)
+		}
+		if listOpts.limit < 1 {
+			return errors.New("limit must be at least 1")
+		}
+		return nil
+	},
 	RunE: func(cmd *cobra.Command, args []string) error {
 		log.Info().Msg("Executing list command")
 
+		labelFilters, err := parseLabels(listOpts.labels)
+		if err != nil {
+			return err
+		}
+
 		// Create temporary directory for git operations
-		tempDir, err := repository.CreateTempDir()
+		tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
 		if err != nil {
 			return err
 		}
 		defer repository.CleanupTempDir(tempDir)
 		log.Info().Msgf("Temporary directory created: %s", tempDir)
 
-		// Clone repository
-		log.Info().Msg("Fetching repository information...")
-		repo, err := repository.CloneRemote(cfg.Git.Repo, cfg.Git.Branch, cfg.Git.Token, tempDir)
+		filteredTags, err := listBackupTags(tempDir, listOpts.envID, listOpts.wsID, labelFilters)
 		if err != nil {
-			return fmt.Errorf("failed to access repository: %w", err)
+			return err
 		}
 
-		// Fetch to ensure we have all tags
-		err = repo.Fetch(&git.FetchOptions{
-			Auth: &http.BasicAuth{
-				Username: "oauth2",
-				Password: cfg.Git.Token,
-			},
-			Tags: git.AllTags,
-		})
-		// Ignore "already up-to-date" errors
-		if err != nil && err != git.NoErrAlreadyUpToDate {
-			log.Warn().Msgf("Fetch warning: %v", err)
+		// Display at most limit of the most recent tags
+		filteredTags = filteredTags[:min(len(filteredTags), listOpts.limit)]
+
+		if listOpts.outputFormat != outputFormatTable {
+			if err := writeTags(cmd.OutOrStdout(), listOpts.outputFormat, filteredTags); err != nil {
+				return err
+			}
+			return checkStale(cmd, filteredTags)
 		}
 
-		// Get all tags
-		tagsIter, err := repo.Tags()
-		if err != nil {
-			return fmt.Errorf("failed to list tags: %w", err)
+		// Display results
+		out := cmd.OutOrStdout()
+		if len(filteredTags) == 0 {
+			if listOpts.envID != "" && listOpts.wsID != "" {
+				fmt.Fprintf(out, "No backups found for environment %s and workspace %s\n",
+					listOpts.envID, listOpts.wsID)
+			} else {
+				fmt.Fprintln(out, "No backups found")
+			}
+			return checkStale(cmd, filteredTags)
 		}
 
-		var filteredTags []tagInfo
-		err = tagsIter.ForEach(func(ref *plumb.Reference) error {
-			tagName := ref.Name().Short()
+		// Header message
+		if listOpts.envID != "" && listOpts.wsID != "" {
+			fmt.Fprintf(out, "Recent backups for environment %s and workspace %s:\n\n",
+				listOpts.envID, listOpts.wsID)
+		} else {
+			fmt.Fprintln(out, "Recent backups:")
+		}
 
-			// Only process tags that match the timestamp format (20060102-150405)
-			parsedTime, err := time.Parse("20060102-150405", tagName)
-			if err != nil {
-				// Not a tag in our expected format, skip it
-				return nil
+		for i, tag := range filteredTags {
+			// Format timestamp for display if valid
+			displayTime := tag.Timestamp
+			if !tag.CreatedAt.IsZero() {
+				displayTime = tag.CreatedAt.Format("2006-01-02 15:04:05")
 			}
 
-			// Get the tag object to read the message
-			tagObj, err := repo.TagObject(ref.Hash())
-			var message string
-			if err == nil {
-				message = tagObj.Message
+			if len(tag.Environments) > 0 && len(tag.Workspaces) > 0 {
+				fmt.Fprintf(out, "%d. %s (env: %s, ws: %s, created: %s)\n",
+					i+1, tag.Name, strings.Join(tag.Environments, ","), strings.Join(tag.Workspaces, ","), displayTime)
+			} else {
+				fmt.Fprintf(out, "%d. %s (created: %s)\n", i+1, tag.Name, displayTime)
 			}
+		}
 
-			// Apply env/ws filters if specified
-			if listOpts.envID != "" && listOpts.wsID != "" {
-				// Check if the message contains the specified env and ws
-				envFilter := fmt.Sprintf("env:%s", listOpts.envID)
-				wsFilter := fmt.Sprintf("ws:%s", listOpts.wsID)
+		return checkStale(cmd, filteredTags)
+	},
+}
 
-				if !strings.Contains(message, envFilter) || !strings.Contains(message, wsFilter) {
-					return nil
-				}
+// listBackupTags clones the backup repository into tempDir, fetches all tags and returns the backup
+// tags matching the environment, workspace and label filters, newest first
+func listBackupTags(tempDir, envID, wsID string, labelFilters []string) ([]tagInfo, error) {
+	// Clone git.clone-depth commits, the latest only by default, the tags are fetched below. On large
+	// repositories the tag fetch of a shallow clone can fail, a full clone already has the tags.
+	log.Info().Msg("Fetching repository information...")
+	repo, err := repository.CloneRemoteViaProxy(cfg.Git.Repo, cfg.Git.Branch, gitAuth, tempDir, cfg.Git.SOCKSProxy, cfg.Git.Depth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to access repository: %w", err)
+	}
+
+	// Fetch to ensure we have all tags
+	err = repo.Fetch(&git.FetchOptions{
+		Auth:         gitAuth,
+		Tags:         git.AllTags,
+		ProxyOptions: repository.ProxyOptions(cfg.Git.SOCKSProxy),
+	})
+	// Ignore "already up-to-date" errors
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		log.Warn().Msgf("Fetch warning: %v", err)
+	}
+
+	// Get all tags
+	tagsIter, err := repo.Tags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	var filteredTags []tagInfo
+	err = tagsIter.ForEach(func(ref *plumb.Reference) error {
+		tagName := ref.Name().Short()
+
+		// Get the tag object to read the message
+		tagObj, err := repo.TagObject(ref.Hash())
+		var message string
+		commit := ref.Hash()
+		if err == nil {
+			message = tagObj.Message
+			commit = tagObj.Target
+		}
+
+		// Only process tags that match git.tag-format, named in git.tag-timezone, see backupCmd
+		parsedTime, ok := cfg.Git.ParseTagTime(tagName)
+		if !ok {
+			// Semantic version tags (backup --semantic-version) are dated by their tagger
+			if _, ok := repository.ParseSemVer(tagName); !ok || tagObj == nil {
+				// Not a tag in our expected format, skip it
+				return nil
 			}
+			parsedTime = tagObj.Tagger.When
+		}
 
+		tag := tagInfo{
+			Name:      tagName,
+			Timestamp: tagName,
+			CreatedAt: parsedTime,
+			Message:   message,
+			Commit:    commit.String(),
+		}
+		if metadata, ok := parseTagMetadata(message); ok {
+			tag.Environments = metadata.Environments
+			for _, env := range metadata.Environments {
+				tag.Workspaces = append(tag.Workspaces, metadata.Workspaces[env]...)
+			}
+			tag.WorkspacesByEnv = metadata.Workspaces
+			tag.FileCount = metadata.FileCount
+			tag.Labels = metadata.Labels
+		} else {
 			// Parse env and ws IDs from message for display
-			var envIDs, wsIDs []string
-			msgParts := strings.Split(message, " ")
-			for _, part := range msgParts {
+			for _, part := range strings.Fields(message) {
 				if strings.HasPrefix(part, "env:") {
-					envIDs = append(envIDs, strings.TrimPrefix(part, "env:"))
+					tag.Environments = append(tag.Environments, strings.TrimPrefix(part, "env:"))
 				} else if strings.HasPrefix(part, "ws:") {
-					wsIDs = append(wsIDs, strings.TrimPrefix(part, "ws:"))
+					tag.Workspaces = append(tag.Workspaces, strings.TrimPrefix(part, "ws:"))
 				}
 			}
+			tag.Labels = messageLabels(message)
+		}
 
-			// Add tag to the filtered list
-			filteredTags = append(filteredTags, tagInfo{
-				Name:      tagName,
-				Timestamp: tagName,
-				Time:      parsedTime,
-				Message:   message,
-				EnvID:     strings.Join(envIDs, ","),
-				WsID:      strings.Join(wsIDs, ","),
-			})
-
+		// Apply env/ws filters if specified
+		if envID != "" && wsID != "" && !tag.hasWorkspace(envID, wsID) {
 			return nil
-		})
-
-		if err != nil {
-			return fmt.Errorf("error processing tags: %w", err)
 		}
 
-		// Sort tags by timestamp (newest first)
-		sort.Slice(filteredTags, func(i, j int) bool {
-			return filteredTags[i].Time.After(filteredTags[j].Time)
-		})
-
-		// Display results
-		if len(filteredTags) == 0 {
-			if listOpts.envID != "" && listOpts.wsID != "" {
-				fmt.Printf("No backups found for environment %s and workspace %s\n",
-					listOpts.envID, listOpts.wsID)
-			} else {
-				fmt.Println("No backups found")
+		// Apply label filters if specified, all labels must be present
+		for _, labelFilter := range labelFilters {
+			if !slices.Contains(tag.Labels, labelFilter) {
+				return nil
 			}
-			return nil
 		}
 
-		// Header message
-		if listOpts.envID != "" && listOpts.wsID != "" {
-			fmt.Printf("Recent backups for environment %s and workspace %s:\n\n",
-				listOpts.envID, listOpts.wsID)
-		} else {
-			fmt.Println("Recent backups:")
-		}
+		// Add tag to the filtered list
+		filteredTags = append(filteredTags, tag)
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("error processing tags: %w", err)
+	}
 
-		// Display at most 10 most recent tags
-		limit := 10
-		if len(filteredTags) < limit {
-			limit = len(filteredTags)
+	// Sort tags by timestamp (newest first), tags created within the same second by name
+	sort.Slice(filteredTags, func(i, j int) bool {
+		if filteredTags[i].CreatedAt.Equal(filteredTags[j].CreatedAt) {
+			return filteredTags[i].Name > filteredTags[j].Name
 		}
+		return filteredTags[i].CreatedAt.After(filteredTags[j].CreatedAt)
+	})
 
-		for i := 0; i < limit; i++ {
-			tag := filteredTags[i]
-			// Format timestamp for display if valid
-			displayTime := tag.Timestamp
-			if !tag.Time.IsZero() {
-				displayTime = tag.Time.Format("2006-01-02 15:04:05")
-			}
+	return filteredTags, nil
+}
 
-			if tag.EnvID != "" && tag.WsID != "" {
-				fmt.Printf("%d. %s (env: %s, ws: %s, created: %s)\n",
-					i+1, tag.Name, tag.EnvID, tag.WsID, displayTime)
-			} else {
-				fmt.Printf("%d. %s (created: %s)\n", i+1, tag.Name, displayTime)
-			}
+// writeTags writes the tags to out as a JSON or YAML list
+func writeTags(out io.Writer, format string, tags []tagInfo) error {
+	// An empty list rather than null when no backup matches
+	if tags == nil {
+		tags = []tagInfo{}
+	}
+
+	if format == outputFormatYAML {
+		encoder := yaml.NewEncoder(out)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(tags); err != nil {
+			return fmt.Errorf("failed to write backups as YAML: %w", err)
 		}
+		return encoder.Close()
+	}
 
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(tags); err != nil {
+		return fmt.Errorf("failed to write backups as JSON: %w", err)
+	}
+	return nil
+}
+
+// checkStale returns an error with the backup stale exit code when the newest tag
+// is older than the --check-stale duration. Tags must be sorted newest first. The warning
+// goes to stderr so the JSON and YAML output stay parsable.
+func checkStale(cmd *cobra.Command, tags []tagInfo) error {
+	if listOpts.checkStale <= 0 {
 		return nil
-	},
+	}
+
+	// Staleness is a monitoring result, not a usage problem
+	cmd.SilenceUsage = true
+
+	if len(tags) == 0 {
+		fmt.Fprintf(cmd.ErrOrStderr(), "WARNING: no backup found, expected one within %s\n", listOpts.checkStale)
+		return &exitCodeError{code: ExitCodeBackupStale, err: errors.New("no backup found")}
+	}
+
+	age := time.Since(tags[0].CreatedAt)
+	if age > listOpts.checkStale {
+		fmt.Fprintf(cmd.ErrOrStderr(), "WARNING: latest backup %s is %s old, older than %s\n",
+			tags[0].Name, age.Round(time.Second), listOpts.checkStale)
+		return &exitCodeError{
+			code: ExitCodeBackupStale,
+			err:  fmt.Errorf("latest backup %s is stale", tags[0].Name),
+		}
+	}
+
+	return nil
 }
 
 func init() {
 	// Add list-specific flags
 	listCmd.Flags().StringVar(&listOpts.envID, "env-id", "", "Filter backups by environment ID")
 	listCmd.Flags().StringVar(&listOpts.wsID, "ws-id", "", "Filter backups by workspace ID")
+	listCmd.Flags().StringArrayVar(&listOpts.labels, "label", nil, "Filter backups by key=value label (can be repeated)")
+	listCmd.Flags().IntVar(&listOpts.limit, "limit", recentBackups, "Maximum number of backups to list")
+	listCmd.Flags().StringVar(&listOpts.outputFormat, "output-format", outputFormatTable, "Output format: table, json or yaml")
+	listCmd.Flags().DurationVar(&listOpts.checkStale, "check-stale", 0, "Exit with code 2 if the latest backup is older than this duration (e.g. 25h)")
 }
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Log formats of --log-format
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var (
+	logFormat = enumFlag{value: logFormatText, allowed: []string{logFormatText, logFormatJSON}}
+	logLevel  = enumFlag{value: zerolog.LevelInfoValue, allowed: []string{
+		zerolog.LevelDebugValue, zerolog.LevelInfoValue, zerolog.LevelWarnValue, zerolog.LevelErrorValue,
+	}}
+)
+
+// enumFlag is a string flag restricted to a set of values, checked when the flags are parsed
+type enumFlag struct {
+	value   string
+	allowed []string
+}
+
+func (f *enumFlag) String() string {
+	return f.value
+}
+
+func (f *enumFlag) Set(value string) error {
+	if !slices.Contains(f.allowed, value) {
+		return fmt.Errorf("must be one of %s", strings.Join(f.allowed, ", "))
+	}
+	f.value = value
+	return nil
+}
+
+func (f *enumFlag) Type() string {
+	return "string"
+}
+
+// configureLogging applies --log-format and --log-level, before any command runs
+func configureLogging() {
+	if logFormat.value == logFormatJSON {
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	} else {
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+	}
+
+	// Only allowed values get past the flag parsing
+	level, _ := zerolog.ParseLevel(logLevel.value)
+	zerolog.SetGlobalLevel(level)
+}
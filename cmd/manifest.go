@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/plainid/git-backup/config"
+)
+
+// manifestFileName lists the files of a backup with their checksums, at the root of every backup
+const manifestFileName = "manifest.json"
+
+// backupManifest describes a backup and the SHA-256 digest of each of its files
+type backupManifest struct {
+	Timestamp    time.Time                     `json:"timestamp"`
+	CommitAuthor string                        `json:"commit_author"`
+	Environments []manifestResource            `json:"environments"`
+	Workspaces   map[string][]manifestResource `json:"workspaces"`
+	FileCount    int                           `json:"file_count"`
+	// Labels are the key=value labels given with --label
+	Labels []string `json:"labels,omitempty"`
+	// Files maps the path of every file relative to the repository root to its hex SHA-256 digest
+	Files map[string]string `json:"files"`
+}
+
+// manifestResource is an environment or workspace of the manifest
+type manifestResource struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// manifestMismatch is a file of the manifest that no longer has its recorded digest
+type manifestMismatch struct {
+	Path string
+	// Problem is why the file does not match, a missing file or a different digest
+	Problem string
+}
+
+// writeManifest writes the manifest of the backup in dir, covering every file of the backup
+// except the git metadata and the manifest itself, along with the labels of the backup
+func writeManifest(dir string, backupTime time.Time, envs []config.Environment, labels []string) error {
+	files, err := checksumFiles(dir)
+	if err != nil {
+		return err
+	}
+
+	author := commitSignature()
+	manifest := backupManifest{
+		Timestamp:    backupTime.UTC(),
+		CommitAuthor: fmt.Sprintf("%s <%s>", author.Name, author.Email),
+		Environments: make([]manifestResource, 0, len(envs)),
+		Workspaces:   make(map[string][]manifestResource, len(envs)),
+		FileCount:    len(files),
+		Labels:       labels,
+		Files:        files,
+	}
+	for _, env := range envs {
+		manifest.Environments = append(manifest.Environments, manifestResource{ID: env.ID, Name: env.Name})
+		workspaces := make([]manifestResource, 0, len(env.Workspaces))
+		for _, ws := range env.Workspaces {
+			workspaces = append(workspaces, manifestResource{ID: ws.ID, Name: ws.Name})
+		}
+		manifest.Workspaces[env.ID] = workspaces
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(dir, manifestFileName), formatJSON(string(data))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// checksumFiles returns the hex SHA-256 digest of every file of the backup in dir by slash-separated
+// path relative to dir, without the git metadata and the manifest
+func checksumFiles(dir string) (map[string]string, error) {
+	files := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == git.GitDirName {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel == manifestFileName {
+			return nil
+		}
+		digest, err := fileChecksum(path)
+		if err != nil {
+			return err
+		}
+		files[rel] = digest
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute backup checksums: %w", err)
+	}
+	return files, nil
+}
+
+// fileChecksum returns the hex SHA-256 digest of the file at path
+func fileChecksum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// readManifest reads the manifest of the backup in dir
+func readManifest(dir string) (*backupManifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("backup has no %s, it was taken before manifests were written", manifestFileName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest backupManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// verifyManifest recomputes the digest of every file listed in the manifest of the backup in dir
+// and returns the files that are missing or changed, sorted by path
+func verifyManifest(dir string, manifest *backupManifest) ([]manifestMismatch, error) {
+	paths := make([]string, 0, len(manifest.Files))
+	for path := range manifest.Files {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	var mismatches []manifestMismatch
+	for _, path := range paths {
+		digest, err := fileChecksum(filepath.Join(dir, filepath.FromSlash(path)))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			mismatches = append(mismatches, manifestMismatch{Path: path, Problem: "file is missing"})
+		case err != nil:
+			return nil, fmt.Errorf("failed to compute checksum of %s: %w", path, err)
+		case digest != manifest.Files[path]:
+			mismatches = append(mismatches, manifestMismatch{
+				Path:    path,
+				Problem: fmt.Sprintf("checksum %s does not match %s", digest, manifest.Files[path]),
+			})
+		}
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// pruneOptions holds command-specific options
+type pruneOptions struct {
+	keepLast  int
+	olderThan time.Duration
+}
+
+var pruneOpts pruneOptions
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Delete old backup tags",
+	Long: `Delete the backup tags beyond the most recent ones from the remote repository.
+The commits of deleted tags stay in the history of the backup branch.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if pruneOpts.keepLast < 0 {
+			return errors.New("keep-last must not be negative")
+		}
+		if pruneOpts.olderThan < 0 {
+			return errors.New("older-than must not be negative")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Int("keepLast", pruneOpts.keepLast).Dur("olderThan", pruneOpts.olderThan).Msg("Executing prune command")
+
+		tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(tempDir)
+
+		tags, err := listBackupTags(tempDir, "", "", nil)
+		if err != nil {
+			return err
+		}
+		candidates := pruneCandidates(tags, pruneOpts.keepLast, pruneOpts.olderThan, time.Now())
+		if len(candidates) == 0 {
+			log.Info().Msg("No backup tags to prune")
+			return nil
+		}
+
+		if cfg.DryRun {
+			log.Info().Msgf("Dry run mode: %d backup tags would be deleted", len(candidates))
+			for _, tag := range candidates {
+				fmt.Fprintln(cmd.OutOrStdout(), tag.Name)
+			}
+			return nil
+		}
+
+		repo, err := git.PlainOpen(tempDir)
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+		if err := deleteRemoteTags(repo, candidates); err != nil {
+			return err
+		}
+		for _, tag := range candidates {
+			fmt.Fprintln(cmd.OutOrStdout(), tag.Name)
+		}
+		log.Info().Msgf("%d backup tags deleted", len(candidates))
+		return nil
+	},
+}
+
+// pruneCandidates returns the timestamp tags to delete among tags sorted newest first: those beyond
+// the keepLast most recent ones, and older than olderThan when it is set. Semantic version tags are
+// never pruned.
+func pruneCandidates(tags []tagInfo, keepLast int, olderThan time.Duration, now time.Time) []tagInfo {
+	var candidates []tagInfo
+	kept := 0
+	for _, tag := range tags {
+		if _, ok := cfg.Git.ParseTagTime(tag.Name); !ok {
+			continue
+		}
+		if kept < keepLast {
+			kept++
+			continue
+		}
+		if olderThan > 0 && now.Sub(tag.CreatedAt) <= olderThan {
+			continue
+		}
+		candidates = append(candidates, tag)
+	}
+	return candidates
+}
+
+// deleteRemoteTags deletes the tags from the remote in a single push. Tags that are already
+// gone from the remote are skipped by the push, so deleting them again is not an error.
+func deleteRemoteTags(repo *git.Repository, tags []tagInfo) error {
+	refSpecs := make([]gitconfig.RefSpec, 0, len(tags))
+	for _, tag := range tags {
+		refSpecs = append(refSpecs, gitconfig.RefSpec(fmt.Sprintf(":refs/tags/%s", tag.Name)))
+	}
+
+	err := repo.Push(&git.PushOptions{
+		Auth:         gitAuth,
+		RefSpecs:     refSpecs,
+		ProxyOptions: repository.ProxyOptions(cfg.Git.SOCKSProxy),
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return fmt.Errorf("failed to delete tags from remote: %w", err)
+	}
+	return nil
+}
+
+func init() {
+	pruneCmd.Flags().IntVar(&pruneOpts.keepLast, "keep-last", 30, "Number of most recent backup tags to keep")
+	pruneCmd.Flags().DurationVar(&pruneOpts.olderThan, "older-than", 0, "Only delete backup tags older than this duration (e.g. 720h), in addition to keep-last")
+}
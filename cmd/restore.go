@@ -1,16 +1,18 @@
 package cmd
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/transport/http"
 	"github.com/plainid/git-backup/config"
 	"github.com/plainid/git-backup/repository"
 	"github.com/rs/zerolog/log"
@@ -22,6 +24,9 @@ var (
 	restoreTargetDir string
 	restoreEnvID     string
 	restoreWsID      string
+	restorePush      bool
+	// restoreAppsOnly limits the push to the application definitions, without their policies
+	restoreAppsOnly bool
 )
 
 var restoreCmd = &cobra.Command{
@@ -29,17 +34,16 @@ var restoreCmd = &cobra.Command{
 	Short: "Restore PlainID configuration from git",
 	Long:  `List recent backups and provide selection to restore from.`,
 	PreRunE: func(cmd *cobra.Command, args []string) error {
-		// Validate that tag and target-dir are only used together in non-interactive mode
-		if restoreTag != "" {
-			// Non-interactive mode - target-dir is required
-			if restoreTargetDir == "" {
+		// The selected backup is checked out into the target directory, in both modes
+		if restoreTargetDir == "" {
+			if restoreTag != "" {
 				return errors.New("target-dir is required when tag is specified (non-interactive mode)")
 			}
+			return errors.New("target-dir is required, the selected backup is checked out into it")
 		}
 
-		// In dry-run mode, we need both tag and target-dir
-		if cfg.DryRun && (restoreTag == "" || restoreTargetDir == "") {
-			return errors.New("both tag and target-dir parameters are required when using dry-run with restore")
+		if restoreAppsOnly && !restorePush {
+			return errors.New("restore-apps-only requires push-to-plainid")
 		}
 
 		// Validate env-id and ws-id if they're provided
@@ -52,122 +56,256 @@ var restoreCmd = &cobra.Command{
 	RunE: func(cmd *cobra.Command, args []string) error {
 		log.Info().Msg("Executing restore command")
 
-		// Check if we're in non-interactive mode (tag is specified)
-		if restoreTag != "" {
-			log.Info().Str("tag", restoreTag).Msg("Non-interactive mode: Restoring from specific tag")
-			log.Info().Str("targetDir", restoreTargetDir).Msg("Target directory specified")
+		tag := restoreTag
+		var confirm func(restoreSummary) (bool, error)
+		if tag == "" {
+			log.Info().Msg("Interactive mode: Will present most recent backups for selection")
+			// Menus and questions go to stderr, stdout only gets the selected tag
+			in := bufio.NewReader(cmd.InOrStdin())
+			selected, err := selectBackupTag(in, cmd.ErrOrStderr())
+			if err != nil {
+				return err
+			}
+			if selected == "" {
+				log.Info().Msg("No backup selected, nothing restored")
+				return nil
+			}
+			tag = selected
+			fmt.Fprintln(cmd.OutOrStdout(), tag)
 
-			// Create target directory if it doesn't exist
-			if err := os.MkdirAll(restoreTargetDir, 0755); err != nil {
-				return fmt.Errorf("failed to create target directory: %w", err)
+			// Once the backup is checked out, it is previewed before anything is copied
+			confirm = func(summary restoreSummary) (bool, error) {
+				return confirmRestore(in, cmd.ErrOrStderr(), summary)
 			}
+		}
+
+		if err := restoreFromTag(cmd.Context(), tag, confirm); err != nil {
+			return err
+		}
+
+		log.Info().Msg("Restore operation completed")
+		return nil
+	},
+}
+
+// restoreFromTag checks out the backup tag into the target directory, only the configured
+// environment and workspace when filtered. When confirm is set, it is asked to confirm the
+// backup before anything is copied. With --push-to-plainid the policies are imported into PlainID afterwards.
+func restoreFromTag(ctx context.Context, tag string, confirm func(restoreSummary) (bool, error)) error {
+	log.Info().Str("tag", tag).Msg("Restoring from tag")
+	log.Info().Str("targetDir", restoreTargetDir).Msg("Target directory specified")
+
+	// Use temporary directory for git checkout
+	tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return err
+	}
+	defer repository.CleanupTempDir(tempDir)
+
+	log.Info().Str("tempDir", tempDir).Msg("Temporary directory created")
+
+	repo, err := cloneAndCheckoutTag(tempDir, tag)
+	if err != nil {
+		return err
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to resolve checked out tag '%s': %w", tag, err)
+	}
+	log.Info().Str("tag", tag).Str("commit", head.Hash().String()).Msg("Tag checked out")
 
-			// Use temporary directory for git checkout
-			tempDir, err := repository.CreateTempDir()
+	if confirm != nil {
+		summary, err := summarizeRestore(tempDir)
+		if err != nil {
+			return err
+		}
+		confirmed, err := confirm(summary)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			log.Info().Str("tag", tag).Msg("Restore cancelled, nothing copied")
+			return nil
+		}
+	}
+
+	// Create target directory if it doesn't exist
+	if err := os.MkdirAll(restoreTargetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+
+	// Instances are restored with their own configuration, the current one is set back afterwards
+	shared := currentInstance("")
+	defer useInstance(shared)
+
+	// If env-id and ws-id are provided, only copy those specific directories
+	if restoreEnvID != "" && restoreWsID != "" {
+		log.Info().Str("envID", restoreEnvID).Str("wsID", restoreWsID).Msg("Filtering by environment and workspace")
+
+		found := false
+		for _, instance := range backupInstances() {
+			useInstance(instance)
+			found, err = restoreWorkspace(instanceDir(tempDir, instance), instanceDir(restoreTargetDir, instance))
 			if err != nil {
 				return err
 			}
-			defer repository.CleanupTempDir(tempDir)
-
-			log.Info().Str("tempDir", tempDir).Msg("Temporary directory created")
+			if found {
+				break
+			}
+		}
 
-			// If env-id and ws-id are provided, only copy those specific directories
-			if restoreEnvID != "" && restoreWsID != "" {
-				log.Info().Str("envID", restoreEnvID).Str("wsID", restoreWsID).Msg("Filtering by environment and workspace")
+		if !found {
+			return fmt.Errorf("could not find configuration for environment '%s' and workspace '%s' in tag '%s'", restoreEnvID, restoreWsID, tag)
+		}
+	} else {
+		// Copy everything from the tag to the target directory, or the selected instance only
+		log.Info().Msg("No environment/workspace filter specified, copying all configuration")
+
+		srcDir, dstDir := tempDir, restoreTargetDir
+		if instanceAlias != "" {
+			instance := backupInstances()[0]
+			srcDir, dstDir = instanceDir(tempDir, instance), instanceDir(restoreTargetDir, instance)
+		}
 
-				// Find the matching environment directory
-				found := false
+		summary, err := summarizeRestore(srcDir)
+		if err != nil {
+			return err
+		}
+		log.Info().Msgf("Restoring %s", summary)
 
-				entries, err := os.ReadDir(tempDir)
-				if err != nil {
-					return fmt.Errorf("failed to read temp directory: %w", err)
-				}
+		if err := os.MkdirAll(dstDir, 0755); err != nil {
+			return fmt.Errorf("failed to create target directory: %w", err)
+		}
+		entries, err := os.ReadDir(srcDir)
+		if err != nil {
+			return fmt.Errorf("failed to read temp directory: %w", err)
+		}
 
-				for _, entry := range entries {
-					if entry.IsDir() && strings.HasSuffix(entry.Name(), "_"+restoreEnvID) {
-						envDir := filepath.Join(tempDir, entry.Name())
-
-						// Check for workspace within this environment
-						wsEntries, err := os.ReadDir(envDir)
-						if err != nil {
-							return fmt.Errorf("failed to read environment directory: %w", err)
-						}
-
-						for _, wsEntry := range wsEntries {
-							// Find matching workspace directory
-							if wsEntry.IsDir() {
-								wsDir := filepath.Join(envDir, wsEntry.Name())
-
-								// Get workspace ID from configuration or use directory name
-								// For now, we assume workspace directory name is the workspace name
-								ws := findWorkspaceByNameOrID(restoreEnvID, restoreWsID, wsEntry.Name())
-								if ws != nil {
-									// Copy entire workspace directory to target
-									log.Info().Str("source", wsDir).Str("target", restoreTargetDir).Msg("Copying workspace directory")
-
-									if err := copyDir(wsDir, restoreTargetDir); err != nil {
-										return fmt.Errorf("failed to copy workspace directory: %w", err)
-									}
-
-									found = true
-									break
-								}
-							}
-						}
-
-						// Also copy environment-level files
-						if found {
-							// Copy environment-level files (templates, etc.)
-							envFiles, err := os.ReadDir(envDir)
-							if err != nil {
-								return fmt.Errorf("failed to read environment directory files: %w", err)
-							}
-
-							for _, file := range envFiles {
-								if !file.IsDir() {
-									srcFile := filepath.Join(envDir, file.Name())
-									dstFile := filepath.Join(restoreTargetDir, file.Name())
-									log.Info().Str("source", srcFile).Str("target", dstFile).Msg("Copying environment file")
-
-									if err := copyFile(srcFile, dstFile); err != nil {
-										return fmt.Errorf("failed to copy environment file: %w", err)
-									}
-								}
-							}
-
-							break
-						}
-					}
-				}
+		for _, entry := range entries {
+			// Skip the git metadata of the checkout
+			if entry.Name() == git.GitDirName {
+				continue
+			}
 
-				if !found {
-					return fmt.Errorf("could not find configuration for environment '%s' and workspace '%s' in tag '%s'", restoreEnvID, restoreWsID, restoreTag)
-				}
+			src := filepath.Join(srcDir, entry.Name())
+			dst := filepath.Join(dstDir, entry.Name())
+			if entry.IsDir() {
+				err = copyDir(src, dst)
 			} else {
-				// Copy everything from the tag to the target directory
-				log.Info().Msg("No environment/workspace filter specified, copying all configuration")
+				err = copyFile(src, dst)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy configuration: %w", err)
+			}
+		}
+	}
 
-				if err := copyDir(tempDir, restoreTargetDir); err != nil {
-					return fmt.Errorf("failed to copy configuration: %w", err)
-				}
+	if cfg.DryRun {
+		log.Info().Msg("Dry run mode: Configuration has been checked out to target directory, but will not be processed further")
+	} else {
+		log.Info().Msg("Configuration has been checked out to target directory")
+	}
+
+	if restorePush {
+		// Every instance gets the applications and policies of its own directory
+		for _, instance := range backupInstances() {
+			useInstance(instance)
+			dir := instanceDir(tempDir, instance)
+			apps, err := backupApplications(dir)
+			if err != nil {
+				return err
+			}
+			// Policies are imported into their application, which must exist first
+			appIDs, err := pushApplications(ctx, apps)
+			if err != nil {
+				return err
+			}
+			if restoreAppsOnly {
+				continue
 			}
 
-			if cfg.DryRun {
-				log.Info().Msg("Dry run mode: Configuration has been checked out to target directory, but will not be processed further")
-			} else {
-				log.Info().Msg("Configuration has been checked out to target directory")
-				// TODO: When restore to PlainID is implemented, add code here to upload the configuration
+			policies, err := backupPolicies(dir)
+			if err != nil {
+				return err
+			}
+			if err := pushPolicies(ctx, pushedPolicies(policies, appIDs)); err != nil {
+				return err
 			}
-		} else {
-			log.Info().Msg("Interactive mode: Will present most recent backups for selection")
-			// TODO: Implement interactive mode by showing recent tags and allowing selection
-			// This can be implemented using the list command functionality
-			return errors.New("interactive mode is not yet implemented")
 		}
+	}
+	return nil
+}
 
-		log.Info().Msg("Restore operation completed")
-		return nil
-	},
+// restoreWorkspace copies the filtered workspace of the backup in dir, along with the files of its
+// environment, into targetDir and reports whether the workspace was found
+func restoreWorkspace(dir, targetDir string) (bool, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		// The instance was not backed up in this backup
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read temp directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || !strings.HasSuffix(entry.Name(), "_"+restoreEnvID) {
+			continue
+		}
+		envDir := filepath.Join(dir, entry.Name())
+
+		// Check for workspace within this environment
+		wsEntries, err := os.ReadDir(envDir)
+		if err != nil {
+			return false, fmt.Errorf("failed to read environment directory: %w", err)
+		}
+
+		found := false
+		for _, wsEntry := range wsEntries {
+			// Find matching workspace directory
+			if !wsEntry.IsDir() {
+				continue
+			}
+			wsDir := filepath.Join(envDir, wsEntry.Name())
+
+			// The workspace directory is named after the workspace
+			ws := findWorkspaceByNameOrID(restoreEnvID, restoreWsID, wsEntry.Name())
+			if ws != nil {
+				// Copy entire workspace directory to target
+				log.Info().Str("source", wsDir).Str("target", targetDir).Msg("Copying workspace directory")
+
+				if err := copyDir(wsDir, targetDir); err != nil {
+					return false, fmt.Errorf("failed to copy workspace directory: %w", err)
+				}
+
+				found = true
+				break
+			}
+		}
+		if !found {
+			continue
+		}
+
+		// Also copy environment-level files (templates, etc.)
+		envFiles, err := os.ReadDir(envDir)
+		if err != nil {
+			return false, fmt.Errorf("failed to read environment directory files: %w", err)
+		}
+		for _, file := range envFiles {
+			if !file.IsDir() {
+				srcFile := filepath.Join(envDir, file.Name())
+				dstFile := filepath.Join(targetDir, file.Name())
+				log.Info().Str("source", srcFile).Str("target", dstFile).Msg("Copying environment file")
+
+				if err := copyFile(srcFile, dstFile); err != nil {
+					return false, fmt.Errorf("failed to copy environment file: %w", err)
+				}
+			}
+		}
+		return true, nil
+	}
+	return false, nil
 }
 
 // cloneAndCheckoutTag clones the repository and checks out the specified tag
@@ -176,11 +314,9 @@ func cloneAndCheckoutTag(tempDir, tag string) (*git.Repository, error) {
 
 	// Clone the repository with default branch
 	repo, err := git.PlainClone(tempDir, false, &git.CloneOptions{
-		URL: cfg.Git.Repo,
-		Auth: &http.BasicAuth{
-			Username: "oauth2",
-			Password: cfg.Git.Token,
-		},
+		URL:          cfg.Git.Repo,
+		Auth:         gitAuth,
+		ProxyOptions: repository.ProxyOptions(cfg.Git.SOCKSProxy),
 	})
 
 	if err != nil {
@@ -215,16 +351,20 @@ func findWorkspaceByNameOrID(envID, wsID, wsName string) *config.Workspace {
 		return nil
 	}
 
-	// Check if workspace ID matches or if there's a wildcard
+	// Check if a configured workspace matches, the directory is named after the workspace
 	for i, ws := range env.Workspaces {
-		if ws.ID == wsID || ws.ID == "*" || (ws.Name == wsName && (wsID == "" || ws.ID == wsID)) {
+		if ws.ID == "*" || ws.ID != wsID {
+			continue
+		}
+		if ws.Name == "" || ws.Name == wsName {
 			return &env.Workspaces[i]
 		}
+		log.Debug().Str("wsID", wsID).Str("wsName", wsName).Msg("Directory does not belong to the workspace")
+		return nil
 	}
 
-	// If we have a wildcard environment and still haven't found a match, it's likely a configuration issue
+	// Workspaces of a wildcard are only known by ID, assume the directory belongs to the workspace
 	if env.HasWildcardWorkspace() {
-		// Create a temporary workspace with the provided ID
 		return &config.Workspace{ID: wsID, Name: wsName}
 	}
 
@@ -306,4 +446,6 @@ func init() {
 	restoreCmd.Flags().StringVar(&restoreTargetDir, "target-dir", "", "Target directory to check out configuration (for manual restoration)")
 	restoreCmd.Flags().StringVar(&restoreEnvID, "env-id", "", "Environment ID to restore for (optional, for filtering)")
 	restoreCmd.Flags().StringVar(&restoreWsID, "ws-id", "", "Workspace ID to restore for (optional, for filtering)")
+	restoreCmd.Flags().BoolVar(&restorePush, "push-to-plainid", false, "Import the restored applications and policies into PlainID")
+	restoreCmd.Flags().BoolVar(&restoreAppsOnly, "restore-apps-only", false, "Only push the application definitions, not their policies (with --push-to-plainid)")
 }
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// restoreCommandsFileName is the script at the root of every backup listing the commands to restore it
+const restoreCommandsFileName = "restore-commands.sh"
+
+// restoreCommandsTemplate generates restore-commands.sh, a dry run of every restore precedes the restore itself
+var restoreCommandsTemplate = template.Must(template.New(restoreCommandsFileName).Funcs(template.FuncMap{
+	"quote": shellQuote,
+}).Parse(`#!/bin/sh
+# Commands to restore the backup {{ .Tag }}, generated by git-backup.
+# Every restore is preceded by a dry run, check its output before running the restore.
+set -e
+{{ range .Envs }}
+# Environment {{ .Name }} ({{ .ID }})
+{{- range .Workspaces }}
+
+# Workspace {{ .Name }} ({{ .ID }})
+git-backup restore --dry-run --tag {{ quote $.Tag }} --target-dir {{ quote .TargetDir }} --env-id {{ quote .EnvID }} --ws-id {{ quote .ID }}{{ with .Instance }} --instance {{ quote . }}{{ end }}
+git-backup restore --tag {{ quote $.Tag }} --target-dir {{ quote .TargetDir }} --env-id {{ quote .EnvID }} --ws-id {{ quote .ID }}{{ with .Instance }} --instance {{ quote . }}{{ end }}
+{{- end }}
+{{ end -}}
+`))
+
+// restoreCommandsWorkspace is a workspace of restore-commands.sh
+type restoreCommandsWorkspace struct {
+	ID        string
+	Name      string
+	EnvID     string
+	TargetDir string
+	// Instance is the alias of the PlainID instance, empty for the plainid section
+	Instance string
+}
+
+// restoreCommandsEnv is an environment of restore-commands.sh
+type restoreCommandsEnv struct {
+	ID         string
+	Name       string
+	Workspaces []restoreCommandsWorkspace
+}
+
+// writeRestoreCommands writes the restore commands of every environment and workspace of the
+// instances for the backup tag to the backup in dir
+func writeRestoreCommands(dir, tag string, instances []plainIDInstance) error {
+	data := struct {
+		Tag  string
+		Envs []restoreCommandsEnv
+	}{Tag: tag}
+	for _, instance := range instances {
+		for _, env := range instance.plainID.Envs {
+			restoreEnv := restoreCommandsEnv{ID: env.ID, Name: env.Name}
+			for _, ws := range env.Workspaces {
+				restoreEnv.Workspaces = append(restoreEnv.Workspaces, restoreCommandsWorkspace{
+					ID:        ws.ID,
+					Name:      ws.Name,
+					EnvID:     env.ID,
+					TargetDir: filepath.Join("restore", tag, instance.alias, env.Name+"_"+env.ID, ws.Name),
+					Instance:  instance.alias,
+				})
+			}
+			data.Envs = append(data.Envs, restoreEnv)
+		}
+	}
+
+	var script bytes.Buffer
+	if err := restoreCommandsTemplate.Execute(&script, data); err != nil {
+		return fmt.Errorf("failed to generate restore commands: %w", err)
+	}
+
+	if err := writeBackupFile(filepath.Join(dir, restoreCommandsFileName), script.Bytes()); err != nil {
+		return fmt.Errorf("failed to write restore commands: %w", err)
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single shell word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/plainid/git-backup/repository"
+)
+
+// restoreSummary counts what a checked out backup would restore
+type restoreSummary struct {
+	Environments int
+	Workspaces   int
+	Applications int
+	Policies     int
+	Files        int
+	// Tree lists the environment, workspace and application directories, indented by depth
+	Tree []string
+}
+
+// summarizeRestore walks a checked out backup and counts its environments, workspaces,
+// applications, policies and files
+func summarizeRestore(dir string) (restoreSummary, error) {
+	var summary restoreSummary
+
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == dir {
+			return nil
+		}
+		if d.IsDir() && d.Name() == git.GitDirName {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		depth := strings.Count(rel, string(filepath.Separator))
+
+		if !d.IsDir() {
+			summary.Files++
+			if strings.Contains(d.Name(), "rego") {
+				summary.Policies++
+			}
+			return nil
+		}
+
+		// Backups are laid out as <env>/<workspace>/<application>, workspaces also hold a groups directory
+		switch {
+		case depth == 0:
+			summary.Environments++
+		case depth == 1:
+			summary.Workspaces++
+		case depth == 2 && d.Name() != "groups":
+			summary.Applications++
+		default:
+			return nil
+		}
+		summary.Tree = append(summary.Tree, strings.Repeat("    ", depth)+d.Name())
+		return nil
+	})
+	if err != nil {
+		return restoreSummary{}, fmt.Errorf("failed to summarize backup: %w", err)
+	}
+	return summary, nil
+}
+
+// String describes the summary in a single line
+func (s restoreSummary) String() string {
+	return fmt.Sprintf("%d environments, %d workspaces, %d applications (%d policies, %d files)",
+		s.Environments, s.Workspaces, s.Applications, s.Policies, s.Files)
+}
+
+// confirmRestore shows the tree of the backup and asks the user to confirm restoring it.
+// Anything but y or yes declines.
+func confirmRestore(in io.Reader, out io.Writer, summary restoreSummary) (bool, error) {
+	for _, line := range summary.Tree {
+		fmt.Fprintln(out, line)
+	}
+	fmt.Fprintf(out, "\n%s\n", summary)
+	fmt.Fprintf(out, "Restore %d environments, %d workspaces, %d applications? [y/N] ",
+		summary.Environments, summary.Workspaces, summary.Applications)
+
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, fmt.Errorf("failed to read confirmation: %w", err)
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// selectBackupTag lists the most recent backups of the repository, of the --env-id and --ws-id
+// workspace when given, and asks the user to pick one. It returns an empty tag when none is picked.
+func selectBackupTag(in *bufio.Reader, out io.Writer) (string, error) {
+	tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+	if err != nil {
+		return "", err
+	}
+	defer repository.CleanupTempDir(tempDir)
+
+	tags, err := listBackupTags(tempDir, restoreEnvID, restoreWsID, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(tags) == 0 {
+		return "", errors.New("no backups found to restore from")
+	}
+	return promptBackupTag(in, out, tags[:min(len(tags), recentBackups)])
+}
+
+// promptBackupTag shows tags as a numbered menu and reads the number of the chosen one,
+// asking again until a valid number is given. An empty answer or the end of input picks none.
+func promptBackupTag(in *bufio.Reader, out io.Writer, tags []tagInfo) (string, error) {
+	fmt.Fprintln(out, "Recent backups:")
+	for i, tag := range tags {
+		fmt.Fprintf(out, "%d. %s (created: %s)\n", i+1, tag.Name, tag.CreatedAt.Format("2006-01-02 15:04:05"))
+	}
+
+	for {
+		fmt.Fprintf(out, "Select a backup to restore [1-%d], or press Enter to cancel: ", len(tags))
+		answer, err := in.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", fmt.Errorf("failed to read selection: %w", err)
+		}
+		answer = strings.TrimSpace(answer)
+		if answer == "" {
+			return "", nil
+		}
+
+		if n, convErr := strconv.Atoi(answer); convErr == nil && n >= 1 && n <= len(tags) {
+			return tags[n-1].Name, nil
+		}
+		fmt.Fprintf(out, "Invalid selection %q\n", answer)
+		if err == io.EOF {
+			return "", nil
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/plainid/git-backup/plainid"
+	"github.com/rs/zerolog/log"
+)
+
+// backupPolicy is a policy file of a backup with the PlainID IDs it is imported for
+type backupPolicy struct {
+	envID string
+	wsID  string
+	appID string
+	path  string
+}
+
+// backupApplication is an application definition of a backup with the PlainID IDs it is imported for
+type backupApplication struct {
+	envID string
+	wsID  string
+	app   plainid.Application
+	path  string
+}
+
+// pushedWorkspace is a workspace directory of a backup with the PlainID IDs it is pushed to
+type pushedWorkspace struct {
+	envID string
+	wsID  string
+	dir   string
+}
+
+// backupPolicies returns the policies of the backup checked out in dir, only those of the restored
+// workspace when filtered
+func backupPolicies(dir string) ([]backupPolicy, error) {
+	workspaces, err := pushedWorkspaces(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var policies []backupPolicy
+	for _, ws := range workspaces {
+		wsPolicies, err := workspacePolicies(ws.dir, ws.envID, ws.wsID)
+		if err != nil {
+			return nil, err
+		}
+		policies = append(policies, wsPolicies...)
+	}
+	return policies, nil
+}
+
+// backupApplications returns the application definitions of the backup checked out in dir, only
+// those of the restored workspace when filtered
+func backupApplications(dir string) ([]backupApplication, error) {
+	workspaces, err := pushedWorkspaces(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var apps []backupApplication
+	for _, ws := range workspaces {
+		wsApps, err := workspaceApplications(ws.dir, ws.envID, ws.wsID)
+		if err != nil {
+			return nil, err
+		}
+		apps = append(apps, wsApps...)
+	}
+	return apps, nil
+}
+
+// workspaceApplications returns the application definitions in the workspace directory wsDir
+func workspaceApplications(wsDir, envID, wsID string) ([]backupApplication, error) {
+	appEntries, err := os.ReadDir(wsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace directory: %w", err)
+	}
+
+	var apps []backupApplication
+	for _, appEntry := range appEntries {
+		path := filepath.Join(wsDir, appEntry.Name(), "application.json")
+		// Other workspace directories, like groups, have no application definition
+		data, err := os.ReadFile(path)
+		if !appEntry.IsDir() || errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read application: %w", err)
+		}
+		var app plainid.Application
+		if err := json.Unmarshal(data, &app); err != nil {
+			return nil, fmt.Errorf("failed to parse application %s: %w", path, err)
+		}
+		if err := app.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid application %s: %w", path, err)
+		}
+		apps = append(apps, backupApplication{envID: envID, wsID: wsID, app: app, path: path})
+	}
+	return apps, nil
+}
+
+// pushedWorkspaces returns the workspace directories of the backup checked out in dir, only the
+// restored workspace when filtered. Workspaces are identified by their metadata, as their
+// directories are named after them.
+func pushedWorkspaces(dir string) ([]pushedWorkspace, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read backup directory: %w", err)
+	}
+
+	var workspaces []pushedWorkspace
+	for _, entry := range entries {
+		sep := strings.LastIndex(entry.Name(), "_")
+		if !entry.IsDir() || sep < 0 {
+			continue
+		}
+		envID := entry.Name()[sep+1:]
+		if restoreEnvID != "" && envID != restoreEnvID {
+			continue
+		}
+
+		envDir := filepath.Join(dir, entry.Name())
+		wsEntries, err := os.ReadDir(envDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read environment directory: %w", err)
+		}
+		for _, wsEntry := range wsEntries {
+			if !wsEntry.IsDir() {
+				continue
+			}
+			wsDir := filepath.Join(envDir, wsEntry.Name())
+
+			var metadata struct {
+				ID string `json:"id"`
+			}
+			data, err := os.ReadFile(filepath.Join(wsDir, "workspace-metadata.json"))
+			if err != nil || json.Unmarshal(data, &metadata) != nil || metadata.ID == "" {
+				log.Warn().Str("wsDir", wsDir).Msg("Workspace ID unknown without workspace-metadata.json, it is not pushed")
+				continue
+			}
+			if restoreWsID != "" && metadata.ID != restoreWsID {
+				continue
+			}
+			workspaces = append(workspaces, pushedWorkspace{envID: envID, wsID: metadata.ID, dir: wsDir})
+		}
+	}
+	return workspaces, nil
+}
+
+// workspacePolicies returns the policies of the applications in the workspace directory wsDir
+func workspacePolicies(wsDir, envID, wsID string) ([]backupPolicy, error) {
+	appEntries, err := os.ReadDir(wsDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace directory: %w", err)
+	}
+
+	var policies []backupPolicy
+	for _, appEntry := range appEntries {
+		if !appEntry.IsDir() {
+			continue
+		}
+		appDir := filepath.Join(wsDir, appEntry.Name())
+
+		// Other workspace directories, like groups, have no application definition
+		var app struct {
+			ID string `json:"applicationId"`
+		}
+		data, err := os.ReadFile(filepath.Join(appDir, "application.json"))
+		if err != nil || json.Unmarshal(data, &app) != nil || app.ID == "" {
+			continue
+		}
+
+		// Backups taken before policies were named .rego have .srego files
+		paths, err := filepath.Glob(filepath.Join(appDir, "policy_*.rego"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list policies: %w", err)
+		}
+		legacyPaths, err := filepath.Glob(filepath.Join(appDir, "policy_*.srego"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list policies: %w", err)
+		}
+		paths = append(paths, legacyPaths...)
+		for _, path := range paths {
+			policies = append(policies, backupPolicy{envID: envID, wsID: wsID, appID: app.ID, path: path})
+		}
+	}
+	return policies, nil
+}
+
+// pushApplications creates or updates the applications in PlainID one by one, before their
+// policies are imported, and returns the PlainID ID of every pushed application by its backup ID.
+// Deleted applications are created again under a new ID. A failed application does not stop the
+// others, all failures are returned together. In dry-run mode the applications are only logged.
+func pushApplications(ctx context.Context, apps []backupApplication) (map[string]string, error) {
+	var errs []error
+	appIDs := make(map[string]string, len(apps))
+	for _, app := range apps {
+		logger := log.With().Str("envID", app.envID).Str("wsID", app.wsID).Str("appID", app.app.ID).Logger()
+		if cfg.DryRun {
+			logger.Info().Msgf("Dry run mode: Would push application %s to PlainID", app.app.Name)
+			continue
+		}
+
+		appID, err := plainIDService.UpsertApplication(ctx, app.envID, app.wsID, app.app)
+		if err != nil {
+			logger.Error().Err(err).Msgf("Failed to push application %s to PlainID", app.app.Name)
+			errs = append(errs, fmt.Errorf("%s: %w", app.app.Name, err))
+			continue
+		}
+		logger.Debug().Str("pushedAppID", appID).Msgf("Application %s pushed to PlainID", app.app.Name)
+		appIDs[app.app.ID] = appID
+	}
+
+	if cfg.DryRun {
+		log.Info().Msgf("Dry run mode: %d applications would be pushed to PlainID", len(apps))
+		return appIDs, nil
+	}
+	log.Info().Msgf("%d applications pushed to PlainID, %d failed", len(appIDs), len(errs))
+	if len(errs) > 0 {
+		return appIDs, fmt.Errorf("failed to push %d of %d applications: %w", len(errs), len(apps), errors.Join(errs...))
+	}
+	return appIDs, nil
+}
+
+// pushedPolicies returns the policies with the ID of their application in PlainID, which changes
+// when a deleted application is created again. Policies of applications that were not pushed keep
+// the ID of the backup.
+func pushedPolicies(policies []backupPolicy, appIDs map[string]string) []backupPolicy {
+	pushed := make([]backupPolicy, 0, len(policies))
+	for _, policy := range policies {
+		if appID, ok := appIDs[policy.appID]; ok {
+			policy.appID = appID
+		}
+		pushed = append(pushed, policy)
+	}
+	return pushed
+}
+
+// pushPolicies imports the policies into PlainID one by one. A failed policy does not stop the
+// others, all failures are returned together. In dry-run mode the policies are only logged.
+func pushPolicies(ctx context.Context, policies []backupPolicy) error {
+	var errs []error
+	pushed := 0
+	for _, policy := range policies {
+		logger := log.With().Str("envID", policy.envID).Str("wsID", policy.wsID).Str("appID", policy.appID).
+			Str("policy", filepath.Base(policy.path)).Logger()
+		if cfg.DryRun {
+			logger.Info().Msg("Dry run mode: Would push policy to PlainID")
+			continue
+		}
+
+		content, err := os.ReadFile(policy.path)
+		if err == nil {
+			err = plainIDService.ImportPolicy(ctx, policy.envID, policy.wsID, policy.appID, string(content))
+		}
+		if err != nil {
+			logger.Error().Err(err).Msg("Failed to push policy to PlainID")
+			errs = append(errs, fmt.Errorf("%s: %w", filepath.Base(policy.path), err))
+			continue
+		}
+		logger.Debug().Msg("Policy pushed to PlainID")
+		pushed++
+	}
+
+	if cfg.DryRun {
+		log.Info().Msgf("Dry run mode: %d policies would be pushed to PlainID", len(policies))
+		return nil
+	}
+	log.Info().Msgf("%d policies pushed to PlainID, %d failed", pushed, len(errs))
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to push %d of %d policies: %w", len(errs), len(policies), errors.Join(errs...))
+	}
+	return nil
+}
@@ -1,21 +1,40 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 
 	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/internal/metrics"
 	"github.com/plainid/git-backup/plainid"
+	"github.com/plainid/git-backup/repository"
 )
 
+// Version of the tool, set at build time with -ldflags "-X github.com/plainid/git-backup/cmd.Version=<version>"
+var Version = "dev"
+
 var (
 	cfgFile        string
 	cfg            *config.Config
 	plainIDService *plainid.Service
-	rootCmd        = &cobra.Command{
+	gitAuth        transport.AuthMethod
+	// conditionalCache holds the PlainID responses of the previous backup, nil unless conditional requests are enabled
+	conditionalCache *plainid.ConditionalCache
+	// metricsAddr is the address the Prometheus metrics are served on, they are not served if empty
+	metricsAddr   string
+	metricsServer *metrics.Server
+	rootCmd       = &cobra.Command{
 		Use:   "git-backup",
 		Short: "Backup PlainID configuration to git repository",
 		Long: `Git backup tool is used to backup PlainID configuration files to a git repository.
@@ -23,112 +42,290 @@ The main concept it's build around is versioning the configuration files, so you
 rollback to a previous version if needed.`,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			var err error
+			ctx := cmd.Context()
+			if metricsAddr != "" {
+				if metricsServer, err = metrics.Start(metricsAddr); err != nil {
+					return err
+				}
+			}
+
 			cfg, err = config.LoadConfig(cmd.Flags())
 			if err != nil {
 				return fmt.Errorf("failed to load configuration: %w", err)
 			}
 
-			plainIDService = plainid.NewService(*cfg)
+			gitAuth, err = repository.NewAuth(cfg.Git)
 			if err != nil {
-				return fmt.Errorf("failed to create PlainID service: %w", err)
+				return fmt.Errorf("failed to set up git authentication: %w", err)
 			}
 
-			envs, err := plainIDService.Environments()
+			instances, err := selectInstances(cfg.PlainIDInstances(), instanceAlias)
 			if err != nil {
-				return fmt.Errorf("failed to get environments for whildcard setup: %w", err)
+				return err
 			}
-
-			var cfgEnvs []config.Environment
-			// check for whildcard envs
-			if cfg.PlainID.HasWildcardEnvironment() {
-				for _, env := range envs {
-					cfgEnvs = append(cfgEnvs, config.Environment{
-						ID:   env.ID,
-						Name: env.Name,
-						Workspaces: []config.Workspace{ // if we have a wildcard environment, we assume all workspaces are included
-							{ID: "*"}},
-						Identities: []string{"*"},
-					})
-				}
-			} else {
-				for _, configEnv := range cfg.PlainID.Envs {
-					for _, apiEnv := range envs {
-						if configEnv.ID == apiEnv.ID {
-							newEnv := configEnv
-							newEnv.Name = apiEnv.Name
-							cfgEnvs = append(cfgEnvs, newEnv)
-							break
-						}
+			// The plainid section is kept when there are several instances, backup and restore go
+			// through every instance in turn
+			shared := currentInstance("")
+			// All PlainID calls of a run share the command name and start time as request ID prefix
+			requestIDPrefix := cmd.Name() + "-" + time.Now().Format("20060102-150405")
+			plainIDInstances = nil
+			for _, instance := range instances {
+				cfg.PlainID = instance.PlainIDConfig
+				if err := setupPlainID(ctx, requestIDPrefix); err != nil {
+					if instance.Alias != "" {
+						return fmt.Errorf("instance %s: %w", instance.Alias, err)
 					}
+					return err
 				}
+				plainIDInstances = append(plainIDInstances, currentInstance(instance.Alias))
 			}
 
-			// Process workspaces for each environment
-			for i := range cfgEnvs {
-				wss, err := plainIDService.Workspaces(cfgEnvs[i].ID)
-				if err != nil {
-					return fmt.Errorf("failed to get workspaces for environment %s: %w", cfgEnvs[i].ID, err)
-				}
+			if len(plainIDInstances) > 1 {
+				useInstance(shared)
+			}
+			return nil
+		},
+	}
+)
 
-				var newWSs []config.Workspace
-				if cfgEnvs[i].HasWildcardWorkspace() {
-					for _, ws := range wss {
-						newWSs = append(newWSs, config.Workspace{
-							ID:   ws.ID,
-							Name: ws.Name,
-						})
-					}
-				} else {
-					for _, configWs := range cfgEnvs[i].Workspaces {
-						for _, apiWs := range wss {
-							if configWs.ID == apiWs.ID {
-								newWs := configWs
-								newWs.Name = apiWs.Name
-								newWSs = append(newWSs, newWs)
-								break
-							}
-						}
+// setupPlainID creates the service of the PlainID instance in cfg.PlainID and resolves its wildcard
+// environments, workspaces and identities
+func setupPlainID(ctx context.Context, requestIDPrefix string) error {
+	opts := []plainid.Option{
+		plainid.WithUserAgent(userAgent()),
+		plainid.WithRequestIDPrefix(requestIDPrefix),
+	}
+	conditionalCache = nil
+	if cfg.PlainID.ConditionalRequests {
+		conditionalCache = plainid.NewConditionalCache()
+		opts = append(opts, plainid.WithConditionalCache(conditionalCache))
+	}
+	plainIDService = plainid.NewService(*cfg, opts...)
+
+	if cfg.PreFlight {
+		if err := preFlight(ctx); err != nil {
+			return err
+		}
+	}
+
+	envs, err := plainIDService.Environments(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get environments for whildcard setup: %w", err)
+	}
+
+	var cfgEnvs []config.Environment
+	// check for whildcard envs
+	if cfg.PlainID.HasWildcardEnvironment() {
+		for _, env := range envs {
+			cfgEnvs = append(cfgEnvs, config.Environment{
+				ID:   env.ID,
+				Name: env.Name,
+				Workspaces: []config.Workspace{ // if we have a wildcard environment, we assume all workspaces are included
+					{ID: "*"}},
+				Identities: []string{"*"},
+			})
+		}
+	} else {
+		for _, configEnv := range cfg.PlainID.Envs {
+			for _, apiEnv := range envs {
+				if configEnv.ID == apiEnv.ID {
+					newEnv := configEnv
+					// A name set in the config file takes precedence over the PlainID one
+					if newEnv.Name == "" {
+						newEnv.Name = apiEnv.Name
 					}
+					cfgEnvs = append(cfgEnvs, newEnv)
+					break
 				}
-				cfgEnvs[i].Workspaces = newWSs
 			}
+		}
+	}
 
-			// Process identities for each environment
-			for i := range cfgEnvs {
-				identities, err := plainIDService.Identities(cfgEnvs[i].ID)
-				if err != nil {
-					return fmt.Errorf("failed to get identities for environment %s: %w", cfgEnvs[i].ID, err)
-				}
+	// Process workspaces for each environment
+	for i := range cfgEnvs {
+		wss, err := plainIDService.Workspaces(ctx, cfgEnvs[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to get workspaces for environment %s: %w", cfgEnvs[i].ID, err)
+		}
 
-				var newIdentities []string
-				if cfgEnvs[i].HasWildcardIdentities() {
-					for _, identity := range identities {
-						newIdentities = append(newIdentities, identity.TemplateID)
+		var newWSs []config.Workspace
+		if wildcard := cfgEnvs[i].FindWorkspace("*"); wildcard != nil {
+			for _, ws := range wss {
+				newWSs = append(newWSs, config.Workspace{
+					ID:             ws.ID,
+					Name:           ws.Name,
+					MaxConcurrency: wildcard.MaxConcurrency,
+				})
+			}
+		} else {
+			for _, configWs := range cfgEnvs[i].Workspaces {
+				for _, apiWs := range wss {
+					if configWs.ID == apiWs.ID {
+						newWs := configWs
+						newWs.Name = apiWs.Name
+						newWSs = append(newWSs, newWs)
+						break
 					}
-					cfgEnvs[i].Identities = newIdentities
 				}
 			}
+		}
+		cfgEnvs[i].Workspaces = newWSs
+	}
 
-			cfg.PlainID.Envs = cfgEnvs
-			return nil
-		},
+	// Process identities for each environment, configured identity templates are kept as they are
+	for i := range cfgEnvs {
+		if !cfgEnvs[i].HasWildcardIdentities() {
+			continue
+		}
+		identities, err := plainIDService.Identities(ctx, cfgEnvs[i].ID)
+		if err != nil {
+			return fmt.Errorf("failed to get identities for environment %s: %w", cfgEnvs[i].ID, err)
+		}
+
+		// Several identity workspaces can share a template, each template is backed up once
+		var newIdentities []string
+		for _, identity := range identities {
+			if identity.TemplateID == "" || slices.Contains(newIdentities, identity.TemplateID) {
+				continue
+			}
+			newIdentities = append(newIdentities, identity.TemplateID)
+		}
+		cfgEnvs[i].Identities = newIdentities
 	}
+
+	cfg.PlainID.Envs = cfgEnvs
+	// Names resolved from PlainID become directory names, they must not collide
+	return config.ValidateUniqueNames(cfg.PlainID.Envs)
+}
+
+// Exit codes returned by the tool
+const (
+	// ExitCodeError is returned for any failure without a more specific code
+	ExitCodeError = 1
+	// ExitCodeBackupStale is returned when the latest backup is older than allowed
+	ExitCodeBackupStale = 2
 )
 
+// toolSignature identifies the tool as author of backup commits, tags and notes
+func toolSignature() *object.Signature {
+	sig := &object.Signature{
+		Name:  cfg.Meta.ToolName,
+		Email: cfg.Meta.ToolEmail,
+		When:  time.Now(),
+	}
+	if sig.Name == "" {
+		sig.Name = config.DefaultToolName
+	}
+	if sig.Email == "" {
+		sig.Email = config.DefaultToolEmail
+	}
+	return sig
+}
+
+// commitSignature signs backup commits, tags and notes with the configured commit author, the
+// tool identity by default
+func commitSignature() *object.Signature {
+	sig := toolSignature()
+	if cfg.Git.CommitAuthorName != "" {
+		sig.Name = cfg.Git.CommitAuthorName
+	}
+	if cfg.Git.CommitAuthorEmail != "" {
+		sig.Email = cfg.Git.CommitAuthorEmail
+	}
+	return sig
+}
+
+// preFlight checks the connection to PlainID and logs the outcome of every check
+func preFlight(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	result, err := plainIDService.TestConnection(ctx)
+	for _, check := range result.Checks {
+		event := log.Info()
+		if !check.OK {
+			event = log.Error()
+		}
+		event.Str("check", check.Name).Dur("duration", check.Duration).Msgf("Pre-flight: %s", check.Message)
+	}
+	if err != nil {
+		return fmt.Errorf("pre-flight check failed: %w", err)
+	}
+	return nil
+}
+
+// userAgent identifies the tool in PlainID API calls as <tool-name>/<version>
+func userAgent() string {
+	return toolSignature().Name + "/" + Version
+}
+
+// exitCodeError carries a specific process exit code for a command failure
+type exitCodeError struct {
+	code int
+	err  error
+}
+
+func (e *exitCodeError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitCodeError) Unwrap() error {
+	return e.err
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	// Interrupting the tool cancels the running command and its PlainID requests
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err := rootCmd.ExecuteContext(ctx)
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(); err != nil {
+			log.Warn().Err(err).Msg("Failed to stop serving metrics")
+		}
+	}
+	if err != nil {
 		log.Error().Err(err).Msg("Failed to execute command")
-		os.Exit(1)
+
+		if errors.Is(err, config.ErrConfigFileNotFound) {
+			fmt.Fprintln(os.Stderr, "No usable configuration file was found, here is a template to start from:")
+			fmt.Fprintln(os.Stderr)
+			config.PrintTemplate(os.Stderr)
+		}
+
+		var exitErr *exitCodeError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.code)
+		}
+		os.Exit(ExitCodeError)
 	}
 }
 
 func init() {
 	// Register all configuration flags
 	config.RegisterFlags(rootCmd.PersistentFlags())
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "Path to config file, same as --file")
+	rootCmd.MarkFlagsMutuallyExclusive("file", "config")
+	rootCmd.PersistentFlags().Var(&logFormat, "log-format", "Log format: text or json")
+	rootCmd.PersistentFlags().Var(&logLevel, "log-level", "Log level: debug, info, warn or error")
+	// Logging is set up once the flags are parsed, before the pre-run of any command
+	cobra.OnInitialize(configureLogging)
+	rootCmd.PersistentFlags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on /metrics at this address (e.g. :9090)")
+	rootCmd.PersistentFlags().StringVar(&instanceAlias, "instance", "", "Alias of the only PlainID instance to work with, all configured instances by default")
 
 	// Add commands
 	rootCmd.AddCommand(backupCmd)
 	rootCmd.AddCommand(restoreCmd)
 	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(diffCmd)
+	rootCmd.AddCommand(auditCmd)
+	rootCmd.AddCommand(cloneCmd)
+	rootCmd.AddCommand(infoCmd)
+	rootCmd.AddCommand(pruneCmd)
+	rootCmd.AddCommand(validateCmd)
+	rootCmd.AddCommand(verifyCmd)
+	rootCmd.AddCommand(statusCmd)
+	rootCmd.AddCommand(initCmd)
 }
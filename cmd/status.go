@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// statusOptions holds command-specific options
+type statusOptions struct {
+	warnAfter    time.Duration
+	outputFormat string
+}
+
+// outputFormatText is the human-readable output of the status command
+const outputFormatText = "text"
+
+var statusOpts statusOptions
+
+// backupStatus describes the latest backup, marshaled as the json output of the status command
+type backupStatus struct {
+	Tag          string    `json:"tag"`
+	CreatedAt    time.Time `json:"created_at"`
+	Age          string    `json:"age"`
+	AgeSeconds   int64     `json:"age_seconds"`
+	Commit       string    `json:"commit"`
+	Environments int       `json:"environments"`
+	Workspaces   int       `json:"workspaces"`
+	Stale        bool      `json:"stale"`
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the latest backup and whether it is stale",
+	Long: `Show the latest timestamp backup tag, its age, commit and the number of environments and
+workspaces it covers. The command fails if the backup is older than --warn-after, so it can be
+used in monitoring scripts.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		switch statusOpts.outputFormat {
+		case outputFormatText, outputFormatJSON:
+		default:
+			return fmt.Errorf("output-format must be %s or %s: %s", outputFormatText, outputFormatJSON, statusOpts.outputFormat)
+		}
+		if statusOpts.warnAfter <= 0 {
+			return errors.New("warn-after must be positive")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Msg("Executing status command")
+
+		tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+		if err != nil {
+			return err
+		}
+		defer repository.CleanupTempDir(tempDir)
+
+		tags, err := listBackupTags(tempDir, "", "", nil)
+		if err != nil {
+			return err
+		}
+
+		// Staleness is a monitoring result, not a usage problem
+		cmd.SilenceUsage = true
+
+		latest, ok := latestTimestampTag(tags)
+		if !ok {
+			fmt.Fprintln(cmd.OutOrStdout(), "No backups found")
+			return errors.New("no backup found")
+		}
+
+		age := time.Since(latest.CreatedAt)
+		status := backupStatus{
+			Tag:          latest.Name,
+			CreatedAt:    latest.CreatedAt,
+			Age:          humanizeAge(age),
+			AgeSeconds:   int64(age.Seconds()),
+			Commit:       latest.Commit,
+			Environments: len(latest.Environments),
+			Workspaces:   len(latest.Workspaces),
+			Stale:        age > statusOpts.warnAfter,
+		}
+		if err := writeStatus(cmd.OutOrStdout(), statusOpts.outputFormat, status); err != nil {
+			return err
+		}
+
+		if status.Stale {
+			return fmt.Errorf("latest backup %s is older than %s", status.Tag, statusOpts.warnAfter)
+		}
+		return nil
+	},
+}
+
+// latestTimestampTag returns the newest timestamp tag among tags sorted newest first,
+// semantic version tags are skipped
+func latestTimestampTag(tags []tagInfo) (tagInfo, bool) {
+	for _, tag := range tags {
+		if _, ok := cfg.Git.ParseTagTime(tag.Name); ok {
+			return tag, true
+		}
+	}
+	return tagInfo{}, false
+}
+
+// writeStatus writes the status of the latest backup to out as text or JSON
+func writeStatus(out io.Writer, format string, status backupStatus) error {
+	if format == outputFormatJSON {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(status); err != nil {
+			return fmt.Errorf("failed to write status as JSON: %w", err)
+		}
+		return nil
+	}
+
+	fmt.Fprintf(out, "Latest backup: %s\n", status.Tag)
+	fmt.Fprintf(out, "Created:       %s (%s)\n", status.CreatedAt.Format("2006-01-02 15:04:05"), status.Age)
+	fmt.Fprintf(out, "Commit:        %s\n", status.Commit)
+	fmt.Fprintf(out, "Environments:  %d\n", status.Environments)
+	fmt.Fprintf(out, "Workspaces:    %d\n", status.Workspaces)
+	if status.Stale {
+		fmt.Fprintf(out, "WARNING: latest backup is older than %s\n", statusOpts.warnAfter)
+	}
+	return nil
+}
+
+// humanizeAge formats a backup age as "just now", "5 minutes ago", "2 hours ago" or "3 days ago"
+func humanizeAge(age time.Duration) string {
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", name)
+		}
+		return fmt.Sprintf("%d %ss ago", n, name)
+	}
+
+	switch {
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return unit(int(age/time.Minute), "minute")
+	case age < 24*time.Hour:
+		return unit(int(age/time.Hour), "hour")
+	default:
+		return unit(int(age/(24*time.Hour)), "day")
+	}
+}
+
+func init() {
+	statusCmd.Flags().DurationVar(&statusOpts.warnAfter, "warn-after", 25*time.Hour, "Fail if the latest backup is older than this duration")
+	statusCmd.Flags().StringVar(&statusOpts.outputFormat, "output-format", outputFormatText, "Output format: text or json")
+}
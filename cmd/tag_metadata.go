@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/plainid/git-backup/config"
+)
+
+// backupTagMetadata is the message of backup tags, list parses it back to filter and display backups
+type backupTagMetadata struct {
+	Timestamp    time.Time `json:"timestamp"`
+	Environments []string  `json:"environments"`
+	// Workspaces are the workspaces backed up, by environment ID
+	Workspaces map[string][]string `json:"workspaces"`
+	// Skipped are the workspaces skipped with --continue-on-error, by environment ID
+	Skipped   map[string][]string `json:"skipped,omitempty"`
+	FileCount int                 `json:"file_count"`
+	Labels    []string            `json:"labels,omitempty"`
+}
+
+// newBackupTagMetadata describes the backup of envs, leaving out the workspaces that failed
+func newBackupTagMetadata(stats *backupStats, backupTime time.Time, envs []config.Environment, fileCount int, labels []string) backupTagMetadata {
+	metadata := backupTagMetadata{
+		Timestamp:    backupTime.UTC(),
+		Environments: make([]string, 0, len(envs)),
+		Workspaces:   make(map[string][]string, len(envs)),
+		FileCount:    fileCount,
+		Labels:       labels,
+	}
+	for _, env := range envs {
+		metadata.Environments = append(metadata.Environments, env.ID)
+		wsIDs := make([]string, 0, len(env.Workspaces))
+		for _, ws := range env.Workspaces {
+			if stats.failed(env.ID, ws.ID) {
+				if metadata.Skipped == nil {
+					metadata.Skipped = make(map[string][]string)
+				}
+				metadata.Skipped[env.ID] = append(metadata.Skipped[env.ID], ws.ID)
+				continue
+			}
+			wsIDs = append(wsIDs, ws.ID)
+		}
+		metadata.Workspaces[env.ID] = wsIDs
+	}
+	return metadata
+}
+
+// message returns the metadata as tag message
+func (m backupTagMetadata) message() (string, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode tag metadata: %w", err)
+	}
+	return string(data), nil
+}
+
+// parseTagMetadata parses the message of a backup tag, tags created before the metadata was
+// introduced and tags with a custom message are reported as not holding any
+func parseTagMetadata(message string) (backupTagMetadata, bool) {
+	if !strings.HasPrefix(strings.TrimSpace(message), "{") {
+		return backupTagMetadata{}, false
+	}
+	var metadata backupTagMetadata
+	if err := json.Unmarshal([]byte(message), &metadata); err != nil || metadata.Workspaces == nil {
+		return backupTagMetadata{}, false
+	}
+	return metadata, true
+}
@@ -0,0 +1,147 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/plainid"
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration, PlainID connectivity and git access",
+	Long: `Check that the required configuration is set, the configured environments and workspaces
+exist in PlainID and the git repository can be reached with the configured credentials.
+Nothing is written to PlainID or git.`,
+	// The configuration is checked by the command itself rather than required up front
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Msg("Executing validate command")
+
+		// Check results are the output of the command, a failed check is not a usage problem
+		cmd.SilenceUsage = true
+		report := &validationReport{out: cmd.OutOrStdout()}
+
+		var err error
+		cfg, err = config.ReadConfig(cmd.Flags())
+		report.check("Configuration loaded", err)
+		if err != nil {
+			return report.result()
+		}
+
+		missing := config.MissingFields(cfg)
+		for _, field := range missing {
+			report.fail(fmt.Sprintf("Configuration field %s", field), "required field is empty")
+		}
+		if len(missing) == 0 {
+			report.check("Configuration is valid", config.Validate(cfg))
+		}
+
+		for _, instance := range cfg.PlainIDInstances() {
+			if instance.Alias != "" {
+				fmt.Fprintf(report.out, "PlainID instance %s:\n", instance.Alias)
+			}
+			cfg.PlainID = instance.PlainIDConfig
+			if cfg.PlainID.BaseURL != "" && cfg.PlainID.ClientID != "" && cfg.PlainID.ClientSecret != "" {
+				validatePlainID(cmd.Context(), report, plainid.NewService(*cfg, plainid.WithUserAgent(userAgent())))
+			} else {
+				report.fail("PlainID connection", "PlainID base URL and client credentials are required")
+			}
+		}
+
+		if cfg.Git.Repo != "" {
+			validateGit(report)
+		} else {
+			report.fail("Git repository access", "git.repo is required")
+		}
+
+		return report.result()
+	},
+}
+
+// validationReport prints the outcome of every check and counts the failed ones
+type validationReport struct {
+	out    io.Writer
+	failed int
+}
+
+// check reports the named check as passed when err is nil, otherwise as failed with err as reason
+func (r *validationReport) check(name string, err error) {
+	if err != nil {
+		r.fail(name, err.Error())
+		return
+	}
+	fmt.Fprintf(r.out, "✓ %s\n", name)
+}
+
+// fail reports the named check as failed for reason
+func (r *validationReport) fail(name, reason string) {
+	r.failed++
+	fmt.Fprintf(r.out, "✗ %s: %s\n", name, reason)
+}
+
+// result returns an error if any check failed
+func (r *validationReport) result() error {
+	if r.failed > 0 {
+		return fmt.Errorf("%d validation checks failed", r.failed)
+	}
+	log.Info().Msg("All validation checks passed")
+	return nil
+}
+
+// validatePlainID checks that the configured environments and their workspaces exist in PlainID.
+// Wildcards match whatever exists, only the environments or workspaces are listed for them.
+func validatePlainID(ctx context.Context, report *validationReport, service *plainid.Service) {
+	envs, err := service.Environments(ctx)
+	report.check("PlainID environments listed", err)
+	if err != nil {
+		return
+	}
+
+	for _, env := range cfg.PlainID.Envs {
+		if env.IsWildcard() {
+			continue
+		}
+		name := fmt.Sprintf("PlainID environment %s", env.ID)
+		if !slices.ContainsFunc(envs, func(e plainid.Environment) bool { return e.ID == env.ID }) {
+			report.fail(name, "environment does not exist or is not accessible with the client credentials")
+			continue
+		}
+		report.check(name, nil)
+
+		wss, err := service.Workspaces(ctx, env.ID)
+		report.check(fmt.Sprintf("PlainID workspaces of environment %s listed", env.ID), err)
+		if err != nil {
+			continue
+		}
+		for _, ws := range env.Workspaces {
+			if ws.ID == "*" {
+				continue
+			}
+			name := fmt.Sprintf("PlainID workspace %s of environment %s", ws.ID, env.ID)
+			if !slices.ContainsFunc(wss, func(w plainid.Workspace) bool { return w.ID == ws.ID }) {
+				report.fail(name, "workspace does not exist in the environment")
+				continue
+			}
+			report.check(name, nil)
+		}
+	}
+}
+
+// validateGit checks that the references of the git repository can be listed with the configured
+// credentials, without cloning or pushing anything
+func validateGit(report *validationReport) {
+	auth, err := repository.NewAuth(cfg.Git)
+	if err == nil {
+		_, err = repository.ListRemote(cfg.Git.Repo, auth, cfg.Git.SOCKSProxy)
+	}
+	report.check("Git repository access", err)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/plainid/git-backup/repository"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyTag string
+	verifyDir string
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the checksums of a backup",
+	Long: `Recompute the SHA-256 checksum of every file listed in the manifest of a backup and report
+the files that are missing or changed. The latest backup of the branch is verified unless a tag
+or a directory holding a backup, such as a restore target directory, is given.`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if verifyTag != "" && verifyDir != "" {
+			return errors.New("tag and dir cannot be used together")
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		log.Info().Msg("Executing verify command")
+
+		dir := verifyDir
+		if dir == "" {
+			tempDir, err := repository.CreateTempDir(cfg.Git.TempDir)
+			if err != nil {
+				return err
+			}
+			defer repository.CleanupTempDir(tempDir)
+
+			if verifyTag != "" {
+				if _, err := cloneAndCheckoutTag(tempDir, verifyTag); err != nil {
+					return err
+				}
+			} else if _, err := repository.CloneRemoteViaProxy(cfg.Git.Repo, cfg.Git.Branch, gitAuth, tempDir, cfg.Git.SOCKSProxy, 1); err != nil {
+				return err
+			}
+			dir = tempDir
+		}
+
+		manifest, err := readManifest(dir)
+		if err != nil {
+			return err
+		}
+		mismatches, err := verifyManifest(dir, manifest)
+		if err != nil {
+			return err
+		}
+
+		// Mismatches are the result of the command, not a usage problem
+		cmd.SilenceUsage = true
+		for _, mismatch := range mismatches {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s: %s\n", mismatch.Path, mismatch.Problem)
+		}
+		if len(mismatches) > 0 {
+			return fmt.Errorf("%d of %d files do not match the manifest", len(mismatches), len(manifest.Files))
+		}
+		log.Info().Msgf("All %d files match the manifest of %s", len(manifest.Files), manifest.Timestamp.Format("2006-01-02 15:04:05"))
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyTag, "tag", "", "Tag of the backup to verify (default is the latest backup of the branch)")
+	verifyCmd.Flags().StringVar(&verifyDir, "dir", "", "Directory holding a checked out backup to verify instead of the repository")
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+)
+
+// watchDebounce coalesces rapid changes of the watched file, such as bursts of webhook deliveries
+var watchDebounce = 5 * time.Second
+
+// watchBackups runs a backup every time the file at path is written, until the command is interrupted.
+// Failed backups are logged and do not stop watching.
+func watchBackups(cmd *cobra.Command, path string) error {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	return watchFile(ctx, path, func() {
+		log.Info().Str("file", path).Msg("Watched file changed, starting backup")
+		if err := backupWithRetries(cmd); err != nil {
+			log.Error().Err(err).Msg("Backup triggered by watched file failed")
+		}
+	})
+}
+
+// watchFile calls onChange once the file at path has stopped changing for watchDebounce
+func watchFile(ctx context.Context, path string, onChange func()) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create file watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	// The directory is watched as webhook handlers often replace the file instead of writing it in place
+	path = filepath.Clean(path)
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+	log.Info().Str("file", path).Msg("Watching file for changes")
+
+	debounce := time.NewTimer(watchDebounce)
+	debounce.Stop()
+	defer debounce.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Msg("Stopped watching")
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == path && event.Has(fsnotify.Write|fsnotify.Create) {
+				debounce.Reset(watchDebounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn().Err(err).Msg("File watcher error")
+		case <-debounce.C:
+			onChange()
+		}
+	}
+}
@@ -3,10 +3,15 @@ package config
 import (
 	"errors"
 	"fmt"
+	"io/fs"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
@@ -14,24 +19,138 @@ import (
 // Default configuration file name
 const DefaultConfigFileName = ".git-backup"
 
+// NoConfigFileEnv is the environment variable that, set to true, disables the configuration file search
+const NoConfigFileEnv = "GIT_BACKUP_NO_CONFIG_FILE"
+
+// GitLab CI/CD variables read with --use-gitlab-ci-vars
+const (
+	GitLabTokenEnv        = "GITLAB_TOKEN"
+	GitLabClientIDEnv     = "PLAINID_CLIENT_ID"
+	GitLabClientSecretEnv = "PLAINID_CLIENT_SECRET"
+)
+
+// ErrConfigFileNotFound marks configuration errors that occurred without a configuration file
+var ErrConfigFileNotFound = errors.New("configuration file not found")
+
+// DefaultGitAuthUsername is the username sent with the git token, accepted by GitHub and GitLab
+const DefaultGitAuthUsername = "oauth2"
+
+// How the git token is sent to the git server
+const (
+	// GitAuthMethodBasic sends the token as password of HTTP basic authentication, with git.auth-username
+	GitAuthMethodBasic = "basic"
+	// GitAuthMethodBearer sends the token in an Authorization: Bearer header
+	GitAuthMethodBearer = "bearer"
+)
+
+// Defaults of the HTTP client used for PlainID requests
+const (
+	DefaultHTTPTimeoutSeconds = 30
+	DefaultMaxIdleConns       = 10
+	DefaultRateLimitRPS       = 10.0
+)
+
+// Defaults of the tool identity recorded in commits and tags
+const (
+	DefaultToolName  = "PlainID Git Backup"
+	DefaultToolEmail = "git-backup@plainid.com"
+)
+
+// Naming strategies of the application directories in the backup
+const (
+	// AppDirStrategyName names the directory after the application, <appName>
+	AppDirStrategyName = "name"
+	// AppDirStrategyID names the directory after the application ID, <appID>
+	AppDirStrategyID = "id"
+	// AppDirStrategyIDName combines both, <appID>_<appName>
+	AppDirStrategyIDName = "id_name"
+)
+
+// MetaConfig identifies the tool in commit and tag metadata and API calls
+type MetaConfig struct {
+	ToolName  string `mapstructure:"tool-name"`
+	ToolEmail string `mapstructure:"tool-email"`
+}
+
 // GitConfig holds the git-specific configuration
 type GitConfig struct {
 	Repo                string `mapstructure:"repo"`
 	Token               string `mapstructure:"token"`
 	Branch              string `mapstructure:"branch"`
 	DeleteTempOnSuccess bool   `mapstructure:"delete-temp-on-success"`
+	// TempDir is where the temporary clones are created, the system default when empty
+	TempDir string `mapstructure:"temp-dir"`
+	// Depth is the number of commits cloned for a backup, 0 clones the full history
+	Depth int `mapstructure:"clone-depth"`
+	// JSONIndent indents the JSON files of the backup, empty writes compact JSON
+	JSONIndent string `mapstructure:"json-indent"`
+	// AuthUsername is sent along with the token, Azure DevOps for instance expects `az`
+	AuthUsername string `mapstructure:"auth-username"`
+	// AuthMethod selects how the token is sent, see the GitAuthMethod constants
+	AuthMethod string `mapstructure:"auth-method"`
+	// CommitAuthorName and CommitAuthorEmail sign the backup commits and tags, the tool identity in
+	// meta when empty
+	CommitAuthorName  string `mapstructure:"commit-author-name"`
+	CommitAuthorEmail string `mapstructure:"commit-author-email"`
+	// TagFormat is the Go time layout of backup tag names, with an optional {branch} placeholder
+	TagFormat string `mapstructure:"tag-format"`
+	// TagTimezone is the time zone backup tags are named in, any name of time.LoadLocation
+	TagTimezone string `mapstructure:"tag-timezone"`
+
+	// SOCKSProxy is the address of a SOCKS5 proxy used for all git operations, host:port or socks5://host:port
+	SOCKSProxy string `mapstructure:"socks-proxy"`
+
+	// TokenFromK8sSecret reads the token from a Kubernetes Secret, only available in builds with the k8s tag
+	TokenFromK8sSecret *K8sSecretRef `mapstructure:"token-from-k8s-secret"`
+
+	// SSH key authentication, used instead of the token for git@ and ssh:// repository URLs
+	SSHKeyPath       string `mapstructure:"ssh-key-path"`
+	SSHKeyPassphrase string `mapstructure:"ssh-key-passphrase"`
+
+	// GitHub App authentication, used instead of the token when an app ID is set
+	GitHubAppID          int64  `mapstructure:"github-app-id"`
+	GitHubInstallationID int64  `mapstructure:"github-installation-id"`
+	GitHubPrivateKeyPath string `mapstructure:"github-private-key-path"`
+}
+
+// K8sSecretRef references a key of a Kubernetes Secret
+type K8sSecretRef struct {
+	Namespace  string `mapstructure:"namespace"`
+	SecretName string `mapstructure:"secret-name"`
+	Key        string `mapstructure:"key"`
+}
+
+// UsesGitHubApp checks if git authentication is done with a GitHub App
+func (g *GitConfig) UsesGitHubApp() bool {
+	return g.GitHubAppID != 0
+}
+
+// UsesSSHKey checks if git authentication is done with an SSH key, which requires an SSH repository URL
+func (g *GitConfig) UsesSSHKey() bool {
+	return g.SSHKeyPath != "" && IsSSHURL(g.Repo)
+}
+
+// IsSSHURL checks if a repository URL is an SSH URL, ssh://host/path or the scp-like user@host:path
+func IsSSHURL(repoURL string) bool {
+	if strings.HasPrefix(repoURL, "ssh://") {
+		return true
+	}
+	return !strings.Contains(repoURL, "://") && strings.Contains(repoURL, "@") && strings.Contains(repoURL, ":")
 }
 
 // Workspace represents a PlainID workspace
 type Workspace struct {
 	ID   string `mapstructure:"id"`
 	Name string
+	// MaxConcurrency limits concurrent application processing in this workspace, 0 uses the global setting
+	MaxConcurrency int `mapstructure:"max-concurrency"`
 }
 
 // Environment represents a PlainID environment with its workspaces
 type Environment struct {
-	ID         string `mapstructure:"id"`
-	Name       string
+	ID string `mapstructure:"id"`
+	// Name is the display name used for the backup directory, defaults to the name in PlainID
+	Name       string      `mapstructure:"name"`
 	Workspaces []Workspace `mapstructure:"workspaces"`
 	Identities []string    `mapstructure:"identities"`
 }
@@ -46,6 +165,7 @@ func (e *Environment) HasWildcardWorkspace() bool {
 	return false
 }
 
+// HasWildcardIdentities checks if the environment has a wildcard identities configuration
 func (e *Environment) HasWildcardIdentities() bool {
 	for _, identity := range e.Identities {
 		if identity == "*" {
@@ -55,6 +175,17 @@ func (e *Environment) HasWildcardIdentities() bool {
 	return false
 }
 
+// ContainsIdentity checks if a specific identity template ID is included in this environment
+// It returns true if the identity template ID matches or if there's a wildcard
+func (e *Environment) ContainsIdentity(identityID string) bool {
+	for _, identity := range e.Identities {
+		if identity == identityID || identity == "*" {
+			return true
+		}
+	}
+	return false
+}
+
 // ContainsWorkspace checks if a specific workspace ID is included in this environment
 // It returns true if the workspace ID matches or if there's a wildcard
 func (e *Environment) ContainsWorkspace(workspaceID string) bool {
@@ -66,6 +197,20 @@ func (e *Environment) ContainsWorkspace(workspaceID string) bool {
 	return false
 }
 
+// FindWorkspace returns the workspace with the given ID, or the wildcard workspace, or nil if not found
+func (e *Environment) FindWorkspace(workspaceID string) *Workspace {
+	var wildcard *Workspace
+	for i, workspace := range e.Workspaces {
+		if workspace.ID == workspaceID {
+			return &e.Workspaces[i]
+		}
+		if workspace.ID == "*" && wildcard == nil {
+			wildcard = &e.Workspaces[i]
+		}
+	}
+	return wildcard
+}
+
 // IsWildcard checks if this environment has a wildcard ID
 func (e *Environment) IsWildcard() bool {
 	return e.ID == "*"
@@ -76,7 +221,46 @@ type PlainIDConfig struct {
 	BaseURL      string        `mapstructure:"base-url"`
 	ClientID     string        `mapstructure:"client-id"`
 	ClientSecret string        `mapstructure:"client-secret"`
+	TokenURL     string        `mapstructure:"token-url"`
 	Envs         []Environment `mapstructure:"envs"`
+
+	// AppDirStrategy selects how application directories are named, see the AppDirStrategy constants
+	AppDirStrategy string `mapstructure:"app-dir-strategy"`
+
+	// ConditionalRequests skips downloading resources unchanged since the previous backup
+	ConditionalRequests bool `mapstructure:"conditional-requests"`
+
+	// Audit log export, the decision logs of the last AuditLogWindow are backed up
+	BackupAuditLog bool          `mapstructure:"backup-audit-log"`
+	AuditLogWindow time.Duration `mapstructure:"audit-log-window"`
+
+	// HTTPTimeoutSeconds bounds every PlainID request including reading the response, it must be positive
+	// and defaults to DefaultHTTPTimeoutSeconds
+	HTTPTimeoutSeconds int `mapstructure:"http-timeout-seconds"`
+	// MaxIdleConns is the number of idle connections kept open to PlainID, 0 is treated as DefaultMaxIdleConns
+	MaxIdleConns int `mapstructure:"max-idle-conns"`
+	// RateLimitRPS is the maximum number of PlainID requests per second, including token requests, 0 is unlimited
+	RateLimitRPS float64 `mapstructure:"rate-limit-rps"`
+}
+
+// OAuth2TokenURL returns the configured token URL, or the default one derived from the base URL
+func (p *PlainIDConfig) OAuth2TokenURL() string {
+	if p.TokenURL != "" {
+		return p.TokenURL
+	}
+	return fmt.Sprintf("%s/api/1.0/api-key/token", p.BaseURL)
+}
+
+// AppDirName returns the directory name of an application according to the configured strategy
+func (p *PlainIDConfig) AppDirName(appID, appName string) string {
+	switch p.AppDirStrategy {
+	case AppDirStrategyID:
+		return appID
+	case AppDirStrategyIDName:
+		return appID + "_" + appName
+	default:
+		return appName
+	}
 }
 
 // HasWildcardEnvironment checks if there's a wildcard environment in the configuration
@@ -125,38 +309,110 @@ func (p *PlainIDConfig) FindEnvironment(envID string) *Environment {
 	return nil
 }
 
+// PlainIDInstanceConfig is one of several PlainID deployments backed up together, each in a
+// directory named after its alias
+type PlainIDInstanceConfig struct {
+	Alias         string `mapstructure:"alias"`
+	PlainIDConfig `mapstructure:",squash"`
+}
+
+// PlainIDInstances returns the configured PlainID instances. Without instances, the plainid
+// section is the only instance, without alias.
+func (c *Config) PlainIDInstances() []PlainIDInstanceConfig {
+	if len(c.Instances) == 0 {
+		return []PlainIDInstanceConfig{{PlainIDConfig: c.PlainID}}
+	}
+	return c.Instances
+}
+
+// RetryConfig controls how PlainID requests failing with 429, 5xx or a connection error are retried
+type RetryConfig struct {
+	// MaxAttempts is the number of attempts of a request including the first one, 0 or 1 disables retries
+	MaxAttempts int `mapstructure:"max-attempts"`
+	// InitialDelay is the delay before the first retry, doubled for every further retry
+	InitialDelay time.Duration `mapstructure:"initial-delay"`
+	// MaxDelay caps the delay between retries
+	MaxDelay time.Duration `mapstructure:"max-delay"`
+}
+
 // Config holds all the configuration parameters for the git-backup tool
 type Config struct {
 	// Structured configurations
 	Git     GitConfig     `mapstructure:"git"`
 	PlainID PlainIDConfig `mapstructure:"plainid"`
+	Meta    MetaConfig    `mapstructure:"meta"`
+	Retry   RetryConfig   `mapstructure:"retry"`
+	// Instances are backed up instead of the plainid section when set, see PlainIDInstances
+	Instances []PlainIDInstanceConfig `mapstructure:"instances"`
 
 	// Command options
-	DryRun bool `mapstructure:"dry-run"`
+	DryRun       bool `mapstructure:"dry-run"`
+	VerifyWrites bool `mapstructure:"verify-writes"`
+	// PreFlight checks the connection to PlainID before running a command
+	PreFlight bool `mapstructure:"pre-flight"`
+	// UseGitLabCIVars reads the credentials from GitLab CI/CD variables, overriding any other source
+	UseGitLabCIVars bool `mapstructure:"use-gitlab-ci-vars"`
+	// WorkerCount is the number of workspaces of an environment backed up concurrently, 0 is treated as 1
+	WorkerCount int `mapstructure:"worker-count"`
 }
 
 // LoadConfig loads the configuration from file, environment variables, and flags
 func LoadConfig(flagSet *pflag.FlagSet) (*Config, error) {
+	cfg, configFileMissing, err := readConfig(flagSet)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate config
+	if err := Validate(cfg); err != nil {
+		// Configuration from flags and environment only is fine as long as it is complete
+		if configFileMissing {
+			return nil, fmt.Errorf("%w: %w", ErrConfigFileNotFound, err)
+		}
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// ReadConfig loads the configuration like LoadConfig without validating it, for commands that
+// check the configuration themselves
+func ReadConfig(flagSet *pflag.FlagSet) (*Config, error) {
+	cfg, _, err := readConfig(flagSet)
+	return cfg, err
+}
+
+// readConfig loads the configuration and reports whether a config file was expected but not found
+func readConfig(flagSet *pflag.FlagSet) (*Config, bool, error) {
 	v := viper.New()
 
 	// Bind command line flags if provided
 	if flagSet != nil {
 		if err := v.BindPFlags(flagSet); err != nil {
-			return nil, fmt.Errorf("failed to bind flags: %w", err)
+			return nil, false, fmt.Errorf("failed to bind flags: %w", err)
 		}
 
-		// Check if custom config file is specified
-		if flagSet.Changed("file") {
-			configFile, _ := flagSet.GetString("file")
+		// Check if custom config file is specified, with --file or its --config alias
+		for _, name := range []string{"file", "config"} {
+			if flagSet.Lookup(name) == nil || !flagSet.Changed(name) {
+				continue
+			}
+			configFile, _ := flagSet.GetString(name)
 			if configFile != "" {
+				if err := checkConfigFile(configFile); err != nil {
+					return nil, false, err
+				}
 				// If path provided, use it directly
 				v.SetConfigFile(configFile)
 			}
 		}
 	}
 
+	// Deployments configured only through the environment never look for a config file
+	noConfigFile, _ := strconv.ParseBool(os.Getenv(NoConfigFileEnv))
+
 	// If no custom config file specified, use defaults
-	if v.ConfigFileUsed() == "" {
+	if v.ConfigFileUsed() == "" && !noConfigFile {
 		v.SetConfigName(DefaultConfigFileName)
 		v.SetConfigType("yaml")
 		v.AddConfigPath(".")
@@ -164,101 +420,397 @@ func LoadConfig(flagSet *pflag.FlagSet) (*Config, error) {
 	}
 
 	// Read config file if it exists
-	if err := v.ReadInConfig(); err != nil {
+	configFileFound := true
+	if noConfigFile && v.ConfigFileUsed() == "" {
+		configFileFound = false
+	} else if err := v.ReadInConfig(); err != nil {
 		// It's okay if config file doesn't exist
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
-			return nil, fmt.Errorf("failed to read config file: %w", err)
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, false, fmt.Errorf("failed to read config file: %w: %w", ErrConfigFileNotFound, err)
+			}
+			return nil, false, fmt.Errorf("failed to read config file: %w", err)
+		}
+		configFileFound = false
+	}
+
+	// Merge override files on top of the primary config, in the order given
+	if flagSet != nil && flagSet.Changed("extra-config") {
+		extraConfigs, _ := flagSet.GetStringSlice("extra-config")
+		// Override files are YAML like the primary one, whatever their extension
+		v.SetConfigType("yaml")
+		for _, extraConfig := range extraConfigs {
+			v.SetConfigFile(extraConfig)
+			if err := v.MergeInConfig(); err != nil {
+				return nil, false, fmt.Errorf("failed to merge extra config file %s: %w", extraConfig, err)
+			}
 		}
 	}
 
 	// Bind environment variables
 	v.SetEnvKeyReplacer(strings.NewReplacer("-", "_", ".", "_"))
 	v.AutomaticEnv()
+	// The commit author also follows the variables git itself reads, as set by CI jobs
+	_ = v.BindEnv("git.commit-author-name", "GIT_COMMIT_AUTHOR_NAME", "GIT_AUTHOR_NAME")
+	_ = v.BindEnv("git.commit-author-email", "GIT_COMMIT_AUTHOR_EMAIL", "GIT_AUTHOR_EMAIL")
 
 	// Unmarshal config
 	var cfg Config
 	if err := v.Unmarshal(&cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		return nil, false, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
-	// Validate config
-	if err := validateConfig(&cfg); err != nil {
-		return nil, err
+	if cfg.UseGitLabCIVars {
+		applyGitLabCIVars(&cfg)
 	}
 
-	return &cfg, nil
+	if err := inheritInstanceSettings(v, &cfg); err != nil {
+		return nil, false, err
+	}
+
+	if ref := cfg.Git.TokenFromK8sSecret; ref != nil {
+		if ref.Namespace == "" || ref.SecretName == "" || ref.Key == "" {
+			return nil, false, errors.New("invalid configuration: git.token-from-k8s-secret requires namespace, secret-name and key")
+		}
+		token, err := readK8sSecret(*ref)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read git token from Kubernetes secret: %w", err)
+		}
+		cfg.Git.Token = token
+	}
+
+	return &cfg, !configFileFound && !noConfigFile, nil
+}
+
+// checkConfigFile checks that the config file given on the command line exists and can be read
+func checkConfigFile(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w: %s", ErrConfigFileNotFound, path)
+	}
+	if err != nil {
+		return fmt.Errorf("config file %s is not readable: %w", path, err)
+	}
+	return file.Close()
+}
+
+// inheritInstanceSettings decodes the instances on top of the plainid section, so an instance
+// inherits every setting it does not set, except the environments
+func inheritInstanceSettings(v *viper.Viper, cfg *Config) error {
+	rawInstances, ok := v.Get("instances").([]any)
+	if !ok {
+		return nil
+	}
+
+	cfg.Instances = make([]PlainIDInstanceConfig, len(rawInstances))
+	for i, rawInstance := range rawInstances {
+		instance := PlainIDInstanceConfig{PlainIDConfig: cfg.PlainID}
+		instance.Envs = nil
+		// The decoder behaves like viper's Unmarshal
+		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+			DecodeHook: mapstructure.ComposeDecodeHookFunc(
+				mapstructure.StringToTimeDurationHookFunc(),
+				mapstructure.StringToSliceHookFunc(","),
+			),
+			WeaklyTypedInput: true,
+			Result:           &instance,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create decoder for instances[%d]: %w", i, err)
+		}
+		if err := decoder.Decode(rawInstance); err != nil {
+			return fmt.Errorf("failed to unmarshal instances[%d]: %w", i, err)
+		}
+		cfg.Instances[i] = instance
+	}
+	return nil
 }
 
 // RegisterFlags registers all the configuration flags with the provided flag set
 func RegisterFlags(flagSet *pflag.FlagSet) {
 	// Config file flag
 	flagSet.StringP("file", "f", "", "Path to config file (default is .git-backup in current directory or home directory)")
+	flagSet.StringSlice("extra-config", nil, "Path to an additional config file merged on top of the primary one (can be repeated)")
 
 	// Git configuration
 	flagSet.String("git.repo", "", "Git repository URL (git@ or https:// URL)")
 	flagSet.String("git.token", "", "Git token for authentication")
+	flagSet.String("git.auth-username", DefaultGitAuthUsername, "Username sent with the git token (e.g. az for Azure DevOps)")
+	flagSet.String("git.auth-method", GitAuthMethodBasic, "How the git token is sent: basic, or bearer for an Authorization: Bearer header")
+	flagSet.String("git.commit-author-name", "", "Author name of backup commits and tags, meta.tool-name by default (env GIT_AUTHOR_NAME)")
+	flagSet.String("git.commit-author-email", "", "Author email of backup commits and tags, meta.tool-email by default (env GIT_AUTHOR_EMAIL)")
 	flagSet.String("git.branch", "main", "Git branch for storing configuration files")
 	flagSet.Bool("git.delete-temp-on-success", false, "Delete temporary files on successful backup")
+	flagSet.String("git.temp-dir", "", "Directory for temporary clones (default is $TMPDIR or the system temporary directory)")
+	flagSet.Int("git.clone-depth", 1, "Number of commits cloned for a backup, 0 for the full history")
+	flagSet.String("git.ssh-key-path", "", "Path to the SSH private key used for git@ and ssh:// repository URLs instead of the token")
+	flagSet.String("git.ssh-key-passphrase", "", "Passphrase of the SSH private key")
+	flagSet.String("git.socks-proxy", "", "SOCKS5 proxy address (host:port) used to reach the git repository")
+	flagSet.String("git.tag-format", DefaultTagFormat, "Go time layout of backup tag names, {branch} is replaced with git.branch")
+	flagSet.String("git.tag-timezone", DefaultTagTimezone, "Time zone of backup tag names, Local, UTC or a name such as Asia/Singapore")
+	flagSet.String("git.json-indent", "  ", "Indentation of JSON files in the backup, empty for compact JSON")
+	flagSet.Int64("git.github-app-id", 0, "GitHub App ID used for authentication instead of the token")
+	flagSet.Int64("git.github-installation-id", 0, "GitHub App installation ID")
+	flagSet.String("git.github-private-key-path", "", "Path to the GitHub App private key (PEM)")
 
 	// PlainID configuration
 	flagSet.String("plainid.base-url", "", "PlainID token endpoint URL")
 	flagSet.String("plainid.client-id", "", "PlainID client ID")
 	flagSet.String("plainid.client-secret", "", "PlainID client secret")
+	flagSet.String("plainid.token-url", "", "PlainID OAuth2 token URL (default is derived from base URL)")
+	flagSet.String("plainid.app-dir-strategy", AppDirStrategyName, "Naming of application directories: name, id or id_name")
+	flagSet.Bool("plainid.conditional-requests", false, "Send conditional requests (ETag, Last-Modified) and reuse unchanged resources of the previous backup")
+	flagSet.Bool("plainid.backup-audit-log", false, "Back up the PlainID decision logs of every environment")
+	flagSet.Duration("plainid.audit-log-window", 24*time.Hour, "Period of decision logs to back up, ending at the backup time")
+	flagSet.Int("plainid.http-timeout-seconds", DefaultHTTPTimeoutSeconds, "Timeout of a PlainID request in seconds, including reading the response")
+	flagSet.Int("plainid.max-idle-conns", DefaultMaxIdleConns, "Number of idle connections kept open to PlainID for reuse")
+	flagSet.Float64("plainid.rate-limit-rps", DefaultRateLimitRPS, "Maximum PlainID requests per second, 0 for unlimited")
+
+	// Retries of PlainID requests
+	flagSet.Int("retry.max-attempts", 3, "Attempts of a PlainID request failing with 429, 5xx or a connection error, 1 disables retries")
+	flagSet.Duration("retry.initial-delay", time.Second, "Delay before the first retry of a PlainID request, doubled for every further retry")
+	flagSet.Duration("retry.max-delay", 30*time.Second, "Maximum delay between retries of a PlainID request")
+
+	// Tool identity
+	flagSet.String("meta.tool-name", DefaultToolName, "Name of the tool in commit and tag metadata and the PlainID API user agent")
+	flagSet.String("meta.tool-email", DefaultToolEmail, "Email of the tool in commit and tag metadata")
 
 	// Global command options
 	flagSet.Bool("dry-run", false, "Perform a dry run without making changes")
+	flagSet.Bool("pre-flight", false, "Check the PlainID credentials, API access and API version before running the command")
+	flagSet.Bool("use-gitlab-ci-vars", false, "Read the git token and PlainID client credentials from the GITLAB_TOKEN, PLAINID_CLIENT_ID and PLAINID_CLIENT_SECRET CI/CD variables")
+	flagSet.Int("worker-count", 4, "Number of workspaces of an environment backed up concurrently, 1 backs them up one after the other")
+	flagSet.Bool("verify-writes", false, "Re-read every written file and fail the backup if it differs from the fetched content")
+}
+
+// applyGitLabCIVars overrides the credentials with the GitLab CI/CD variables that are set
+func applyGitLabCIVars(cfg *Config) {
+	if token := os.Getenv(GitLabTokenEnv); token != "" {
+		cfg.Git.Token = token
+	}
+	if clientID := os.Getenv(GitLabClientIDEnv); clientID != "" {
+		cfg.PlainID.ClientID = clientID
+	}
+	if clientSecret := os.Getenv(GitLabClientSecretEnv); clientSecret != "" {
+		cfg.PlainID.ClientSecret = clientSecret
+	}
+}
+
+// Validate validates that all required configurations are present and valid
+func Validate(cfg *Config) error {
+	if missingFields := MissingFields(cfg); len(missingFields) > 0 {
+		return errors.New("missing required configuration: " + strings.Join(missingFields, ", "))
+	}
+
+	if err := validateInstanceAliases(cfg.Instances); err != nil {
+		return err
+	}
+	if len(cfg.Instances) == 0 {
+		if err := validatePlainID("plainid", &cfg.PlainID); err != nil {
+			return err
+		}
+	}
+	for i := range cfg.Instances {
+		if err := validatePlainID(fmt.Sprintf("instances[%d]", i), &cfg.Instances[i].PlainIDConfig); err != nil {
+			return err
+		}
+	}
+
+	switch cfg.Git.AuthMethod {
+	case "", GitAuthMethodBasic, GitAuthMethodBearer:
+	default:
+		return fmt.Errorf("invalid configuration: git.auth-method must be one of %s or %s: %s",
+			GitAuthMethodBasic, GitAuthMethodBearer, cfg.Git.AuthMethod)
+	}
+
+	if cfg.Git.Depth < 0 {
+		return errors.New("invalid configuration: git.clone-depth must not be negative")
+	}
+
+	if err := cfg.Git.validateTagFormat(); err != nil {
+		return err
+	}
+
+	if cfg.WorkerCount < 0 {
+		return errors.New("invalid configuration: worker-count must not be negative")
+	}
+
+	if cfg.Retry.MaxAttempts < 0 || cfg.Retry.InitialDelay < 0 || cfg.Retry.MaxDelay < 0 {
+		return errors.New("invalid configuration: retry.max-attempts, retry.initial-delay and retry.max-delay must not be negative")
+	}
+
+	return nil
+}
+
+// validatePlainID validates the PlainID settings of the plainid section or an instance, key is
+// its position in the configuration used in error messages
+func validatePlainID(key string, p *PlainIDConfig) error {
+	for i, env := range p.Envs {
+		for j, ws := range env.Workspaces {
+			if ws.MaxConcurrency < 0 {
+				return fmt.Errorf("invalid configuration: %s.envs[%d].workspaces[%d].max-concurrency must not be negative", key, i, j)
+			}
+		}
+	}
+
+	if err := ValidateUniqueNames(p.Envs); err != nil {
+		return err
+	}
+
+	switch p.AppDirStrategy {
+	case "", AppDirStrategyName, AppDirStrategyID, AppDirStrategyIDName:
+	default:
+		return fmt.Errorf("invalid configuration: %s.app-dir-strategy must be one of %s, %s or %s: %s",
+			key, AppDirStrategyName, AppDirStrategyID, AppDirStrategyIDName, p.AppDirStrategy)
+	}
+
+	if p.BackupAuditLog && p.AuditLogWindow <= 0 {
+		return fmt.Errorf("invalid configuration: %s.audit-log-window must be positive", key)
+	}
+
+	if p.HTTPTimeoutSeconds <= 0 {
+		return fmt.Errorf("invalid configuration: %s.http-timeout-seconds must be positive", key)
+	}
+	if p.MaxIdleConns < 0 {
+		return fmt.Errorf("invalid configuration: %s.max-idle-conns must not be negative", key)
+	}
+	if p.RateLimitRPS < 0 {
+		return fmt.Errorf("invalid configuration: %s.rate-limit-rps must not be negative", key)
+	}
+
+	if p.TokenURL != "" {
+		tokenURL, err := url.Parse(p.TokenURL)
+		if err != nil || tokenURL.Scheme != "https" || tokenURL.Host == "" {
+			return fmt.Errorf("invalid configuration: %s.token-url must be a valid HTTPS URL: %s", key, p.TokenURL)
+		}
+	}
+
+	return nil
 }
 
-// validateConfig validates that all required configurations are present
-func validateConfig(cfg *Config) error {
+// validateInstanceAliases checks that every instance has a unique alias usable as directory name
+func validateInstanceAliases(instances []PlainIDInstanceConfig) error {
+	for i, instance := range instances {
+		if instance.Alias == "." || instance.Alias == ".." || strings.ContainsAny(instance.Alias, `/\`) {
+			return fmt.Errorf("invalid configuration: instances[%d].alias must be usable as a directory name: %s", i, instance.Alias)
+		}
+	}
+	if dups := duplicates(instances, func(instance PlainIDInstanceConfig) string { return instance.Alias }); len(dups) > 0 {
+		return errors.New("invalid configuration: duplicate instance aliases: " + strings.Join(dups, ", "))
+	}
+	return nil
+}
+
+// MissingFields returns the required configuration fields that are not set
+func MissingFields(cfg *Config) []string {
 	var missingFields []string
 
 	if cfg.Git.Repo == "" {
 		missingFields = append(missingFields, "git.repo")
 	}
-	if cfg.Git.Token == "" {
+	if cfg.Git.UsesGitHubApp() {
+		if cfg.Git.GitHubInstallationID == 0 {
+			missingFields = append(missingFields, "git.github-installation-id")
+		}
+		if cfg.Git.GitHubPrivateKeyPath == "" {
+			missingFields = append(missingFields, "git.github-private-key-path")
+		}
+	} else if cfg.Git.Token == "" && !cfg.Git.UsesSSHKey() {
 		missingFields = append(missingFields, "git.token")
 	}
 	if cfg.Git.Branch == "" {
 		missingFields = append(missingFields, "git.branch")
 	}
-	if cfg.PlainID.BaseURL == "" {
-		missingFields = append(missingFields, "plainid.base-url")
+
+	if len(cfg.Instances) == 0 {
+		return append(missingFields, missingPlainIDFields("plainid", &cfg.PlainID)...)
 	}
-	if cfg.PlainID.ClientID == "" {
-		missingFields = append(missingFields, "plainid.client-id")
+	for i, instance := range cfg.Instances {
+		key := fmt.Sprintf("instances[%d]", i)
+		if instance.Alias == "" {
+			missingFields = append(missingFields, key+".alias")
+		}
+		missingFields = append(missingFields, missingPlainIDFields(key, &instance.PlainIDConfig)...)
 	}
-	if cfg.PlainID.ClientSecret == "" {
-		missingFields = append(missingFields, "plainid.client-secret")
+	return missingFields
+}
+
+// missingPlainIDFields returns the required fields that are not set in the plainid section or an
+// instance, prefixed with key
+func missingPlainIDFields(key string, p *PlainIDConfig) []string {
+	var missingFields []string
+
+	if p.BaseURL == "" {
+		missingFields = append(missingFields, key+".base-url")
+	}
+	if p.ClientID == "" {
+		missingFields = append(missingFields, key+".client-id")
+	}
+	if p.ClientSecret == "" {
+		missingFields = append(missingFields, key+".client-secret")
 	}
 
 	// Check for environments and workspaces
-	if len(cfg.PlainID.Envs) == 0 {
-		missingFields = append(missingFields, "plainid.envs")
+	if len(p.Envs) == 0 {
+		missingFields = append(missingFields, key+".envs")
 	} else {
 		// Check each environment has an ID and at least one workspace (unless it's a wildcard environment)
-		for i, env := range cfg.PlainID.Envs {
+		for i, env := range p.Envs {
 			if env.ID == "" {
-				missingFields = append(missingFields, fmt.Sprintf("plainid.envs[%d].id", i))
+				missingFields = append(missingFields, fmt.Sprintf("%s.envs[%d].id", key, i))
 			}
 			// For wildcard environments, workspaces are optional
 			if len(env.Workspaces) == 0 && !env.IsWildcard() {
-				missingFields = append(missingFields, fmt.Sprintf("plainid.envs[%d].workspaces", i))
+				missingFields = append(missingFields, fmt.Sprintf("%s.envs[%d].workspaces", key, i))
 			}
 			// Check for identities in each environment
 			if len(env.Identities) == 0 {
-				missingFields = append(missingFields, fmt.Sprintf("plainid.envs[%d].identities", i))
+				missingFields = append(missingFields, fmt.Sprintf("%s.envs[%d].identities", key, i))
 			}
 		}
 	}
 
-	if len(missingFields) > 0 {
-		return errors.New("missing required configuration: " + strings.Join(missingFields, ", "))
+	return missingFields
+}
+
+// ValidateUniqueNames checks that environment names are unique, and workspace names within each
+// environment, as backup directories are named after them. Empty names are not checked, names of
+// workspaces are usually resolved from PlainID after the configuration is loaded.
+func ValidateUniqueNames(envs []Environment) error {
+	var problems []string
+
+	if dups := duplicates(envs, func(env Environment) string { return env.Name }); len(dups) > 0 {
+		problems = append(problems, "duplicate environment names: "+strings.Join(dups, ", "))
+	}
+	for _, env := range envs {
+		if dups := duplicates(env.Workspaces, func(ws Workspace) string { return ws.Name }); len(dups) > 0 {
+			problems = append(problems, fmt.Sprintf("duplicate workspace names in environment %s: %s", env.ID, strings.Join(dups, ", ")))
+		}
 	}
 
+	if len(problems) > 0 {
+		return errors.New("invalid configuration: " + strings.Join(problems, "; "))
+	}
 	return nil
 }
 
+// duplicates returns the non-empty names given more than once, in order of first repetition
+func duplicates[T any](items []T, name func(T) string) []string {
+	seen := make(map[string]int, len(items))
+	var dups []string
+	for _, item := range items {
+		n := name(item)
+		if n == "" {
+			continue
+		}
+		seen[n]++
+		if seen[n] == 2 {
+			dups = append(dups, n)
+		}
+	}
+	return dups
+}
+
 // homeDir returns the user's home directory or current directory if it can't be determined
 func homeDir() string {
 	home, err := os.UserHomeDir()
@@ -0,0 +1,427 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/stretchr/testify/suite"
+)
+
+// ConfigTestSuite tests configuration validation
+type ConfigTestSuite struct {
+	suite.Suite
+	cfg Config
+}
+
+func TestConfigSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+// SetupTest starts every test from a valid configuration
+func (s *ConfigTestSuite) SetupTest() {
+	s.cfg = Config{
+		Git: GitConfig{
+			Repo:   "https://github.com/organization/repo.git",
+			Token:  "token",
+			Branch: "main",
+		},
+		PlainID: PlainIDConfig{
+			BaseURL:            "https://api.plainid.io",
+			ClientID:           "client-id",
+			ClientSecret:       "client-secret",
+			HTTPTimeoutSeconds: DefaultHTTPTimeoutSeconds,
+			Envs: []Environment{{
+				ID:         "env1",
+				Workspaces: []Workspace{{ID: "ws1"}},
+				Identities: []string{"User"},
+			}},
+		},
+	}
+}
+
+func (s *ConfigTestSuite) TestValidConfig() {
+	s.Assert().NoError(Validate(&s.cfg))
+}
+
+func (s *ConfigTestSuite) TestTokenURLDefaultsToBaseURL() {
+	s.Assert().Equal("https://api.plainid.io/api/1.0/api-key/token", s.cfg.PlainID.OAuth2TokenURL())
+
+	s.cfg.PlainID.TokenURL = "https://auth.plainid.io/oauth/token"
+	s.Assert().Equal("https://auth.plainid.io/oauth/token", s.cfg.PlainID.OAuth2TokenURL())
+	s.Assert().NoError(Validate(&s.cfg))
+}
+
+func (s *ConfigTestSuite) TestTokenURLMustBeHTTPS() {
+	for _, tokenURL := range []string{"http://auth.plainid.io/token", "auth.plainid.io/token", "https://"} {
+		s.cfg.PlainID.TokenURL = tokenURL
+		err := Validate(&s.cfg)
+		s.Require().Error(err, tokenURL)
+		s.Assert().Contains(err.Error(), "plainid.token-url")
+	}
+}
+
+func (s *ConfigTestSuite) TestSSHKeyReplacesToken() {
+	s.cfg.Git.Token = ""
+	s.cfg.Git.SSHKeyPath = "/keys/id_ed25519"
+
+	// The SSH key is not used for HTTPS URLs, which still need the token
+	s.Require().ErrorContains(Validate(&s.cfg), "git.token")
+
+	for _, repo := range []string{"git@gitlab.example.com:org/repo.git", "ssh://git@gitea.example.com:2222/org/repo.git"} {
+		s.cfg.Git.Repo = repo
+		s.Assert().True(s.cfg.Git.UsesSSHKey(), repo)
+		s.Assert().NoError(Validate(&s.cfg), repo)
+	}
+}
+
+func (s *ConfigTestSuite) TestGitAuthMethod() {
+	for _, method := range []string{"", GitAuthMethodBasic, GitAuthMethodBearer} {
+		s.cfg.Git.AuthMethod = method
+		s.Assert().NoError(Validate(&s.cfg), method)
+	}
+	s.cfg.Git.AuthMethod = "digest"
+	s.Assert().ErrorContains(Validate(&s.cfg), "git.auth-method must be one of basic or bearer: digest")
+}
+
+func (s *ConfigTestSuite) TestHTTPClientLimits() {
+	s.cfg.PlainID.HTTPTimeoutSeconds = 0
+	s.Assert().ErrorContains(Validate(&s.cfg), "plainid.http-timeout-seconds must be positive")
+
+	s.cfg.PlainID.HTTPTimeoutSeconds = 10
+	s.cfg.PlainID.MaxIdleConns = -1
+	s.Assert().ErrorContains(Validate(&s.cfg), "plainid.max-idle-conns must not be negative")
+
+	s.cfg.PlainID.MaxIdleConns = 0
+	s.cfg.PlainID.RateLimitRPS = -1
+	s.Assert().ErrorContains(Validate(&s.cfg), "plainid.rate-limit-rps must not be negative")
+}
+
+func (s *ConfigTestSuite) TestAppDirName() {
+	s.Assert().Equal("Payments", s.cfg.PlainID.AppDirName("app-1", "Payments"))
+
+	for strategy, dir := range map[string]string{
+		AppDirStrategyName:   "Payments",
+		AppDirStrategyID:     "app-1",
+		AppDirStrategyIDName: "app-1_Payments",
+	} {
+		s.cfg.PlainID.AppDirStrategy = strategy
+		s.Assert().Equal(dir, s.cfg.PlainID.AppDirName("app-1", "Payments"), strategy)
+		s.Assert().NoError(Validate(&s.cfg), strategy)
+	}
+
+	s.cfg.PlainID.AppDirStrategy = "uuid"
+	err := Validate(&s.cfg)
+	s.Require().Error(err)
+	s.Assert().Contains(err.Error(), "plainid.app-dir-strategy")
+}
+
+func (s *ConfigTestSuite) TestIdentities() {
+	env := Environment{ID: "env1", Identities: []string{"User"}}
+	s.Assert().False(env.HasWildcardIdentities())
+	s.Assert().True(env.ContainsIdentity("User"))
+	s.Assert().False(env.ContainsIdentity("Device"))
+
+	env.Identities = append(env.Identities, "*")
+	s.Assert().True(env.HasWildcardIdentities())
+	s.Assert().True(env.ContainsIdentity("Device"))
+}
+
+func (s *ConfigTestSuite) TestTagName() {
+	backupTime := time.Date(2025, time.March, 4, 23, 30, 0, 0, time.UTC)
+	s.cfg.Git.TagTimezone = "UTC"
+	name, err := s.cfg.Git.TagName(backupTime)
+	s.Require().NoError(err)
+	s.Assert().Equal("20250304-233000", name)
+
+	// The branch is not read as a time layout, the tag is named in its time zone
+	s.cfg.Git.Branch = "Mon-1"
+	s.cfg.Git.TagFormat = "backup/{branch}/2006-01-02T1504"
+	s.cfg.Git.TagTimezone = "Asia/Singapore"
+	s.Require().NoError(Validate(&s.cfg))
+	name, err = s.cfg.Git.TagName(backupTime)
+	s.Require().NoError(err)
+	s.Assert().Equal("backup/Mon-1/2025-03-05T0730", name)
+	parsed, ok := s.cfg.Git.ParseTagTime(name)
+	s.Require().True(ok)
+	s.Assert().True(parsed.Equal(backupTime))
+
+	// The branch may be part of the date, only the placeholder is taken for the branch
+	s.cfg.Git.Branch = "25"
+	s.cfg.Git.TagFormat = "2006{branch}-20060102-150405"
+	name, err = s.cfg.Git.TagName(backupTime)
+	s.Require().NoError(err)
+	s.Assert().Equal("202525-20250305-073000", name)
+	parsed, ok = s.cfg.Git.ParseTagTime(name)
+	s.Require().True(ok)
+	s.Assert().True(parsed.Equal(backupTime))
+	_, ok = s.cfg.Git.ParseTagTime("202524-20250305-073000")
+	s.Assert().False(ok, "a tag of another branch is not a backup of this one")
+
+	// Tags of the default format remain backups
+	_, ok = s.cfg.Git.ParseTagTime("20250304-233000")
+	s.Assert().True(ok)
+	_, ok = s.cfg.Git.ParseTagTime("v1.0.0")
+	s.Assert().False(ok)
+
+	s.cfg.Git.TagFormat = "backup 20060102"
+	s.Assert().ErrorContains(Validate(&s.cfg), "is not a valid git tag")
+	s.cfg.Git.TagFormat = "backup-{branch}"
+	s.Assert().ErrorContains(Validate(&s.cfg), "git.tag-format must include the date or time of the backup")
+
+	s.cfg.Git.TagFormat = ""
+	s.cfg.Git.TagTimezone = "Mars/Olympus"
+	s.Assert().ErrorContains(Validate(&s.cfg), "git.tag-timezone is not a valid time zone")
+}
+
+func (s *ConfigTestSuite) TestUniqueNames() {
+	s.cfg.PlainID.Envs[0].Workspaces = []Workspace{{ID: "ws1", Name: "Main"}, {ID: "ws2"}, {ID: "ws3", Name: "Main"}}
+	s.cfg.PlainID.Envs = append(s.cfg.PlainID.Envs, Environment{
+		ID:         "env2",
+		Workspaces: []Workspace{{ID: "ws4"}, {ID: "ws5"}},
+		Identities: []string{"User"},
+	})
+	err := Validate(&s.cfg)
+	s.Require().Error(err)
+	s.Assert().Contains(err.Error(), "duplicate workspace names in environment env1: Main")
+	s.Assert().NotContains(err.Error(), "env2", "empty names are resolved later")
+
+	s.cfg.PlainID.Envs[0].Workspaces[2].Name = "Other"
+	s.Assert().NoError(Validate(&s.cfg))
+
+	s.cfg.PlainID.Envs[0].Name = "Production"
+	s.cfg.PlainID.Envs[1].Name = "Production"
+	s.Assert().ErrorContains(Validate(&s.cfg), "duplicate environment names: Production")
+}
+
+func (s *ConfigTestSuite) TestTemplateIsValidConfig() {
+	var template strings.Builder
+	PrintTemplate(&template)
+
+	path := filepath.Join(s.T().TempDir(), "config.yaml")
+	s.Require().NoError(os.WriteFile(path, []byte(template.String()), 0600))
+
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", path}))
+
+	cfg, err := LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("main", cfg.Git.Branch)
+	s.Assert().Equal(24*time.Hour, cfg.PlainID.AuditLogWindow)
+}
+
+func (s *ConfigTestSuite) TestMissingConfigFile() {
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", filepath.Join(s.T().TempDir(), "missing.yaml")}))
+
+	_, err := LoadConfig(flagSet)
+	s.Assert().ErrorIs(err, ErrConfigFileNotFound)
+}
+
+func (s *ConfigTestSuite) TestConfigFlag() {
+	dir := s.T().TempDir()
+	var template strings.Builder
+	PrintTemplate(&template)
+	configFile := filepath.Join(dir, "backup.yaml")
+	s.Require().NoError(os.WriteFile(configFile, []byte(template.String()), 0600))
+
+	// The root command registers --config as an alias of --file
+	newFlagSet := func() *pflag.FlagSet {
+		flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+		RegisterFlags(flagSet)
+		flagSet.String("config", "", "")
+		return flagSet
+	}
+
+	flagSet := newFlagSet()
+	s.Require().NoError(flagSet.Parse([]string{"--config", configFile}))
+	cfg, err := LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("main", cfg.Git.Branch)
+
+	// Environment variables still override the file
+	s.T().Setenv("GIT_BRANCH", "release")
+	cfg, err = LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("release", cfg.Git.Branch)
+
+	// The commit author follows the git variables
+	s.T().Setenv("GIT_AUTHOR_NAME", "Backup CI")
+	s.T().Setenv("GIT_AUTHOR_EMAIL", "backup-ci@example.com")
+	cfg, err = LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("Backup CI", cfg.Git.CommitAuthorName)
+	s.Assert().Equal("backup-ci@example.com", cfg.Git.CommitAuthorEmail)
+
+	flagSet = newFlagSet()
+	s.Require().NoError(flagSet.Parse([]string{"--config", filepath.Join(dir, "missing.yaml")}))
+	_, err = LoadConfig(flagSet)
+	s.Assert().ErrorIs(err, ErrConfigFileNotFound)
+}
+
+func (s *ConfigTestSuite) TestExtraConfig() {
+	dir := s.T().TempDir()
+	var template strings.Builder
+	PrintTemplate(&template)
+	configFile := filepath.Join(dir, "config.yaml")
+	s.Require().NoError(os.WriteFile(configFile, []byte(template.String()), 0600))
+	secretsFile := filepath.Join(dir, "secrets.yml")
+	s.Require().NoError(os.WriteFile(secretsFile, []byte(`
+git:
+  branch: "release"
+plainid:
+  client-secret: "override-secret"
+`), 0600))
+	lastFile := filepath.Join(dir, "last.conf")
+	s.Require().NoError(os.WriteFile(lastFile, []byte(`
+git:
+  branch: "hotfix"
+`), 0600))
+
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", configFile, "--extra-config", secretsFile, "--extra-config", lastFile}))
+
+	cfg, err := LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("override-secret", cfg.PlainID.ClientSecret, "override keys should win")
+	s.Assert().Equal("hotfix", cfg.Git.Branch, "later override files should win")
+	s.Assert().Equal("your-client-id", cfg.PlainID.ClientID, "keys the overrides do not set should be kept")
+	s.Assert().Equal("https://api.plainid.io", cfg.PlainID.BaseURL)
+	s.Assert().Equal("https://github.com/organization/repo.git", cfg.Git.Repo)
+
+	flagSet = pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", configFile, "--extra-config", filepath.Join(dir, "missing.yaml")}))
+	_, err = LoadConfig(flagSet)
+	s.Assert().ErrorContains(err, "failed to merge extra config file")
+}
+
+func (s *ConfigTestSuite) TestNoConfigFileEnv() {
+	// A config file in the working directory must be ignored
+	dir := s.T().TempDir()
+	var template strings.Builder
+	PrintTemplate(&template)
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, DefaultConfigFileName+".yaml"), []byte(template.String()), 0600))
+	wd, err := os.Getwd()
+	s.Require().NoError(err)
+	s.Require().NoError(os.Chdir(dir))
+	defer func() { s.Require().NoError(os.Chdir(wd)) }()
+
+	s.T().Setenv(NoConfigFileEnv, "true")
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+
+	_, err = LoadConfig(flagSet)
+	s.Require().Error(err)
+	s.Assert().NotErrorIs(err, ErrConfigFileNotFound, "a missing config file is expected")
+	s.Assert().Contains(err.Error(), "git.repo", "the config file should not have been read")
+}
+
+func (s *ConfigTestSuite) TestUseGitLabCIVars() {
+	path := filepath.Join(s.T().TempDir(), "config.yaml")
+	var template strings.Builder
+	PrintTemplate(&template)
+	s.Require().NoError(os.WriteFile(path, []byte(template.String()), 0600))
+
+	s.T().Setenv(GitLabTokenEnv, "glpat-token")
+	s.T().Setenv(GitLabClientSecretEnv, "ci-client-secret")
+
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", path, "--plainid.client-id", "flag-client-id"}))
+
+	cfg, err := LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().NotEqual("glpat-token", cfg.Git.Token, "GitLab variables are only used when enabled")
+
+	s.Require().NoError(flagSet.Parse([]string{"--use-gitlab-ci-vars"}))
+	cfg, err = LoadConfig(flagSet)
+	s.Require().NoError(err)
+	s.Assert().Equal("glpat-token", cfg.Git.Token)
+	s.Assert().Equal("ci-client-secret", cfg.PlainID.ClientSecret)
+	s.Assert().Equal("flag-client-id", cfg.PlainID.ClientID, "unset variables keep the configured value")
+}
+
+func (s *ConfigTestSuite) TestInstances() {
+	path := filepath.Join(s.T().TempDir(), "config.yaml")
+	s.Require().NoError(os.WriteFile(path, []byte(`
+git:
+  repo: "https://github.com/organization/repo.git"
+  token: "token"
+plainid:
+  client-id: "shared-client-id"
+  client-secret: "shared-client-secret"
+  app-dir-strategy: "id"
+instances:
+  - alias: "retail"
+    base-url: "https://retail.plainid.io"
+    envs:
+      - id: "env1"
+        workspaces: [{id: "ws1"}]
+        identities: ["User"]
+  - alias: "banking"
+    base-url: "https://banking.plainid.io"
+    client-secret: "banking-client-secret"
+    http-timeout-seconds: 5
+    envs:
+      - id: "env2"
+        workspaces: [{id: "ws2"}]
+        identities: ["User"]
+`), 0600))
+
+	flagSet := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	RegisterFlags(flagSet)
+	s.Require().NoError(flagSet.Parse([]string{"--file", path}))
+
+	cfg, err := LoadConfig(flagSet)
+	s.Require().NoError(err)
+	instances := cfg.PlainIDInstances()
+	s.Require().Len(instances, 2)
+
+	retail, banking := instances[0], instances[1]
+	s.Assert().Equal("retail", retail.Alias)
+	s.Assert().Equal("https://retail.plainid.io", retail.BaseURL)
+	s.Assert().Equal("shared-client-secret", retail.ClientSecret, "unset settings are inherited from the plainid section")
+	s.Assert().Equal(AppDirStrategyID, retail.AppDirStrategy)
+	s.Assert().Equal(DefaultHTTPTimeoutSeconds, retail.HTTPTimeoutSeconds, "flag defaults are inherited as well")
+	s.Assert().Equal("banking-client-secret", banking.ClientSecret)
+	s.Assert().Equal(5, banking.HTTPTimeoutSeconds)
+	s.Assert().Equal("shared-client-id", banking.ClientID)
+	s.Require().Len(banking.Envs, 1)
+	s.Assert().Equal("env2", banking.Envs[0].ID, "environments are not inherited")
+
+	snapshot, err := cfg.SnapshotYAML()
+	s.Require().NoError(err)
+	s.Assert().Contains(string(snapshot), "alias: banking")
+	s.Assert().NotContains(string(snapshot), "banking-client-secret")
+}
+
+func (s *ConfigTestSuite) TestInstancesValidation() {
+	s.Assert().Equal([]PlainIDInstanceConfig{{PlainIDConfig: s.cfg.PlainID}}, s.cfg.PlainIDInstances(),
+		"the plainid section is the only instance without instances")
+
+	s.cfg.Instances = []PlainIDInstanceConfig{
+		{Alias: "retail", PlainIDConfig: s.cfg.PlainID},
+		{PlainIDConfig: s.cfg.PlainID},
+	}
+	s.cfg.Instances[1].BaseURL = ""
+	s.cfg.PlainID = PlainIDConfig{}
+	s.Assert().EqualError(Validate(&s.cfg), "missing required configuration: instances[1].alias, instances[1].base-url")
+
+	s.cfg.Instances[1].Alias = "retail"
+	s.cfg.Instances[1].BaseURL = "https://api.plainid.io"
+	s.Assert().EqualError(Validate(&s.cfg), "invalid configuration: duplicate instance aliases: retail")
+
+	s.cfg.Instances[1].Alias = "../banking"
+	s.Assert().ErrorContains(Validate(&s.cfg), "instances[1].alias must be usable as a directory name")
+
+	s.cfg.Instances[1].Alias = "banking"
+	s.cfg.Instances[1].HTTPTimeoutSeconds = 0
+	s.Assert().EqualError(Validate(&s.cfg), "invalid configuration: instances[1].http-timeout-seconds must be positive")
+}
@@ -0,0 +1,36 @@
+//go:build k8s
+
+package config
+
+import (
+	"context"
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+// readK8sSecret reads a key of a Kubernetes Secret using the in-cluster service account
+func readK8sSecret(ref K8sSecretRef) (string, error) {
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return "", fmt.Errorf("failed to load in-cluster Kubernetes config: %w", err)
+	}
+
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return "", fmt.Errorf("failed to create Kubernetes client: %w", err)
+	}
+
+	secret, err := client.CoreV1().Secrets(ref.Namespace).Get(context.Background(), ref.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get secret %s/%s: %w", ref.Namespace, ref.SecretName, err)
+	}
+
+	value, ok := secret.Data[ref.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s/%s has no key %s", ref.Namespace, ref.SecretName, ref.Key)
+	}
+	return string(value), nil
+}
@@ -0,0 +1,10 @@
+//go:build !k8s
+
+package config
+
+import "errors"
+
+// readK8sSecret is not available without the Kubernetes client, see k8s.go
+func readK8sSecret(_ K8sSecretRef) (string, error) {
+	return "", errors.New("git.token-from-k8s-secret requires a build with Kubernetes support (-tags k8s)")
+}
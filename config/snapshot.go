@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maskedSecret replaces secrets in configuration snapshots
+const maskedSecret = "***"
+
+// Masked returns a copy of the configuration with secrets replaced by ***
+func (c Config) Masked() Config {
+	masked := c
+	if masked.Git.Token != "" {
+		masked.Git.Token = maskedSecret
+	}
+	if masked.Git.SSHKeyPassphrase != "" {
+		masked.Git.SSHKeyPassphrase = maskedSecret
+	}
+	if masked.PlainID.ClientSecret != "" {
+		masked.PlainID.ClientSecret = maskedSecret
+	}
+	if c.Instances != nil {
+		masked.Instances = make([]PlainIDInstanceConfig, len(c.Instances))
+		for i, instance := range c.Instances {
+			if instance.ClientSecret != "" {
+				instance.ClientSecret = maskedSecret
+			}
+			masked.Instances[i] = instance
+		}
+	}
+	return masked
+}
+
+// SnapshotYAML renders the configuration, with secrets masked, as YAML using the configuration file keys
+func (c Config) SnapshotYAML() ([]byte, error) {
+	out, err := yaml.Marshal(snapshotValue(reflect.ValueOf(c.Masked())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal config snapshot: %w", err)
+	}
+	return out, nil
+}
+
+// snapshotValue converts structs to maps keyed like the configuration file
+func snapshotValue(v reflect.Value) any {
+	switch v.Kind() {
+	case reflect.Pointer:
+		if v.IsNil() {
+			return nil
+		}
+		return snapshotValue(v.Elem())
+	case reflect.Struct:
+		m := make(map[string]any, v.NumField())
+		for i := range v.NumField() {
+			field := v.Type().Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			key, opts, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+			// Squashed structs are keyed like the fields of the enclosing struct
+			if opts == "squash" {
+				for k, value := range snapshotValue(v.Field(i)).(map[string]any) {
+					m[k] = value
+				}
+				continue
+			}
+			if key == "" {
+				key = strings.ToLower(field.Name)
+			}
+			m[key] = snapshotValue(v.Field(i))
+		}
+		return m
+	case reflect.Slice:
+		if v.IsNil() {
+			return nil
+		}
+		s := make([]any, v.Len())
+		for i := range v.Len() {
+			s[i] = snapshotValue(v.Index(i))
+		}
+		return s
+	default:
+		return v.Interface()
+	}
+}
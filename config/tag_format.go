@@ -0,0 +1,108 @@
+package config
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// Defaults of the backup tag names
+const (
+	// DefaultTagFormat is the Go time layout of backup tags, YYYYMMDD-HHMMSS
+	DefaultTagFormat   = "20060102-150405"
+	DefaultTagTimezone = "Local"
+	// TagBranchPlaceholder is replaced with git.branch in git.tag-format
+	TagBranchPlaceholder = "{branch}"
+)
+
+// tagLocation returns the time zone backup tags are named in
+func (g *GitConfig) tagLocation() (*time.Location, error) {
+	loc, err := time.LoadLocation(cmp.Or(g.TagTimezone, DefaultTagTimezone))
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration: git.tag-timezone is not a valid time zone: %w", err)
+	}
+	return loc, nil
+}
+
+// TagName returns the name of the backup tag of t, formatted with TagFormat in TagTimezone. The
+// branch replaces its placeholder after formatting, so its letters and digits are never taken for
+// parts of the layout.
+func (g *GitConfig) TagName(t time.Time) (string, error) {
+	loc, err := g.tagLocation()
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(cmp.Or(g.TagFormat, DefaultTagFormat), TagBranchPlaceholder)
+	for i, part := range parts {
+		parts[i] = t.In(loc).Format(part)
+	}
+	name := strings.Join(parts, g.Branch)
+
+	if err := plumbing.NewTagReferenceName(name).Validate(); err != nil {
+		return "", fmt.Errorf("backup tag name %q of git.tag-format is not a valid git tag: %w", name, err)
+	}
+	return name, nil
+}
+
+// ParseTagTime returns the time of a backup tag named with TagFormat. Tags named with the default
+// format in local time, before TagFormat or TagTimezone were changed, are recognized as well.
+func (g *GitConfig) ParseTagTime(name string) (time.Time, bool) {
+	if loc, err := g.tagLocation(); err == nil {
+		layouts := strings.Split(cmp.Or(g.TagFormat, DefaultTagFormat), TagBranchPlaceholder)
+		if t, ok := parseBranchTag(layouts, name, g.Branch, nil, loc); ok {
+			return t, true
+		}
+	}
+	if t, err := time.ParseInLocation(DefaultTagFormat, name, time.Local); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// tagLayoutSeparator stands for the branch between the layouts of a tag format while it is parsed,
+// it is a literal for time.Parse that cannot be part of a tag name
+const tagLayoutSeparator = "\x00"
+
+// parseBranchTag parses name, the layouts joined by the branch. The branch may also appear in the
+// formatted time, a release-2024 branch in 2024 for instance, so every occurrence of the branch is
+// tried in turn as the one standing for the next placeholder. segments are the parts of the name
+// before the placeholders already matched.
+func parseBranchTag(layouts []string, name, branch string, segments []string, loc *time.Location) (time.Time, bool) {
+	if len(segments) == len(layouts)-1 {
+		value := strings.Join(append(segments, name), tagLayoutSeparator)
+		t, err := time.ParseInLocation(strings.Join(layouts, tagLayoutSeparator), value, loc)
+		return t, err == nil
+	}
+	for start := 0; start <= len(name); {
+		i := strings.Index(name[start:], branch)
+		if i < 0 {
+			break
+		}
+		i += start
+		if t, ok := parseBranchTag(layouts, name[i+len(branch):], branch, append(slices.Clone(segments), name[:i]), loc); ok {
+			return t, true
+		}
+		start = i + 1
+	}
+	return time.Time{}, false
+}
+
+// validateTagFormat checks that backup tags are valid git tags named after the backup time
+func (g *GitConfig) validateTagFormat() error {
+	first, err := g.TagName(time.Date(2001, time.February, 3, 4, 5, 6, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	second, err := g.TagName(time.Date(2012, time.November, 22, 13, 14, 15, 0, time.UTC))
+	if err != nil {
+		return err
+	}
+	if first == second {
+		return fmt.Errorf("invalid configuration: git.tag-format must include the date or time of the backup: %s", g.TagFormat)
+	}
+	return nil
+}
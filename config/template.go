@@ -0,0 +1,93 @@
+package config
+
+import (
+	"fmt"
+	"io"
+)
+
+// configTemplate is a starter configuration file listing every option
+const configTemplate = `# git-backup configuration, save as .git-backup in the current or home directory
+# or pass it with -f. Every key can also be set as a flag or environment variable.
+
+# Git configuration
+git:
+    # Repository the backups are pushed to (HTTPS URL)
+    repo: "https://github.com/organization/repo.git"
+    # Token used for authentication, not needed with a GitHub App
+    token: "your-git-token"
+    # Username sent with the token, use "az" for Azure DevOps
+    auth-username: "oauth2"
+    # How the token is sent: "basic", or "bearer" for an Authorization: Bearer header
+    auth-method: "basic"
+    # Author of backup commits and tags, the tool identity in meta by default
+    # commit-author-name: "Backup CI"
+    # commit-author-email: "backup-ci@example.com"
+    # Branch the backups are committed to
+    branch: "main"
+    # Delete the temporary clone after a successful backup
+    delete-temp-on-success: false
+    # Go time layout of backup tags, {branch} is replaced with the branch, and their time zone
+    tag-format: "20060102-150405"
+    tag-timezone: "Local"
+    # Indentation of JSON files, "" for compact JSON
+    json-indent: "  "
+    # SSH key used instead of the token for git@ and ssh:// repository URLs
+    # ssh-key-path: "/home/backup/.ssh/id_ed25519"
+    # ssh-key-passphrase: ""
+    # SOCKS5 proxy used for git operations (host:port)
+    # socks-proxy: "proxy.example.com:1080"
+    # GitHub App authentication, used instead of the token
+    # github-app-id: 12345
+    # github-installation-id: 67890
+    # github-private-key-path: "/path/to/private-key.pem"
+
+# PlainID configuration
+plainid:
+    # PlainID API base URL
+    base-url: "https://api.plainid.io"
+    client-id: "your-client-id"
+    client-secret: "your-client-secret"
+    # OAuth2 token URL, defaults to <base-url>/api/1.0/api-key/token
+    # token-url: "https://auth.example.com/oauth2/token"
+    # Naming of application directories: name, id or id_name (<appID>_<appName>)
+    app-dir-strategy: name
+    # Back up the decision logs of the last audit-log-window
+    backup-audit-log: false
+    audit-log-window: 24h
+    # Timeout of every PlainID request in seconds, and idle connections kept for reuse
+    http-timeout-seconds: 30
+    max-idle-conns: 10
+    # Maximum requests per second sent to PlainID, 0 for unlimited
+    rate-limit-rps: 10
+    envs:
+        # Environment ID, or "*" for all environments
+        - id: "environment-id"
+          # Optional name used in the backup directory name
+          name: "production"
+          workspaces:
+              # Workspace ID, or "*" for all workspaces
+              - id: "*"
+                # Optional limit of applications processed concurrently
+                # max-concurrency: 4
+          # Identity templates to back up, or "*" for all
+          identities:
+              - "*"
+
+# Retries of PlainID requests failing with 429, 5xx or a connection error
+retry:
+    # Attempts including the first one, 1 disables retries
+    max-attempts: 3
+    # Delay before the first retry, doubled for every further retry up to max-delay
+    initial-delay: 1s
+    max-delay: 30s
+
+# Identity of the tool in commits, tags and API calls
+meta:
+    tool-name: "PlainID Git Backup"
+    tool-email: "git-backup@plainid.com"
+`
+
+// PrintTemplate writes a commented configuration file template with placeholder values to w
+func PrintTemplate(w io.Writer) {
+	fmt.Fprint(w, configTemplate)
+}
@@ -0,0 +1,100 @@
+// Package circuitbreaker stops calling an API that appears to be down instead of
+// letting every request of a backup fail on its own.
+package circuitbreaker
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultThreshold is the number of consecutive failures that opens the circuit
+	DefaultThreshold = 5
+	// DefaultCooldown is how long an open circuit fails requests before letting them through again
+	DefaultCooldown = 60 * time.Second
+)
+
+// ErrOpen is returned for requests made while the circuit is open
+var ErrOpen = errors.New("circuit open: PlainID API appears to be down")
+
+// Breaker is an http.RoundTripper that counts consecutive 5xx responses and connection errors
+// per base URL and fails requests immediately once the threshold is reached, until the cooldown ends.
+// The circuit is then half-open: a single request probes the API, the others keep failing until
+// the probe succeeds and closes the circuit, or fails and opens it again.
+type Breaker struct {
+	base      http.RoundTripper
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+// hostState is the circuit of a single base URL
+type hostState struct {
+	failures  int
+	openUntil time.Time
+	// probing is set while the request probing a half-open circuit is in flight
+	probing bool
+}
+
+// New returns a Breaker around base with the default threshold and cooldown
+func New(base http.RoundTripper) *Breaker {
+	return &Breaker{
+		base:      base,
+		threshold: DefaultThreshold,
+		cooldown:  DefaultCooldown,
+		now:       time.Now,
+		hosts:     make(map[string]*hostState),
+	}
+}
+
+func (b *Breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.Scheme + "://" + req.URL.Host
+
+	b.mu.Lock()
+	state, ok := b.hosts[key]
+	if !ok {
+		state = &hostState{}
+		b.hosts[key] = state
+	}
+	probe := state.failures >= b.threshold
+	if probe {
+		if b.now().Before(state.openUntil) || state.probing {
+			b.mu.Unlock()
+			return nil, ErrOpen
+		}
+		state.probing = true
+	}
+	b.mu.Unlock()
+
+	resp, err := b.base.RoundTrip(req)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		state.probing = false
+	}
+	if err == nil && resp.StatusCode < http.StatusInternalServerError {
+		state.failures = 0
+		return resp, err
+	}
+	// A cancelled request says nothing about the API
+	if err != nil && req.Context().Err() != nil {
+		return resp, err
+	}
+
+	// After a cooldown the failure count is kept, so a single failure opens the circuit again
+	state.failures++
+	if state.failures >= b.threshold {
+		state.openUntil = b.now().Add(b.cooldown)
+		log.Warn().Str("url", key).Int("failures", state.failures).Dur("cooldown", b.cooldown).
+			Msg("Too many consecutive PlainID API failures, circuit opened")
+	}
+	return resp, err
+}
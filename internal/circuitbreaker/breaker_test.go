@@ -0,0 +1,146 @@
+package circuitbreaker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// BreakerTestSuite tests the circuit breaker against a fake API whose status can be changed
+type BreakerTestSuite struct {
+	suite.Suite
+	server   *httptest.Server
+	status   int
+	requests int
+	now      time.Time
+	client   *http.Client
+}
+
+func TestBreakerSuite(t *testing.T) {
+	suite.Run(t, new(BreakerTestSuite))
+}
+
+func (s *BreakerTestSuite) SetupTest() {
+	s.status = http.StatusOK
+	s.requests = 0
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		s.requests++
+		w.WriteHeader(s.status)
+	}))
+
+	s.now = time.Now()
+	breaker := New(http.DefaultTransport)
+	breaker.now = func() time.Time { return s.now }
+	s.client = &http.Client{Transport: breaker}
+}
+
+func (s *BreakerTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// get requests the fake API and returns the status, or the error of the client
+func (s *BreakerTestSuite) get() (int, error) {
+	resp, err := s.client.Get(s.server.URL + "/api/1.0/environments")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+func (s *BreakerTestSuite) TestOpensAfterConsecutiveFailures() {
+	s.status = http.StatusServiceUnavailable
+	for range DefaultThreshold {
+		status, err := s.get()
+		s.Require().NoError(err)
+		s.Assert().Equal(http.StatusServiceUnavailable, status)
+	}
+
+	_, err := s.get()
+	s.Assert().ErrorIs(err, ErrOpen)
+	s.Assert().Equal(DefaultThreshold, s.requests, "open circuit should not call the API")
+
+	// Once the cooldown is over requests go through, a single failure opens the circuit again
+	s.now = s.now.Add(DefaultCooldown)
+	_, err = s.get()
+	s.Require().NoError(err)
+	_, err = s.get()
+	s.Assert().ErrorIs(err, ErrOpen)
+
+	// A success closes the circuit
+	s.now = s.now.Add(DefaultCooldown)
+	s.status = http.StatusOK
+	for range DefaultThreshold {
+		status, err := s.get()
+		s.Require().NoError(err)
+		s.Assert().Equal(http.StatusOK, status)
+	}
+	s.status = http.StatusInternalServerError
+	_, err = s.get()
+	s.Assert().NoError(err, "failures are counted again from zero")
+}
+
+func (s *BreakerTestSuite) TestHalfOpenLetsOneProbeThrough() {
+	s.status = http.StatusServiceUnavailable
+	for range DefaultThreshold {
+		_, err := s.get()
+		s.Require().NoError(err)
+	}
+
+	// The probe is held by the API, the concurrent requests fail without calling it
+	arrived, release := make(chan struct{}), make(chan struct{})
+	s.status = http.StatusOK
+	handler := s.server.Config.Handler
+	s.server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(arrived)
+		<-release
+		handler.ServeHTTP(w, r)
+	})
+	s.now = s.now.Add(DefaultCooldown)
+	probed := make(chan error)
+	go func() {
+		_, err := s.get()
+		probed <- err
+	}()
+	<-arrived
+	for range 3 {
+		_, err := s.get()
+		s.Assert().ErrorIs(err, ErrOpen)
+	}
+
+	close(release)
+	s.Require().NoError(<-probed)
+	s.server.Config.Handler = handler
+	status, err := s.get()
+	s.Require().NoError(err)
+	s.Assert().Equal(http.StatusOK, status, "a successful probe closes the circuit")
+	s.Assert().Equal(DefaultThreshold+2, s.requests)
+}
+
+func (s *BreakerTestSuite) TestClientErrorsDoNotCount() {
+	s.status = http.StatusNotFound
+	for range DefaultThreshold + 1 {
+		status, err := s.get()
+		s.Require().NoError(err)
+		s.Assert().Equal(http.StatusNotFound, status)
+	}
+}
+
+func (s *BreakerTestSuite) TestIgnoresCancelledRequests() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for range DefaultThreshold {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.server.URL, nil)
+		s.Require().NoError(err)
+		_, err = s.client.Do(req)
+		s.Require().ErrorIs(err, context.Canceled)
+	}
+
+	status, err := s.get()
+	s.Require().NoError(err)
+	s.Assert().Equal(http.StatusOK, status)
+}
@@ -0,0 +1,90 @@
+// Package metrics holds the Prometheus metrics of the tool and serves them over HTTP.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/rs/zerolog/log"
+)
+
+// StatusError is the status label of API calls that failed without an HTTP response
+const StatusError = "error"
+
+var (
+	// BackupDuration observes how long backups take, successful or not
+	BackupDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "gitbackup_backup_duration_seconds",
+		Help: "Duration of backups in seconds.",
+		// From a few seconds for a single workspace to over an hour for large tenants
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	})
+	// APICalls counts the calls of PlainID service methods
+	APICalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitbackup_api_calls_total",
+		Help: "Number of PlainID API calls by service method.",
+	}, []string{"method"})
+	// APIErrors counts the failed PlainID HTTP requests, by service method and HTTP status
+	APIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gitbackup_api_errors_total",
+		Help: "Number of failed PlainID API requests by service method and HTTP status.",
+	}, []string{"method", "status"})
+	// LastBackupTimestamp is the Unix time of the last successful backup
+	LastBackupTimestamp = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gitbackup_last_backup_timestamp",
+		Help: "Unix timestamp of the last successful backup.",
+	})
+)
+
+// shutdownTimeout is how long scrapes in progress are given to complete on shutdown
+const shutdownTimeout = 5 * time.Second
+
+// Server serves the metrics on /metrics until it is shut down
+type Server struct {
+	server   *http.Server
+	listener net.Listener
+}
+
+// Start listens on addr, host:port or :port, and serves the metrics in the background
+func Start(addr string) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for metrics on %s: %w", addr, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	s := &Server{
+		server:   &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		listener: listener,
+	}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Error().Err(err).Msg("Metrics server failed")
+		}
+	}()
+	log.Info().Msgf("Serving metrics on http://%s/metrics", listener.Addr())
+	return s, nil
+}
+
+// Addr returns the address the metrics are served on
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Shutdown stops the server, letting scrapes in progress complete
+func (s *Server) Shutdown() error {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to shut down metrics server: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer(t *testing.T) {
+	server, err := Start("127.0.0.1:0")
+	require.NoError(t, err)
+
+	APICalls.WithLabelValues("Environments").Inc()
+	APIErrors.WithLabelValues("Environments", "503").Inc()
+	BackupDuration.Observe(12)
+	LastBackupTimestamp.SetToCurrentTime()
+
+	resp, err := http.Get("http://" + server.Addr() + "/metrics")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, resp.Body.Close())
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, string(body), `gitbackup_api_calls_total{method="Environments"} 1`)
+	assert.Contains(t, string(body), `gitbackup_api_errors_total{method="Environments",status="503"} 1`)
+	assert.Contains(t, string(body), "gitbackup_backup_duration_seconds_count 1")
+	assert.Contains(t, string(body), "gitbackup_last_backup_timestamp ")
+
+	require.NoError(t, server.Shutdown())
+	_, err = http.Get("http://" + server.Addr() + "/metrics")
+	assert.Error(t, err, "the server should be shut down")
+
+	_, err = Start(server.Addr() + "0000")
+	assert.ErrorContains(t, err, "failed to listen for metrics")
+}
@@ -0,0 +1,58 @@
+// Package worker runs independent pieces of work concurrently with a bounded number of workers.
+package worker
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// WorkerPool runs a worker function over work items of type T, at most a fixed number at a time
+type WorkerPool[T any] struct {
+	workers int
+}
+
+// NewWorkerPool returns a pool running at most workers items at a time, at least one
+func NewWorkerPool[T any](workers int) *WorkerPool[T] {
+	return &WorkerPool[T]{workers: max(workers, 1)}
+}
+
+// Run calls fn for every item and waits for all of them. Items are dispatched in order, so a pool
+// of one worker processes them sequentially. The first error cancels the context passed to the
+// running workers, stops dispatching the remaining items and is returned.
+func (p *WorkerPool[T]) Run(ctx context.Context, items []T, fn func(ctx context.Context, item T) error) error {
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	g, groupCtx := errgroup.WithContext(runCtx)
+	sem := make(chan struct{}, p.workers)
+
+dispatch:
+	for _, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-groupCtx.Done():
+			break dispatch
+		}
+		// The context may have been cancelled while waiting for a worker to be free
+		if groupCtx.Err() != nil {
+			<-sem
+			break
+		}
+
+		g.Go(func() error {
+			err := fn(groupCtx, item)
+			// Cancel before freeing the worker, so no further item is dispatched after a failure
+			if err != nil {
+				cancel()
+			}
+			<-sem
+			return err
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return err
+	}
+	// Items left undispatched because the parent context was cancelled are a failure as well
+	return ctx.Err()
+}
@@ -0,0 +1,83 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+// WorkerPoolTestSuite tests the worker pool with work items that record how they were run
+type WorkerPoolTestSuite struct {
+	suite.Suite
+}
+
+func TestWorkerPoolSuite(t *testing.T) {
+	suite.Run(t, new(WorkerPoolTestSuite))
+}
+
+func (s *WorkerPoolTestSuite) TestSingleWorkerIsSequential() {
+	var order []int
+	err := NewWorkerPool[int](1).Run(context.Background(), []int{1, 2, 3, 4}, func(_ context.Context, item int) error {
+		order = append(order, item)
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Assert().Equal([]int{1, 2, 3, 4}, order)
+}
+
+func (s *WorkerPoolTestSuite) TestBoundsConcurrency() {
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var done []int
+
+	err := NewWorkerPool[int](3).Run(context.Background(), make([]int, 12), func(_ context.Context, item int) error {
+		n := running.Add(1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		running.Add(-1)
+
+		mu.Lock()
+		done = append(done, item)
+		mu.Unlock()
+		return nil
+	})
+	s.Require().NoError(err)
+	s.Assert().Len(done, 12)
+	s.Assert().LessOrEqual(peak.Load(), int32(3))
+}
+
+func (s *WorkerPoolTestSuite) TestFirstErrorStopsDispatching() {
+	failure := errors.New("failed")
+	var calls atomic.Int32
+
+	err := NewWorkerPool[int](1).Run(context.Background(), []int{1, 2, 3}, func(_ context.Context, item int) error {
+		calls.Add(1)
+		if item == 2 {
+			return failure
+		}
+		return nil
+	})
+	s.Require().ErrorIs(err, failure)
+	s.Assert().Equal(int32(2), calls.Load())
+}
+
+func (s *WorkerPoolTestSuite) TestCancelledContext() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := NewWorkerPool[int](2).Run(ctx, []int{1, 2}, func(context.Context, int) error {
+		s.Fail("no work should be dispatched")
+		return nil
+	})
+	s.Require().ErrorIs(err, context.Canceled)
+}
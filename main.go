@@ -9,7 +9,7 @@ import (
 )
 
 func main() {
-	// Configure zerolog
+	// Configure zerolog, text logs are written until --log-format and --log-level are applied
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 	cmd.Execute()
@@ -0,0 +1,46 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AttributeSchemas returns the custom attribute schemas policies of an environment can reference.
+// PlainID instances without the attribute schemas API (404) return an empty string.
+func (s Service) AttributeSchemas(ctx context.Context, envID string) (schemas string, err error) {
+	ctx, span := s.startSpan(ctx, "AttributeSchemas", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/1.0/attribute-schemas/%s", s.cfg.PlainID.BaseURL, envID)
+
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), "GET", baseURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Warn().Msgf("Attribute schemas API not available for environment %s, skipping attribute schemas", envID)
+		return "", nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download attribute schemas for %s: %s %s", envID, responseStatus(resp), body)
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,44 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// AuditLogs exports the authorization decision logs of an environment between since and until
+// as returned by the PlainID audit API.
+func (s Service) AuditLogs(ctx context.Context, envID string, since, until time.Time) (auditLogs string, err error) {
+	ctx, span := s.startSpan(ctx, "AuditLogs", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/1.0/audit-logs/%s?from=%s&to=%s", s.cfg.PlainID.BaseURL, envID,
+		url.QueryEscape(since.UTC().Format(time.RFC3339)), url.QueryEscape(until.UTC().Format(time.RFC3339)))
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download audit logs for %s: %s %s", envID, responseStatus(resp), body)
+	}
+
+	return string(body), nil
+}
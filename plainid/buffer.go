@@ -0,0 +1,33 @@
+package plainid
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// bufferPool holds reusable buffers for reading API response bodies.
+// A backup issues thousands of calls, so reusing the growing buffer avoids
+// most of the allocations io.ReadAll makes for every response.
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// readBody reads the whole body into a pooled buffer and returns a copy of its content
+func readBody(r io.Reader) ([]byte, error) {
+	buf, ok := bufferPool.Get().(*bytes.Buffer)
+	if !ok {
+		buf = new(bytes.Buffer)
+	}
+	defer bufferPool.Put(buf)
+
+	buf.Reset()
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+
+	// The buffer is reused, so the caller must get its own copy
+	return bytes.Clone(buf.Bytes()), nil
+}
@@ -0,0 +1,42 @@
+package plainid
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var benchmarkBody = bytes.Repeat([]byte(`{"id":"policy","name":"Policy name","state":"Active"},`), 2000)
+
+func BenchmarkIOReadAll(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := io.ReadAll(bytes.NewReader(benchmarkBody)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReadBody(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := readBody(bytes.NewReader(benchmarkBody)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestReadBody(t *testing.T) {
+	first, err := readBody(bytes.NewReader([]byte("first body")))
+	require.NoError(t, err)
+
+	second, err := readBody(bytes.NewReader([]byte("second")))
+	require.NoError(t, err)
+
+	// Content must not leak between reads sharing a pooled buffer
+	assert.Equal(t, "first body", string(first))
+	assert.Equal(t, "second", string(second))
+}
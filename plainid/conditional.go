@@ -0,0 +1,150 @@
+package plainid
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ConditionalCache keeps the validators and bodies of PlainID responses from the previous backup,
+// so unchanged resources are not downloaded again
+type ConditionalCache struct {
+	mu sync.Mutex
+	// previous holds the responses loaded from the cache file, entries those of the current backup.
+	// Only entries are saved, so resources that are no longer backed up drop out of the cache.
+	previous map[string]CacheEntry
+	entries  map[string]CacheEntry
+}
+
+// CacheEntry is a cached response of a GET request
+type CacheEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// NewConditionalCache returns an empty cache
+func NewConditionalCache() *ConditionalCache {
+	return &ConditionalCache{previous: make(map[string]CacheEntry), entries: make(map[string]CacheEntry)}
+}
+
+// WithConditionalCache sends conditional requests based on the responses in the cache
+func WithConditionalCache(cache *ConditionalCache) Option {
+	return func(s *Service) {
+		s.cache = cache
+	}
+}
+
+// Load replaces the cache content with the cache file at path. A missing file leaves the cache empty.
+func (c *ConditionalCache) Load(path string) error {
+	previous := make(map[string]CacheEntry)
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("failed to read cache file: %w", err)
+	default:
+		if err := json.Unmarshal(data, &previous); err != nil {
+			return fmt.Errorf("failed to parse cache file: %w", err)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.previous = previous
+	c.entries = make(map[string]CacheEntry)
+	return nil
+}
+
+// Save writes the responses of the current backup to the cache file at path
+func (c *ConditionalCache) Save(path string) error {
+	c.mu.Lock()
+	data, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to marshal cache: %w", err)
+	}
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}
+
+func (c *ConditionalCache) get(url string) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.previous[url]
+	return entry, ok
+}
+
+func (c *ConditionalCache) set(url string, entry CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[url] = entry
+}
+
+// conditionalTransport sends If-None-Match and If-Modified-Since for cached GET requests
+// and answers 304 Not Modified responses from the cache, transparently for the callers
+type conditionalTransport struct {
+	base  http.RoundTripper
+	cache *ConditionalCache
+}
+
+func (t *conditionalTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base.RoundTrip(req)
+	}
+
+	url := req.URL.String()
+	entry, cached := t.cache.get(url)
+	if cached {
+		req = req.Clone(req.Context())
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached:
+		log.Info().Str("url", url).Msg("Resource unchanged, using cached version")
+		t.cache.set(url, entry)
+		resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.ContentLength = int64(len(entry.Body))
+		resp.Header.Set("Content-Length", strconv.Itoa(len(entry.Body)))
+		resp.Body = io.NopCloser(bytes.NewReader(entry.Body))
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		body, err := readBody(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		t.cache.set(url, CacheEntry{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Body:         body,
+		})
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	return resp, nil
+}
@@ -0,0 +1,132 @@
+package plainid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/semver"
+)
+
+// MinAPIVersion is the oldest PlainID API version the tool is known to work with
+const MinAPIVersion = "1.0.0"
+
+// ConnectionCheck is the outcome of a single pre-flight check
+type ConnectionCheck struct {
+	Name     string
+	OK       bool
+	Message  string
+	Duration time.Duration
+}
+
+// ConnectionTestResult holds the pre-flight checks in the order they were run,
+// checks after a failed one are not run
+type ConnectionTestResult struct {
+	Checks []ConnectionCheck
+}
+
+// OK checks if all checks passed
+func (r ConnectionTestResult) OK() bool {
+	for _, check := range r.Checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// TestConnection validates that an OAuth2 token can be fetched, that the API can be called with it
+// and that the PlainID API version is supported. The error is the one of the first failed check.
+func (s Service) TestConnection(ctx context.Context) (result ConnectionTestResult, err error) {
+	ctx, span := s.startSpan(ctx, "TestConnection")
+	defer func() { endSpan(span, err) }()
+
+	run := func(name string, check func() (string, error)) error {
+		start := time.Now()
+		message, err := check()
+		result.Checks = append(result.Checks, ConnectionCheck{
+			Name:     name,
+			OK:       err == nil,
+			Message:  message,
+			Duration: time.Since(start),
+		})
+		if err != nil {
+			result.Checks[len(result.Checks)-1].Message = err.Error()
+			return fmt.Errorf("%s check failed: %w", name, err)
+		}
+		return nil
+	}
+
+	err = run("token", func() (string, error) {
+		if _, err := s.tokenSource.Token(); err != nil {
+			return "", fmt.Errorf("failed to fetch OAuth2 token from %s: %w", s.cfg.PlainID.OAuth2TokenURL(), err)
+		}
+		return "OAuth2 token fetched", nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = run("api", func() (string, error) {
+		envs, err := s.Environments(ctx)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d environments accessible", len(envs)), nil
+	})
+	if err != nil {
+		return result, err
+	}
+
+	err = run("version", func() (string, error) {
+		return s.checkAPIVersion(ctx)
+	})
+	return result, err
+}
+
+// checkAPIVersion checks the PlainID API version is at least MinAPIVersion.
+// PlainID instances without the version endpoint (404) pass the check.
+func (s Service) checkAPIVersion(ctx context.Context) (string, error) {
+	baseURL := fmt.Sprintf("%s/api/1.0/version", s.cfg.PlainID.BaseURL)
+
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), "GET", baseURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "API version endpoint not available, version not checked", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get API version: %s %s", responseStatus(resp), body)
+	}
+
+	var versionResp struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(body, &versionResp); err != nil {
+		return "", fmt.Errorf("failed to parse API version response: %w", err)
+	}
+
+	version := "v" + strings.TrimPrefix(versionResp.Version, "v")
+	if !semver.IsValid(version) {
+		return "", fmt.Errorf("invalid API version %q", versionResp.Version)
+	}
+	if semver.Compare(version, "v"+MinAPIVersion) < 0 {
+		return "", fmt.Errorf("API version %s is older than the supported %s", versionResp.Version, MinAPIVersion)
+	}
+	return "API version " + versionResp.Version, nil
+}
@@ -0,0 +1,46 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// EnvironmentSettings returns the global settings of an environment, such as its enforcement mode and logging level.
+// PlainID instances without the environment settings API (404) return an empty string.
+func (s Service) EnvironmentSettings(ctx context.Context, envID string) (settings string, err error) {
+	ctx, span := s.startSpan(ctx, "EnvironmentSettings", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/env-mgmt/environment/%s/settings", s.cfg.PlainID.BaseURL, envID)
+
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), "GET", baseURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Warn().Msgf("Attribute schemas API not available for environment %s, skipping environment settings", envID)
+		return "", nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download environment settings for %s: %s %s", envID, responseStatus(resp), body)
+	}
+
+	return string(body), nil
+}
@@ -0,0 +1,25 @@
+package plainid
+
+// EnvironmentExport is the in-memory representation of the backup of an environment
+type EnvironmentExport struct {
+	// IdentityTemplates maps identity template IDs to their JSON
+	IdentityTemplates map[string]string
+	PAAGroups         []PAAGroup
+	// Workspaces maps workspace IDs to their export
+	Workspaces map[string]WorkspaceExport
+}
+
+// WorkspaceExport is the in-memory representation of the backup of a workspace
+type WorkspaceExport struct {
+	Applications []ApplicationExport
+	// AssetTemplates maps asset template IDs to their JSON
+	AssetTemplates map[string]string
+	Groups         []Group
+}
+
+// ApplicationExport is an application along with its policies and API mapper set
+type ApplicationExport struct {
+	Application
+	Policies     []PolicyContent
+	APIMapperSet string
+}
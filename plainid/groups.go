@@ -0,0 +1,93 @@
+package plainid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Group is a PlainID authorization group (role) of a workspace
+type Group struct {
+	ID          string         `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	WSID        string         `json:"authWsId"`
+	Properties  map[string]any `json:"properties,omitempty"`
+}
+
+func (g Group) AsJSON() (string, error) {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal group to JSON: %w", err)
+	}
+	return string(b), nil
+}
+
+// Groups returns the authorization groups of a workspace.
+// PlainID instances without the groups API (404) are treated as having no groups.
+func (s Service) Groups(ctx context.Context, envID, wsID string) (groups []Group, err error) {
+	ctx, span := s.startSpan(ctx, "Groups", attribute.String("env_id", envID), attribute.String("ws_id", wsID))
+	defer func() { endSpan(span, err) }()
+
+	type GroupsResponse struct {
+		Data []Group `json:"data"`
+		Meta Meta    `json:"meta"`
+	}
+
+	limit := 50
+	offset := 0
+	groups = make([]Group, 0)
+
+	for {
+		baseURL := fmt.Sprintf("%s/policy-mgmt/1.0/groups/%s?%s=%s&limit=%d&offset=%d", s.cfg.PlainID.BaseURL, envID,
+			url.QueryEscape("filter[authWsId]"), wsID, limit, offset)
+
+		req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), "GET", baseURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := readBody(resp.Body)
+		resp.Body.Close()
+
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.StatusCode == http.StatusNotFound {
+			log.Warn().Msgf("Groups API not available for environment %s, skipping groups", envID)
+			return groups, nil
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("failed to download groups for %s: %s %s", wsID, responseStatus(resp), body)
+		}
+
+		var groupsResp GroupsResponse
+		err = json.Unmarshal(body, &groupsResp)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse groups response: %w", err)
+		}
+
+		groups = append(groups, groupsResp.Data...)
+
+		// Check if we've retrieved all groups
+		if len(groupsResp.Data) < limit || offset+len(groupsResp.Data) >= groupsResp.Meta.Total {
+			break
+		}
+		// Move to the next page
+		offset += limit
+	}
+
+	return groups, nil
+}
@@ -0,0 +1,44 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImportPolicy imports a policy given as Rego into an application of a workspace, the counterpart of
+// AppPolicies. PlainID creates the policy, or updates the one with the ID declared in the Rego.
+func (s Service) ImportPolicy(ctx context.Context, envID, wsID, appID, policyContent string) (err error) {
+	ctx, span := s.startSpan(ctx, "ImportPolicy", attribute.String("env_id", envID), attribute.String("ws_id", wsID), attribute.String("app_id", appID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/2.0/policies/%s?authWsId=%s&appId=%s", s.cfg.PlainID.BaseURL, envID,
+		url.QueryEscape(wsID), url.QueryEscape(appID))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, baseURL, strings.NewReader(policyContent))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain;language=rego")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to import policy for %s: %s %s", appID, responseStatus(resp), body)
+	}
+	return nil
+}
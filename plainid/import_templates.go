@@ -0,0 +1,37 @@
+package plainid
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImportAssetTemplate creates or updates an asset template of an environment from its JSON, the
+// counterpart of AssetTemplate
+func (s Service) ImportAssetTemplate(ctx context.Context, envID, assetTemplateID, assetTemplate string) (err error) {
+	ctx, span := s.startSpan(ctx, "ImportAssetTemplate", attribute.String("env_id", envID), attribute.String("asset_template_id", assetTemplateID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/1.0/asset-templates/%s/%s", s.cfg.PlainID.BaseURL, envID, url.PathEscape(assetTemplateID))
+	if _, err := s.sendJSON(ctx, http.MethodPut, baseURL, json.RawMessage(assetTemplate)); err != nil {
+		return fmt.Errorf("failed to import asset template %s: %w", assetTemplateID, err)
+	}
+	return nil
+}
+
+// ImportIdentityTemplate creates or updates an identity template of an environment from its JSON,
+// the counterpart of IdentityTemplates
+func (s Service) ImportIdentityTemplate(ctx context.Context, envID, identityID, identityTemplate string) (err error) {
+	ctx, span := s.startSpan(ctx, "ImportIdentityTemplate", attribute.String("env_id", envID), attribute.String("identity_id", identityID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/1.0/identity-templates/%s/%s", s.cfg.PlainID.BaseURL, envID, url.PathEscape(identityID))
+	if _, err := s.sendJSON(ctx, http.MethodPut, baseURL, json.RawMessage(identityTemplate)); err != nil {
+		return fmt.Errorf("failed to import identity template %s: %w", identityID, err)
+	}
+	return nil
+}
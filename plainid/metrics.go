@@ -0,0 +1,46 @@
+package plainid
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/plainid/git-backup/internal/metrics"
+)
+
+// apiMethodKey carries the name of the Service method making a request in its context
+type apiMethodKey struct{}
+
+// apiMethod returns the Service method that made the request of ctx
+func apiMethod(ctx context.Context) string {
+	if method, ok := ctx.Value(apiMethodKey{}).(string); ok {
+		return method
+	}
+	return "unknown"
+}
+
+// expectedStatusKey carries the error status that the request of its context may answer with
+type expectedStatusKey struct{}
+
+// expectStatus marks status as an expected answer of the request of ctx rather than a failure, such
+// as 404 for resources that do not exist on every PlainID instance
+func expectStatus(ctx context.Context, status int) context.Context {
+	return context.WithValue(ctx, expectedStatusKey{}, status)
+}
+
+// metricsTransport counts the failed PlainID requests by Service method and HTTP status, statuses
+// expected by the caller are not failures
+type metricsTransport struct {
+	base http.RoundTripper
+}
+
+func (t *metricsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	switch {
+	case err != nil:
+		metrics.APIErrors.WithLabelValues(apiMethod(req.Context()), metrics.StatusError).Inc()
+	case resp.StatusCode >= http.StatusBadRequest && req.Context().Value(expectedStatusKey{}) != resp.StatusCode:
+		metrics.APIErrors.WithLabelValues(apiMethod(req.Context()), strconv.Itoa(resp.StatusCode)).Inc()
+	}
+	return resp, err
+}
@@ -0,0 +1,64 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/plainid/git-backup/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// page is a page of a paginated PlainID endpoint. Depending on the API the total is
+// given at the top level or in meta.
+type page[T any] struct {
+	Data  []T  `json:"data"`
+	Meta  Meta `json:"meta"`
+	Total int  `json:"total"`
+}
+
+// total returns the total number of items of the paginated endpoint
+func (p page[T]) total() int {
+	if p.Meta.Total > 0 {
+		return p.Meta.Total
+	}
+	return p.Total
+}
+
+// Paginate fetches all items of a paginated endpoint, limit items at a time, every page within an
+// HTTP span that is a child of ctx and retried according to retry. baseURL must not carry the limit
+// and offset parameters, they are added for every page. The result is never nil.
+func Paginate[T any](ctx context.Context, client *http.Client, tracer trace.Tracer, retry config.RetryConfig, baseURL string, limit int) ([]T, error) {
+	pageURL, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URL %s: %w", baseURL, err)
+	}
+
+	caller := NewAppCaller[page[T]](client, tracer, retry)
+	items := make([]T, 0)
+	offset := 0
+
+	for {
+		query := pageURL.Query()
+		query.Set("limit", strconv.Itoa(limit))
+		query.Set("offset", strconv.Itoa(offset))
+		pageURL.RawQuery = query.Encode()
+
+		resp, err := caller.Call(ctx, pageURL.String())
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, resp.Data...)
+
+		// Check if we've retrieved all items
+		if len(resp.Data) < limit || offset+len(resp.Data) >= resp.total() {
+			break
+		}
+		// Move to the next page
+		offset += limit
+	}
+
+	return items, nil
+}
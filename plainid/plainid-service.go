@@ -1,15 +1,20 @@
 package plainid
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
+	"time"
 
 	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/internal/circuitbreaker"
 	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/clientcredentials"
 )
 
@@ -20,12 +25,31 @@ type Policy struct {
 	AccessType string `json:"accessType"`
 }
 
+// PolicyFormatRego is the format of policies exported as Rego
+const PolicyFormatRego = "rego"
+
+// PolicyContent is an exported policy along with the policy it was exported from
+type PolicyContent struct {
+	ID      string
+	Name    string
+	Content string
+	Format  string
+}
+
 type Meta struct {
 	Total  int `json:"total"`
 	Limit  int `json:"limit"`
 	Offset int `json:"offset"`
 }
 
+// nonNil returns an empty slice instead of nil, so a missing or null "data" array reads like an empty one
+func nonNil[T any](items []T) []T {
+	if items == nil {
+		return make([]T, 0)
+	}
+	return items
+}
+
 type PolicyResponse struct {
 	Data []Policy `json:"data"`
 	Meta Meta     `json:"meta"`
@@ -47,8 +71,19 @@ type Environment struct {
 }
 
 type Workspace struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Type        string `json:"type"`
+	OwnerID     string `json:"ownerId"`
+}
+
+func (w Workspace) AsJSON() (string, error) {
+	b, err := json.Marshal(w)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal workspace to JSON: %w", err)
+	}
+	return string(b), nil
 }
 
 type Identity struct {
@@ -57,236 +92,173 @@ type Identity struct {
 	TemplateID string `json:"identityTemplateId"`
 }
 
+// AsJSON returns the application as indented JSON
 func (s Application) AsJSON() (string, error) {
-	b, err := json.Marshal(s)
+	b, err := json.MarshalIndent(s, "", jsonIndent)
 	if err != nil {
 		return "", err
 	}
 	return string(b), nil
 }
 
+// AsPrettyJSON is AsJSON, which is indented for readable diffs
+func (s Application) AsPrettyJSON() (string, error) {
+	return s.AsJSON()
+}
+
 type Service struct {
 	cfg    config.Config
 	client *http.Client
+	tracer trace.Tracer
+	// userAgent is sent with every API call, Go's default is used when empty
+	userAgent string
+	// requestIDPrefix is prepended to the request ID of every API call
+	requestIDPrefix string
+	// cache makes API calls conditional on the responses of the previous backup, nil disables it
+	cache *ConditionalCache
+	// tokenSource provides the OAuth2 access tokens of client
+	tokenSource oauth2.TokenSource
 }
 
-func NewService(cfg config.Config) *Service {
-	oauth2Config := clientcredentials.Config{
-		ClientID:     cfg.PlainID.ClientID,
-		ClientSecret: cfg.PlainID.ClientSecret,
-		TokenURL:     fmt.Sprintf("%s/api/1.0/api-key/token", cfg.PlainID.BaseURL),
-	}
-
-	client := oauth2Config.Client(context.Background())
-
-	return &Service{
+func NewService(cfg config.Config, opts ...Option) *Service {
+	s := &Service{
 		cfg:    cfg,
-		client: client,
+		tracer: defaultTracer(),
 	}
-}
-
-func (s Service) Environments() ([]Environment, error) {
-	baseURL := fmt.Sprintf("%s/env-mgmt/environment", s.cfg.PlainID.BaseURL)
-	log.Debug().Msgf("Fetching environments from PlainID %s...", baseURL)
-
-	req, err := http.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
+	for _, opt := range opts {
+		opt(s)
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get environments for whildcard:  %s %s", resp.Status, body)
+	oauth2Config := clientcredentials.Config{
+		ClientID:     cfg.PlainID.ClientID,
+		ClientSecret: cfg.PlainID.ClientSecret,
+		TokenURL:     cfg.PlainID.OAuth2TokenURL(),
 	}
 
-	type EnvsResponse struct {
-		Data []Environment `json:"data"`
-		Meta Meta          `json:"meta"`
+	// The token refresh transport sees the requests after the OAuth2 transport has authorized them,
+	// the circuit breaker fails them fast once PlainID appears to be down. Token requests go through
+	// the base client as well, so the rate limit applies to them too.
+	timeout := time.Duration(cmp.Or(cfg.PlainID.HTTPTimeoutSeconds, config.DefaultHTTPTimeoutSeconds)) * time.Second
+	baseClient := &http.Client{
+		Transport: &tokenRefreshTransport{base: circuitbreaker.New(newRateLimitTransport(newTransport(cfg.PlainID), cfg.PlainID.RateLimitRPS))},
+		Timeout:   timeout,
 	}
-
-	var envsResp EnvsResponse
-	err = json.Unmarshal(body, &envsResp)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse environments response: %w", err)
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, baseClient)
+	s.tokenSource = oauth2Config.TokenSource(ctx)
+	client := oauth2.NewClient(ctx, s.tokenSource)
+	if s.cache != nil {
+		client.Transport = &conditionalTransport{base: client.Transport, cache: s.cache}
 	}
+	client.Transport = &requestIDTransport{base: &metricsTransport{base: client.Transport}, userAgent: s.userAgent, prefix: s.requestIDPrefix}
+	client.Timeout = timeout
 
-	return envsResp.Data, nil
+	s.client = client
+	return s
 }
 
-func (s Service) Workspaces(envID string) ([]Workspace, error) {
-	baseURL := fmt.Sprintf("%s/env-mgmt/1.0-int.1/authorization-workspaces/%s?offset=0&limit=100", s.cfg.PlainID.BaseURL, envID)
-	log.Info().Msgf("Fetching workspaces for environment %s from PlainID %s...", envID, baseURL)
-
-	req, err := http.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+// idleConnTimeout is how long an idle connection to PlainID is kept open
+const idleConnTimeout = 90 * time.Second
+
+// newTransport returns the transport of PlainID requests, keeping up to the configured number of
+// idle connections open. All requests go to the same host, so the limit applies per host as well.
+func newTransport(cfg config.PlainIDConfig) *http.Transport {
+	maxIdleConns := cmp.Or(cfg.MaxIdleConns, config.DefaultMaxIdleConns)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = maxIdleConns
+	transport.MaxIdleConnsPerHost = maxIdleConns
+	transport.IdleConnTimeout = idleConnTimeout
+	return transport
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get environments for whildcard:  %s %s", resp.Status, body)
-	}
+func (s Service) Environments(ctx context.Context) (envs []Environment, err error) {
+	ctx, span := s.startSpan(ctx, "Environments")
+	defer func() { endSpan(span, err) }()
 
-	type WSsResponse struct {
-		Data []Workspace `json:"data"`
-		Meta Meta        `json:"meta"`
-	}
+	baseURL := fmt.Sprintf("%s/env-mgmt/environment", s.cfg.PlainID.BaseURL)
+	log.Debug().Msgf("Fetching environments from PlainID %s...", baseURL)
 
-	var wssResp WSsResponse
-	err = json.Unmarshal(body, &wssResp)
+	envs, err = Paginate[Environment](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 100)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse workspaces response: %w", err)
+		return nil, fmt.Errorf("failed to get environments for whildcard: %w", err)
 	}
-
-	return wssResp.Data, nil
+	return envs, nil
 }
 
-func (s Service) Identities(envID string) ([]Identity, error) {
-	baseURL := fmt.Sprintf("%s/env-mgmt/1.0/identity-workspaces/%s?offset=0&limit=100", s.cfg.PlainID.BaseURL, envID)
-
-	req, err := http.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return nil, err
-	}
+func (s Service) Workspaces(ctx context.Context, envID string) (wss []Workspace, err error) {
+	ctx, span := s.startSpan(ctx, "Workspaces", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
+	baseURL := fmt.Sprintf("%s/env-mgmt/1.0-int.1/authorization-workspaces/%s", s.cfg.PlainID.BaseURL, envID)
+	log.Info().Msgf("Fetching workspaces for environment %s from PlainID %s...", envID, baseURL)
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	wss, err = Paginate[Workspace](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 100)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to get workspaces for environment %s: %w", envID, err)
 	}
+	return wss, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to get environments for whildcard:  %s %s", resp.Status, body)
-	}
+func (s Service) Identities(ctx context.Context, envID string) (identities []Identity, err error) {
+	ctx, span := s.startSpan(ctx, "Identities", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
 
-	type IdentitiesResponse struct {
-		Data []Identity `json:"data"`
-	}
+	baseURL := fmt.Sprintf("%s/env-mgmt/1.0/identity-workspaces/%s", s.cfg.PlainID.BaseURL, envID)
 
-	var identitiesResp IdentitiesResponse
-	err = json.Unmarshal(body, &identitiesResp)
+	identities, err = Paginate[Identity](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 100)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse workspaces response: %w", err)
+		return nil, fmt.Errorf("failed to get identities for environment %s: %w", envID, err)
 	}
-
-	return identitiesResp.Data, nil
+	return identities, nil
 }
 
-func (s Service) Applications(envID, wsID string) ([]Application, error) {
+func (s Service) Applications(ctx context.Context, envID, wsID string) (apps []Application, err error) {
+	ctx, span := s.startSpan(ctx, "Applications", attribute.String("env_id", envID), attribute.String("ws_id", wsID))
+	defer func() { endSpan(span, err) }()
+
 	type AppInfo struct {
 		ID   string `json:"id"`
 		Name string `json:"name"`
 		WSID string `json:"authWsId"`
 	}
 
-	type AppInfoResponse struct {
-		Data   []AppInfo `json:"data"`
-		Total  int       `json:"total"`
-		Limit  int       `json:"limit"`
-		Offset int       `json:"offset"`
+	baseURL := fmt.Sprintf("%s/policy-mgmt/1.0/applications/%s?detailed=true", s.cfg.PlainID.BaseURL, envID)
+	allAppInfos, err := Paginate[AppInfo](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 50)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download apps for %s: %w", wsID, err)
 	}
 
-	limit := 50
-	offset := 0
+	// Keep apps only from specific workspace. The wildcard workspace is never the workspace ID
+	// of an application, all applications of the environment are kept for it.
 	var appInfos []AppInfo
-
-	for {
-		uRL := fmt.Sprintf("%s/policy-mgmt/1.0/applications/%s?detailed=true&limit=%d&offset=%d",
-			s.cfg.PlainID.BaseURL,
-			envID,
-			limit,
-			offset)
-
-		req, err := http.NewRequest("GET", uRL, nil)
-		if err != nil {
-			return nil, err
+	for _, app := range allAppInfos {
+		if wsID == "*" || app.WSID == wsID {
+			appInfos = append(appInfos, app)
 		}
-
-		resp, err := s.client.Do(req)
-		if err != nil {
-			return nil, err
-		}
-
-		body, err := io.ReadAll(resp.Body)
-		resp.Body.Close()
-
-		if err != nil {
-			return nil, err
-		}
-
-		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to download apps for %s: %s %s", wsID, resp.Status, body)
-		}
-
-		var appResp AppInfoResponse
-		err = json.Unmarshal(body, &appResp)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse applications response: %w", err)
-		}
-
-		// Append apps only from specific workspace
-		for _, app := range appResp.Data {
-			if app.WSID == wsID {
-				appInfos = append(appInfos, app)
-			}
-		}
-
-		// Check if we've retrieved all apps
-		if len(appResp.Data) < limit || offset+len(appResp.Data) >= appResp.Total {
-			break
-		}
-		// Move to the next page
-		offset += limit
 	}
 
 	// export applications
-	apps := make([]Application, 0, len(appInfos))
+	apps = make([]Application, 0, len(appInfos))
 	for _, appInfo := range appInfos {
 		baseURL := fmt.Sprintf("%s/api/1.0/applications/%s/%s", s.cfg.PlainID.BaseURL, envID, appInfo.ID)
 
-		req, err := http.NewRequest("GET", baseURL, nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
 		if err != nil {
 			return nil, err
 		}
 
-		resp, err := s.client.Do(req)
+		resp, err := doWithRetry(s.client, s.cfg.Retry, req)
 		if err != nil {
 			return nil, err
 		}
 
 		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
+		body, err := readBody(resp.Body)
 		if err != nil {
 			return nil, err
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to download application for %s: %s %s", appInfo.ID, resp.Status, body)
+			return nil, fmt.Errorf("failed to download application for %s: %s %s", appInfo.ID, responseStatus(resp), body)
 		}
 
 		type AppResponse struct {
@@ -301,6 +273,9 @@ func (s Service) Applications(envID, wsID string) ([]Application, error) {
 
 		app := appResponse.Data
 		app.WSID = appInfo.WSID
+		if err := app.Validate(); err != nil {
+			log.Warn().Err(err).Str("wsId", wsID).Msg("Application failed validation")
+		}
 		apps = append(apps, app)
 	}
 
@@ -308,221 +283,221 @@ func (s Service) Applications(envID, wsID string) ([]Application, error) {
 }
 
 // returns App policies
-func (s Service) AppPolicies(envID, wsID, appID string) ([]string, error) {
-	//todo at the moment we support up to 1000 policies per app which should be enough
+func (s Service) AppPolicies(ctx context.Context, envID, wsID, appID string) (policies []PolicyContent, err error) {
+	ctx, span := s.startSpan(ctx, "AppPolicies", attribute.String("env_id", envID), attribute.String("ws_id", wsID), attribute.String("app_id", appID))
+	defer func() { endSpan(span, err) }()
+
+	// The policy list is paginated, the name of each policy is kept from it for the policy content
 	baseURL := fmt.Sprintf("%s/policy-mgmt/1.0/policies/%s?%s=%s", s.cfg.PlainID.BaseURL, envID,
 		url.QueryEscape("filter[appId]"), appID)
-
-	req, err := http.NewRequest("GET", baseURL, nil)
+	pols, err := Paginate[Policy](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 1000)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("failed to download policies for %s: %w", appID, err)
 	}
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download policies for %s: %s %s", appID, resp.Status, body)
+	var activePolicies []Policy
+	for _, pol := range pols {
+		if pol.State != "Inactive" {
+			activePolicies = append(activePolicies, pol)
+		}
 	}
 
-	var pols PolicyResponse
-	err = json.Unmarshal(body, &pols)
-
 	// retrieve policies now
-	policies := make([]string, 0)
-	for _, pol := range pols.Data {
-		if pol.State == "Inactive" {
-			continue
-		}
-		baseURL = fmt.Sprintf("%s/api/2.0/policies/%s?%s=%s&%s=%s&extendedSchema=true", s.cfg.PlainID.BaseURL, envID,
+	policies = make([]PolicyContent, 0, len(activePolicies))
+	for _, pol := range activePolicies {
+		baseURL := fmt.Sprintf("%s/api/2.0/policies/%s?%s=%s&%s=%s&extendedSchema=true", s.cfg.PlainID.BaseURL, envID,
 			url.QueryEscape("filter[authWsId]"), wsID, url.QueryEscape("filter[id]"), pol.ID)
-		req, err = http.NewRequest("GET", baseURL, nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
 		if err != nil {
-			return nil, fmt.Errorf("failed to download policy for %s: %w", err)
+			return nil, fmt.Errorf("failed to download policy for %s in workspace %s: %w", pol.ID, wsID, err)
 		}
 		req.Header.Set("Accept", "text/plain;language=rego")
 
-		resp, err := s.client.Do(req)
+		resp, err := doWithRetry(s.client, s.cfg.Retry, req)
 		if err != nil {
 			return nil, err
 		}
 
 		defer resp.Body.Close()
-		body, err := io.ReadAll(resp.Body)
+		body, err := readBody(resp.Body)
 		if err != nil {
 			return nil, err
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return nil, fmt.Errorf("failed to download policy for %s: %s %s", wsID, resp.Status, body)
+			return nil, fmt.Errorf("failed to download policy for %s: %s %s", wsID, responseStatus(resp), body)
 		}
 
-		policies = append(policies, string(body))
+		policies = append(policies, PolicyContent{
+			ID:      pol.ID,
+			Name:    pol.Name,
+			Content: string(body),
+			Format:  PolicyFormatRego,
+		})
 	}
-	return policies, err
+	return policies, nil
 }
 
-func (s Service) AppAPIMapper(envID, appID string) (string, error) {
+// AppAPIMapper returns the API mapper set of an application, an empty string if it has none
+func (s Service) AppAPIMapper(ctx context.Context, envID, appID string) (mapper string, err error) {
+	ctx, span := s.startSpan(ctx, "AppAPIMapper", attribute.String("env_id", envID), attribute.String("app_id", appID))
+	defer func() { endSpan(span, err) }()
+
 	baseURL := fmt.Sprintf("%s/api/1.0/api-mapper-sets/%s/%s", s.cfg.PlainID.BaseURL, envID, appID)
 
-	req, err := http.NewRequest("GET", baseURL, nil)
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), http.MethodGet, baseURL, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := s.client.Do(req)
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		return "", err
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download api mapper for %s: %s %s", appID, resp.Status, body)
+	// Applications without an API mapper are not an error
+	if resp.StatusCode == http.StatusNotFound {
+		log.Debug().Msgf("No API mapper found for application %s, skipping", appID)
+		return "", nil
 	}
 
-	return string(body), nil
-}
-
-func (s Service) AssetTemplateIDs(wsID string) ([]string, error) {
-	baseURL := fmt.Sprintf("%s/internal-assets/4.0/asset-types?offset=0&limit=50&%s=%s", s.cfg.PlainID.BaseURL, url.QueryEscape("filter[ownerId]"), wsID)
-
-	req, err := http.NewRequest("GET", baseURL, nil)
-	if err != nil {
-		return nil, err
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download api mapper for %s: %s %s", appID, responseStatus(resp), body)
 	}
 
-	resp, err := s.client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	return prettyJSON(string(body)), nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to fetch asset templates for %s: %s %s", wsID, resp.Status, body)
-	}
+func (s Service) AssetTemplateIDs(ctx context.Context, wsID string) (assetTemplateIDs []string, err error) {
+	ctx, span := s.startSpan(ctx, "AssetTemplateIDs", attribute.String("ws_id", wsID))
+	defer func() { endSpan(span, err) }()
 
-	type AssetTemplatesResp struct {
-		Data []struct {
-			ID          string `json:"id"`
-			ExtID       string `json:"externalId"`
-			Name        string `json:"name"`
-			Description string `json:"description"`
-			OwnerID     string `json:"ownerId"`
-		}
+	// The asset types are paginated like the applications, the total is given in meta
+	type assetType struct {
+		ID          string `json:"id"`
+		ExtID       string `json:"externalId"`
+		Name        string `json:"name"`
+		Description string `json:"description"`
+		OwnerID     string `json:"ownerId"`
 	}
 
-	var appResponse AssetTemplatesResp
-	err = json.Unmarshal(body, &appResponse)
+	baseURL := fmt.Sprintf("%s/internal-assets/4.0/asset-types?%s=%s", s.cfg.PlainID.BaseURL, url.QueryEscape("filter[ownerId]"), wsID)
+	assetTypes, err := Paginate[assetType](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 50)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse applications response: %w", err)
+		return nil, fmt.Errorf("failed to fetch asset templates for %s: %w", wsID, err)
 	}
 
-	var assetTemplateIDs []string
-	for _, app := range appResponse.Data {
-		assetTemplateIDs = append(assetTemplateIDs, app.ExtID)
+	assetTemplateIDs = make([]string, 0, len(assetTypes))
+	for _, assetType := range assetTypes {
+		assetTemplateIDs = append(assetTemplateIDs, assetType.ExtID)
 	}
 	return assetTemplateIDs, nil
 }
 
-func (s Service) AssetTemplate(envID, assetTemplateID string) (string, error) {
+func (s Service) AssetTemplate(ctx context.Context, envID, assetTemplateID string) (assetTemplate string, err error) {
+	ctx, span := s.startSpan(ctx, "AssetTemplate", attribute.String("env_id", envID), attribute.String("asset_template_id", assetTemplateID))
+	defer func() { endSpan(span, err) }()
+
 	baseURL := fmt.Sprintf("%s/api/1.0/asset-templates/%s/%s", s.cfg.PlainID.BaseURL, envID, assetTemplateID)
 
-	req, err := http.NewRequest("GET", baseURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
 	if err != nil {
 		return "", err
 	}
 
-	resp, err := s.client.Do(req)
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		return "", err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download asset template ID for %s: %s %s", assetTemplateID, resp.Status, body)
+		return "", fmt.Errorf("failed to download asset template ID for %s: %s %s", assetTemplateID, responseStatus(resp), body)
 	}
 
-	return string(body), nil
+	return prettyJSON(string(body)), nil
 }
 
-func (s Service) IdentityTemplates(envID, identityID string) (string, error) {
+func (s Service) IdentityTemplates(ctx context.Context, envID, identityID string) (identityTemplates string, err error) {
+	ctx, span := s.startSpan(ctx, "IdentityTemplates", attribute.String("env_id", envID), attribute.String("identity_id", identityID))
+	defer func() { endSpan(span, err) }()
+
 	baseURL := fmt.Sprintf("%s/api/1.0/identity-templates/%s/%s", s.cfg.PlainID.BaseURL, envID, identityID)
 
 	println(baseURL)
 
-	req, err := http.NewRequest("GET", baseURL, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL, nil)
 	if err != nil {
 		return "", err
 	}
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := s.client.Do(req)
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		return "", err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("failed to download apps for %s: %s %s", envID, resp.Status, body)
+		return "", fmt.Errorf("failed to download apps for %s: %s %s", envID, responseStatus(resp), body)
 	}
 
-	return string(body), nil
+	return prettyJSON(string(body)), nil
 }
 
 type AppCaller[T any] struct {
 	client *http.Client
+	tracer trace.Tracer
+	retry  config.RetryConfig
 }
 
-func NewAppCaller[T any](client *http.Client) *AppCaller[T] {
+func NewAppCaller[T any](client *http.Client, tracer trace.Tracer, retry config.RetryConfig) *AppCaller[T] {
 	return &AppCaller[T]{
 		client: client,
+		tracer: tracer,
+		retry:  retry,
 	}
 }
-func (a AppCaller[T]) Call(baseURL string) (*T, error) {
-	req, err := http.NewRequest("GET", baseURL, nil)
+
+// Call fetches and decodes baseURL within an HTTP span that is a child of ctx
+func (a AppCaller[T]) Call(ctx context.Context, baseURL string) (result *T, err error) {
+	ctx, span := a.tracer.Start(ctx, "plainid.http.GET", trace.WithAttributes(attribute.String("url.full", baseURL)))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
 	if err != nil {
 		return nil, err
 	}
 	req.Header.Set("Accept", "application/json")
 
-	resp, err := a.client.Do(req)
+	resp, err := doWithRetry(a.client, a.retry, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp.Body)
 	if err != nil {
 		return nil, err
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to call %s: %s %s", baseURL, resp.Status, body)
+		return nil, fmt.Errorf("failed to call %s: %s %s", baseURL, responseStatus(resp), body)
 	}
 
 	log.Debug().Msgf("Response from %s: %s", baseURL, string(body))
@@ -536,20 +511,121 @@ func (a AppCaller[T]) Call(baseURL string) (*T, error) {
 	return &appResponse, nil
 }
 
-func (s Service) PAAGroups(envID string) ([]PAAGroup, error) {
-	type paaGroupsResp struct {
-		Data []PAAGroup `json:"data"`
+// Stream fetches baseURL within an HTTP span that is a child of ctx and passes a decoder reading
+// the response body to handler, so large responses are processed without buffering them
+func (a AppCaller[T]) Stream(ctx context.Context, baseURL string, handler func(decoder *json.Decoder) error) (err error) {
+	ctx, span := a.tracer.Start(ctx, "plainid.http.GET", trace.WithAttributes(attribute.String("url.full", baseURL)))
+	defer func() { endSpan(span, err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", baseURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetry(a.client, a.retry, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode != http.StatusOK {
+		body, err := readBody(resp.Body)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("failed to call %s: %s %s", baseURL, responseStatus(resp), body)
+	}
+
+	if err := handler(json.NewDecoder(resp.Body)); err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// decodeDataArray streams the elements of the data array of a {"data": [...]} response to each,
+// one element at a time. Other fields of the response are skipped.
+func decodeDataArray[E any](decoder *json.Decoder, each func(E) error) error {
+	if err := expectDelim(decoder, '{'); err != nil {
+		return err
+	}
+	for decoder.More() {
+		key, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if key != "data" {
+			var skipped json.RawMessage
+			if err := decoder.Decode(&skipped); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// A null array has no elements, like an empty one
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if token == nil {
+			continue
+		}
+		if token != json.Delim('[') {
+			return fmt.Errorf("expected [, got %v", token)
+		}
+		for decoder.More() {
+			var element E
+			if err := decoder.Decode(&element); err != nil {
+				return err
+			}
+			if err := each(element); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(decoder, ']'); err != nil {
+			return err
+		}
+	}
+	return expectDelim(decoder, '}')
+}
+
+// expectDelim reads the next token and checks it is the delimiter
+func expectDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
 	}
+	if token != delim {
+		return fmt.Errorf("expected %s, got %v", delim, token)
+	}
+	return nil
+}
+
+func (s Service) PAAGroups(ctx context.Context, envID string) (groups []PAAGroup, err error) {
+	ctx, span := s.startSpan(ctx, "PAAGroups", attribute.String("env_id", envID))
+	defer func() { endSpan(span, err) }()
 
 	baseURL := fmt.Sprintf("%s/api/1.0/paa-groups/%s?limit=10000&detailed=true", s.cfg.PlainID.BaseURL, envID)
 
-	paaGroups, err := NewAppCaller[paaGroupsResp](s.client).Call(baseURL)
+	// The detailed PAA groups response can be megabytes, it is decoded one group at a time
+	groups = make([]PAAGroup, 0)
+	err = NewAppCaller[PAAGroup](s.client, s.tracer, s.cfg.Retry).Stream(ctx, baseURL, func(decoder *json.Decoder) error {
+		return decodeDataArray(decoder, func(paaGroup PAAGroup) error {
+			groups = append(groups, paaGroup)
+			return nil
+		})
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to fetch PAA groups for %s: %w", envID, err)
 	}
 
 	// Fetch sources and views for each group
-	for i, paaGroup := range paaGroups.Data {
+	for i, paaGroup := range groups {
+		if err := paaGroup.Validate(); err != nil {
+			log.Warn().Err(err).Msg("PAA group failed validation")
+		}
+
 		// Fetch sources for this group
 		type paaGroupsSourcesResp struct {
 			Data []PAAGroupSource `json:"data"`
@@ -557,31 +633,38 @@ func (s Service) PAAGroups(envID string) ([]PAAGroup, error) {
 
 		baseURL := fmt.Sprintf("%s/api/1.0/paa-groups/%s/%s/sources?limit=1000&detailed=true", s.cfg.PlainID.BaseURL, envID, paaGroup.ID)
 
-		paaGroupSources, err := NewAppCaller[paaGroupsSourcesResp](s.client).Call(baseURL)
+		paaGroupSources, err := NewAppCaller[paaGroupsSourcesResp](s.client, s.tracer, s.cfg.Retry).Call(ctx, baseURL)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch PAA group sources for %s: %w", paaGroup.ID, err)
 		}
 
-		// Assign sources directly to the group
-		paaGroups.Data[i].Sources = paaGroupSources.Data
-
-		// Fetch views for this group
-		type paaGroupsViewsResp struct {
-			Data []PAAGroupViews `json:"data"`
+		// Invalid sources are still backed up, but flagged for the operator
+		for _, source := range paaGroupSources.Data {
+			if err := source.Validate(); err != nil {
+				log.Warn().Err(err).Str("paaGroupId", paaGroup.ID).Msg("PAA group source failed validation")
+			}
 		}
 
-		baseURL = fmt.Sprintf("%s/api/1.0/paa-groups/%s/%s/views", s.cfg.PlainID.BaseURL, envID, paaGroup.ID)
+		// Assign sources directly to the group
+		groups[i].Sources = nonNil(paaGroupSources.Data)
 
-		paaGroupViews, err := NewAppCaller[paaGroupsViewsResp](s.client).Call(baseURL)
+		// Fetch views for this group
+		views, err := s.paaGroupViews(ctx, envID, paaGroup.ID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to fetch PAA group views for %s: %w", paaGroup.ID, err)
 		}
 
 		// Assign views directly to the group
-		paaGroups.Data[i].Views = paaGroupViews.Data
+		groups[i].Views = views
 	}
 
-	return paaGroups.Data, nil
+	return groups, nil
+}
+
+// paaGroupViews returns all views of a PAA group, following pagination for groups with many views
+func (s Service) paaGroupViews(ctx context.Context, envID, paaGroupID string) ([]PAAGroupViews, error) {
+	baseURL := fmt.Sprintf("%s/api/1.0/paa-groups/%s/%s/views?detailed=true", s.cfg.PlainID.BaseURL, envID, paaGroupID)
+	return Paginate[PAAGroupViews](ctx, s.client, s.tracer, s.cfg.Retry, baseURL, 1000)
 }
 
 type PAAGroupTranslator struct {
@@ -630,10 +713,16 @@ type PAAGroup struct {
 	Sources             []PAAGroupSource `json:"sources"`
 }
 
+// ToJSON returns the PAA group as indented JSON
 func (p PAAGroup) ToJSON() (string, error) {
-	b, err := json.Marshal(p)
+	b, err := json.MarshalIndent(p, "", jsonIndent)
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal PAAGroup to JSON: %w", err)
 	}
 	return string(b), nil
 }
+
+// AsPrettyJSON is ToJSON, which is indented for readable diffs
+func (p PAAGroup) AsPrettyJSON() (string, error) {
+	return p.ToJSON()
+}
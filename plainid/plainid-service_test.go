@@ -0,0 +1,950 @@
+package plainid_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/internal/metrics"
+	"github.com/plainid/git-backup/plainid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/suite"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ServiceTestSuite tests the PlainID service against a fake PlainID API
+type ServiceTestSuite struct {
+	suite.Suite
+	mux    *http.ServeMux
+	server *httptest.Server
+	cfg    config.Config
+}
+
+func TestServiceSuite(t *testing.T) {
+	suite.Run(t, new(ServiceTestSuite))
+}
+
+// SetupTest starts a fresh fake PlainID API for every test
+func (s *ServiceTestSuite) SetupTest() {
+	s.mux = http.NewServeMux()
+	s.mux.HandleFunc("/api/1.0/api-key/token", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"bearer","expires_in":3600}`))
+	})
+	s.server = httptest.NewServer(s.mux)
+
+	s.cfg = config.Config{
+		PlainID: config.PlainIDConfig{
+			BaseURL:      s.server.URL,
+			ClientID:     "client-id",
+			ClientSecret: "client-secret",
+		},
+	}
+}
+
+// TearDownTest stops the fake PlainID API
+func (s *ServiceTestSuite) TearDownTest() {
+	s.server.Close()
+}
+
+// writeJSON writes v as a JSON response
+func (s *ServiceTestSuite) writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	s.Require().NoError(json.NewEncoder(w).Encode(v))
+}
+
+func (s *ServiceTestSuite) TestAppPoliciesInvalidPolicyRequestError() {
+	s.mux.HandleFunc("/policy-mgmt/1.0/policies/env1", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, plainid.PolicyResponse{
+			Data: []plainid.Policy{{ID: "bad\nid", Name: "Broken", State: "Active"}},
+			Meta: plainid.Meta{Total: 1, Limit: 1000},
+		})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	_, err := service.AppPolicies(context.Background(), "env1", "ws1", "app1")
+	s.Require().Error(err)
+	s.Assert().Contains(err.Error(), "failed to download policy for bad\nid in workspace ws1: ")
+	s.Assert().Contains(err.Error(), "invalid control character in URL")
+}
+
+func (s *ServiceTestSuite) TestCustomTokenURL() {
+	tokenRequested := false
+	s.mux.HandleFunc("/auth/token", func(w http.ResponseWriter, _ *http.Request) {
+		tokenRequested = true
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"custom-token","token_type":"bearer","expires_in":3600}`))
+	})
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("Bearer custom-token", r.Header.Get("Authorization"))
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{{ID: "env1", Name: "Env 1"}}})
+	})
+
+	s.cfg.PlainID.TokenURL = s.server.URL + "/auth/token"
+	service := plainid.NewService(s.cfg)
+
+	envs, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+	s.Assert().True(tokenRequested, "custom token URL should be used")
+	s.Assert().Len(envs, 1)
+}
+
+func (s *ServiceTestSuite) TestAppPoliciesContent() {
+	s.mux.HandleFunc("/policy-mgmt/1.0/policies/env1", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, plainid.PolicyResponse{
+			Data: []plainid.Policy{
+				{ID: "pol1", Name: "First", State: "Active"},
+				{ID: "pol2", Name: "Disabled", State: "Inactive"},
+				{ID: "pol3", Name: "Third", State: "Active"},
+			},
+			Meta: plainid.Meta{Total: 3, Limit: 1000},
+		})
+	})
+	s.mux.HandleFunc("/api/2.0/policies/env1", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("ws1", r.URL.Query().Get("filter[authWsId]"))
+		_, _ = w.Write([]byte("package " + r.URL.Query().Get("filter[id]")))
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	policies, err := service.AppPolicies(context.Background(), "env1", "ws1", "app1")
+	s.Require().NoError(err)
+	s.Assert().Equal([]plainid.PolicyContent{
+		{ID: "pol1", Name: "First", Content: "package pol1", Format: plainid.PolicyFormatRego},
+		{ID: "pol3", Name: "Third", Content: "package pol3", Format: plainid.PolicyFormatRego},
+	}, policies)
+}
+
+func (s *ServiceTestSuite) TestAppPoliciesPaginated() {
+	// 1001 policies, the last one is only on the second page
+	s.mux.HandleFunc("/policy-mgmt/1.0/policies/env1", func(w http.ResponseWriter, r *http.Request) {
+		var data []plainid.Policy
+		if r.URL.Query().Get("offset") == "0" {
+			for i := range 1000 {
+				data = append(data, plainid.Policy{ID: fmt.Sprintf("pol%d", i), Name: "Disabled", State: "Inactive"})
+			}
+		} else {
+			data = []plainid.Policy{{ID: "pol1000", Name: "Last Page", State: "Active"}}
+		}
+		s.writeJSON(w, plainid.PolicyResponse{Data: data, Meta: plainid.Meta{Total: 1001, Limit: 1000}})
+	})
+	s.mux.HandleFunc("/api/2.0/policies/env1", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("package " + r.URL.Query().Get("filter[id]")))
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	policies, err := service.AppPolicies(context.Background(), "env1", "ws1", "app1")
+	s.Require().NoError(err)
+	s.Assert().Equal([]plainid.PolicyContent{
+		{ID: "pol1000", Name: "Last Page", Content: "package pol1000", Format: plainid.PolicyFormatRego},
+	}, policies)
+}
+
+func (s *ServiceTestSuite) TestPaginate() {
+	// 5 items with the total at the top level, the existing query parameters are kept
+	s.mux.HandleFunc("/items", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("true", r.URL.Query().Get("detailed"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		data := []int{}
+		for i := offset; i < min(offset+limit, 5); i++ {
+			data = append(data, i)
+		}
+		s.writeJSON(w, map[string]any{"data": data, "total": 5})
+	})
+
+	items, err := plainid.Paginate[int](context.Background(), s.server.Client(), noop.NewTracerProvider().Tracer(""), config.RetryConfig{}, s.server.URL+"/items?detailed=true", 2)
+	s.Require().NoError(err)
+	s.Assert().Equal([]int{0, 1, 2, 3, 4}, items)
+}
+
+func (s *ServiceTestSuite) TestCancelledContext() {
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{}})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := service.Environments(ctx)
+	s.Require().ErrorIs(err, context.Canceled)
+}
+
+func (s *ServiceTestSuite) TestHTTPTimeout() {
+	s.mux.HandleFunc("/env-mgmt/environment", func(_ http.ResponseWriter, r *http.Request) {
+		// A hung PlainID endpoint only answers once the client gave up
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+
+	s.cfg.PlainID.HTTPTimeoutSeconds = 1
+	service := plainid.NewService(s.cfg)
+
+	start := time.Now()
+	_, err := service.Environments(context.Background())
+	s.Require().ErrorContains(err, "Client.Timeout exceeded")
+	s.Assert().Less(time.Since(start), 3*time.Second)
+}
+
+func (s *ServiceTestSuite) TestRetryTransientFailures() {
+	// Environments are fetched with the AppCaller, workspace API mappers with doWithRetry
+	statuses := map[string][]int{
+		"/env-mgmt/environment":                       {http.StatusServiceUnavailable, http.StatusTooManyRequests},
+		"/api/1.0/api-mapper-sets/env1/workspace/ws1": {http.StatusBadGateway},
+	}
+	requests := map[string]int{}
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		if failures := statuses[r.URL.Path]; requests[r.URL.Path] <= len(failures) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(failures[requests[r.URL.Path]-1])
+			return
+		}
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{}})
+	}
+	for path := range statuses {
+		s.mux.HandleFunc(path, handler)
+	}
+
+	s.cfg.Retry = config.RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	service := plainid.NewService(s.cfg)
+
+	_, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+	s.Assert().Equal(3, requests["/env-mgmt/environment"])
+
+	_, err = service.WsAPIMapper(context.Background(), "env1", "ws1")
+	s.Require().NoError(err)
+	s.Assert().Equal(2, requests["/api/1.0/api-mapper-sets/env1/workspace/ws1"])
+}
+
+func (s *ServiceTestSuite) TestRetryGivesUp() {
+	requests := map[string]int{}
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	s.mux.HandleFunc("/api/1.0/attribute-schemas/env1", func(w http.ResponseWriter, r *http.Request) {
+		requests[r.URL.Path]++
+		w.WriteHeader(http.StatusForbidden)
+	})
+
+	s.cfg.Retry = config.RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	service := plainid.NewService(s.cfg)
+
+	_, err := service.Environments(context.Background())
+	s.Require().ErrorContains(err, "500")
+	s.Assert().Equal(3, requests["/env-mgmt/environment"])
+
+	// Client errors are not retried
+	_, err = service.AttributeSchemas(context.Background(), "env1")
+	s.Require().Error(err)
+	s.Assert().Equal(1, requests["/api/1.0/attribute-schemas/env1"])
+}
+
+func (s *ServiceTestSuite) TestRetryPostOnlyOnTooManyRequests() {
+	statuses := map[string][]int{
+		"ws1": {http.StatusServiceUnavailable},
+		"ws2": {http.StatusTooManyRequests},
+	}
+	requests := map[string]int{}
+	s.mux.HandleFunc("/api/2.0/policies/env1", func(w http.ResponseWriter, r *http.Request) {
+		wsID := r.URL.Query().Get("authWsId")
+		requests[wsID]++
+		body, err := io.ReadAll(r.Body)
+		s.Require().NoError(err)
+		s.Assert().Equal("package pol1", string(body), "the body should be sent again")
+		if failures := statuses[wsID]; requests[wsID] <= len(failures) {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(failures[requests[wsID]-1])
+			return
+		}
+		s.writeJSON(w, map[string]any{"data": map[string]string{"id": "pol1"}})
+	})
+
+	s.cfg.Retry = config.RetryConfig{MaxAttempts: 3, InitialDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	service := plainid.NewService(s.cfg)
+
+	// The policy may have been created before the 503, it is not imported twice
+	s.Require().ErrorContains(service.ImportPolicy(context.Background(), "env1", "ws1", "app1", "package pol1"), "503")
+	s.Assert().Equal(1, requests["ws1"])
+
+	s.Require().NoError(service.ImportPolicy(context.Background(), "env1", "ws2", "app1", "package pol1"))
+	s.Assert().Equal(2, requests["ws2"])
+}
+
+func (s *ServiceTestSuite) TestWorkspacesAndIdentitiesPaginated() {
+	// 250 items are served as three pages of at most 100
+	const total = 250
+	tests := []struct {
+		name  string
+		path  string
+		fetch func(service *plainid.Service) ([]string, error)
+	}{
+		{
+			name: "workspaces",
+			path: "/env-mgmt/1.0-int.1/authorization-workspaces/env1",
+			fetch: func(service *plainid.Service) ([]string, error) {
+				wss, err := service.Workspaces(context.Background(), "env1")
+				var ids []string
+				for _, ws := range wss {
+					ids = append(ids, ws.ID)
+				}
+				return ids, err
+			},
+		},
+		{
+			name: "identities",
+			path: "/env-mgmt/1.0/identity-workspaces/env1",
+			fetch: func(service *plainid.Service) ([]string, error) {
+				identities, err := service.Identities(context.Background(), "env1")
+				var ids []string
+				for _, identity := range identities {
+					ids = append(ids, identity.ID)
+				}
+				return ids, err
+			},
+		},
+	}
+
+	var expected []string
+	for i := range total {
+		expected = append(expected, fmt.Sprintf("id%d", i))
+	}
+
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			pages := 0
+			s.mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+				pages++
+				offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+				limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+				var data []map[string]string
+				for i := offset; i < min(offset+limit, total); i++ {
+					data = append(data, map[string]string{"id": fmt.Sprintf("id%d", i)})
+				}
+				s.writeJSON(w, map[string]any{"data": data, "meta": plainid.Meta{Total: total, Limit: limit, Offset: offset}})
+			})
+
+			ids, err := tt.fetch(plainid.NewService(s.cfg))
+			s.Require().NoError(err)
+			s.Assert().Equal(expected, ids)
+			s.Assert().Equal(3, pages)
+		})
+	}
+}
+
+func (s *ServiceTestSuite) TestAssetTemplateIDsPaginated() {
+	// 120 asset templates are served as three pages of at most 50
+	const total = 120
+	pages := 0
+	s.mux.HandleFunc("/internal-assets/4.0/asset-types", func(w http.ResponseWriter, r *http.Request) {
+		pages++
+		s.Assert().Equal("ws1", r.URL.Query().Get("filter[ownerId]"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		var data []map[string]string
+		for i := offset; i < min(offset+limit, total); i++ {
+			data = append(data, map[string]string{"id": strconv.Itoa(i), "externalId": fmt.Sprintf("at%d", i)})
+		}
+		s.writeJSON(w, map[string]any{"data": data, "meta": plainid.Meta{Total: total, Limit: limit, Offset: offset}})
+	})
+
+	ids, err := plainid.NewService(s.cfg).AssetTemplateIDs(context.Background(), "ws1")
+	s.Require().NoError(err)
+	s.Require().Len(ids, total)
+	s.Assert().Equal("at0", ids[0])
+	s.Assert().Equal("at119", ids[total-1])
+	s.Assert().Equal(3, pages)
+}
+
+func (s *ServiceTestSuite) TestAuditLogs() {
+	s.mux.HandleFunc("/api/1.0/audit-logs/env1", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("2025-01-01T00:00:00Z", r.URL.Query().Get("from"))
+		s.Assert().Equal("2025-01-02T00:00:00Z", r.URL.Query().Get("to"))
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	until := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	auditLogs, err := service.AuditLogs(context.Background(), "env1", until.Add(-24*time.Hour), until)
+	s.Require().NoError(err)
+	s.Assert().Equal(`{"data":[]}`, auditLogs)
+}
+
+func (s *ServiceTestSuite) TestRequestIDHeader() {
+	var requestIDs []string
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		requestIDs = append(requestIDs, r.Header.Get(plainid.RequestIDHeader))
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{}})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	for range 2 {
+		_, err := service.Environments(context.Background())
+		s.Require().NoError(err)
+	}
+	s.Require().Len(requestIDs, 2)
+	s.Assert().Len(requestIDs[0], 36)
+	s.Assert().NotEqual(requestIDs[0], requestIDs[1], "every call should get its own request ID")
+}
+
+func (s *ServiceTestSuite) TestRateLimit() {
+	var requests []time.Time
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, _ *http.Request) {
+		requests = append(requests, time.Now())
+		s.writeJSON(w, map[string]any{"data": []any{}})
+	})
+
+	// The token request is held like the API requests
+	s.cfg.PlainID.RateLimitRPS = 20
+	service := plainid.NewService(s.cfg)
+	start := time.Now()
+	for range 3 {
+		_, err := service.Environments(context.Background())
+		s.Require().NoError(err)
+	}
+	s.Require().Len(requests, 3)
+	s.Assert().GreaterOrEqual(time.Since(start), 140*time.Millisecond, "4 requests at 20 per second take at least 150ms")
+	s.Assert().GreaterOrEqual(requests[2].Sub(requests[1]), 40*time.Millisecond)
+
+	// Without limit the requests are sent right away
+	requests = nil
+	s.cfg.PlainID.RateLimitRPS = 0
+	service = plainid.NewService(s.cfg)
+	start = time.Now()
+	for range 3 {
+		_, err := service.Environments(context.Background())
+		s.Require().NoError(err)
+	}
+	s.Assert().Less(time.Since(start), 100*time.Millisecond)
+}
+
+func (s *ServiceTestSuite) TestRequestIDPrefix() {
+	var requestID string
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		requestID = r.Header.Get(plainid.RequestIDHeader)
+		// PlainID may trace the call under its own ID
+		w.Header().Set(plainid.RequestIDHeader, "server-id")
+		http.Error(w, "boom", http.StatusBadRequest)
+	})
+
+	service := plainid.NewService(s.cfg, plainid.WithRequestIDPrefix("backup-20250101-120000"))
+
+	_, err := service.Environments(context.Background())
+	s.Require().Error(err)
+	s.Assert().Regexp(`^backup-20250101-120000-[0-9a-f-]{36}$`, requestID)
+	s.Assert().Contains(err.Error(), fmt.Sprintf("400 Bad Request (request ID %s, server request ID server-id)", requestID))
+}
+
+func (s *ServiceTestSuite) TestMetrics() {
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "boom", http.StatusBadRequest)
+	})
+	calls := testutil.ToFloat64(metrics.APICalls.WithLabelValues("Environments"))
+	failures := testutil.ToFloat64(metrics.APIErrors.WithLabelValues("Environments", "400"))
+
+	_, err := plainid.NewService(s.cfg).Environments(context.Background())
+	s.Require().Error(err)
+	s.Assert().Equal(calls+1, testutil.ToFloat64(metrics.APICalls.WithLabelValues("Environments")))
+	s.Assert().Equal(failures+1, testutil.ToFloat64(metrics.APIErrors.WithLabelValues("Environments", "400")))
+
+	// Applications without an API mapper are expected
+	notFound := testutil.ToFloat64(metrics.APIErrors.WithLabelValues("AppAPIMapper", "404"))
+	_, err = plainid.NewService(s.cfg).AppAPIMapper(context.Background(), "env1", "app1")
+	s.Require().NoError(err)
+	s.Assert().Equal(notFound, testutil.ToFloat64(metrics.APIErrors.WithLabelValues("AppAPIMapper", "404")))
+}
+
+func (s *ServiceTestSuite) TestUserAgent() {
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("Acme Backup/1.2.3", r.Header.Get("User-Agent"))
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{}})
+	})
+
+	service := plainid.NewService(s.cfg, plainid.WithUserAgent("Acme Backup/1.2.3"))
+
+	_, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+}
+
+func (s *ServiceTestSuite) TestConditionalCache() {
+	requests := 0
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{{ID: "env1", Name: "Env 1"}}})
+	})
+
+	cachePath := filepath.Join(s.T().TempDir(), "etag-cache.json")
+	cache := plainid.NewConditionalCache()
+	s.Require().NoError(cache.Load(cachePath))
+	service := plainid.NewService(s.cfg, plainid.WithConditionalCache(cache))
+
+	envs, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+	s.Require().NoError(cache.Save(cachePath))
+
+	// The next backup loads the responses of the previous one
+	s.Require().NoError(cache.Load(cachePath))
+	cachedEnvs, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+	s.Assert().Equal(2, requests)
+	s.Assert().Equal(envs, cachedEnvs, "unchanged resource should be served from the cache")
+}
+
+func (s *ServiceTestSuite) TestPAAGroupsStreamed() {
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"meta":{"total":2},"data":[{"id":"g1","paaGroupType":"IDP"},{"id":"g2","paaGroupType":"IDP"}]}`))
+	})
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	groups, err := service.PAAGroups(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Require().Len(groups, 2)
+	s.Assert().Equal("g1", groups[0].ID)
+	s.Assert().Equal("g2", groups[1].ID)
+}
+
+func (s *ServiceTestSuite) TestPAAGroupViewsPaginated() {
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":"g1","paaGroupType":"IDP"}]}`))
+	})
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1/g1/sources", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+
+	// Serve 1500 views in pages of the requested size
+	const total = 1500
+	var offsets []string
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1/g1/views", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal("true", r.URL.Query().Get("detailed"))
+		offsets = append(offsets, r.URL.Query().Get("offset"))
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+		var data []string
+		for i := offset; i < total && i < offset+limit; i++ {
+			data = append(data, fmt.Sprintf(`{"type":"SQL","paaId":"paa-%d","text":"SELECT %d"}`, i, i))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"meta":{"total":%d},"data":[%s]}`, total, strings.Join(data, ","))
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	groups, err := service.PAAGroups(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Require().Len(groups, 1)
+	s.Assert().Equal([]string{"0", "1000"}, offsets)
+	s.Require().Len(groups[0].Views, total)
+	s.Assert().Equal("paa-1499", groups[0].Views[total-1].PAAID)
+}
+
+func (s *ServiceTestSuite) TestUpsertApplication() {
+	// app1 exists, app2 was deleted and recreated as app3, Deleted no longer exists
+	var requests []string
+	s.mux.HandleFunc("/api/1.0/applications/env1/", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.URL.Path {
+		case "/api/1.0/applications/env1/app1":
+			s.writeJSON(w, map[string]any{"data": map[string]string{"applicationId": "app1", "displayName": "Existing"}})
+		case "/api/1.0/applications/env1/app3":
+			s.writeJSON(w, map[string]any{"data": map[string]string{"applicationId": "app3", "displayName": "Recreated"}})
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	s.mux.HandleFunc("/api/1.0/applications/env1", func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		var created map[string]any
+		s.Require().NoError(json.NewDecoder(r.Body).Decode(&created))
+		s.Assert().Empty(created["applicationId"], "PlainID assigns the ID of created applications")
+		s.Assert().Equal("ws1", created["authWsId"])
+		s.writeJSON(w, map[string]any{"data": map[string]string{"applicationId": "app4"}})
+	})
+	s.mux.HandleFunc("/policy-mgmt/1.0/applications/env1", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":"app3","name":"Recreated","authWsId":"ws1"}],"total":1}`))
+	})
+
+	service := plainid.NewService(s.cfg)
+	ctx := context.Background()
+
+	appID, err := service.UpsertApplication(ctx, "env1", "ws1", plainid.Application{ID: "app1", Name: "Existing"})
+	s.Require().NoError(err)
+	s.Assert().Equal("app1", appID)
+	s.Assert().Equal([]string{"GET /api/1.0/applications/env1/app1", "PUT /api/1.0/applications/env1/app1"}, requests)
+
+	requests = nil
+	appID, err = service.UpsertApplication(ctx, "env1", "ws1", plainid.Application{ID: "app2", Name: "Recreated"})
+	s.Require().NoError(err)
+	s.Assert().Equal("app3", appID)
+	s.Assert().Contains(requests, "PUT /api/1.0/applications/env1/app3", "application recreated under another ID should be updated")
+	s.Assert().NotContains(requests, "POST /api/1.0/applications/env1")
+
+	requests = nil
+	appID, err = service.UpsertApplication(ctx, "env1", "ws1", plainid.Application{ID: "app5", Name: "Deleted"})
+	s.Require().NoError(err)
+	s.Assert().Equal("app4", appID, "the ID assigned by PlainID should be returned")
+	s.Assert().Contains(requests, "POST /api/1.0/applications/env1")
+
+	requests = nil
+	s.Require().NoError(service.ImportApplication(ctx, "env1", "ws1", plainid.Application{ID: "app1", Name: "Existing"}))
+	s.Assert().Equal([]string{"GET /api/1.0/applications/env1/app1", "PUT /api/1.0/applications/env1/app1"}, requests)
+}
+
+func (s *ServiceTestSuite) TestImportPolicy() {
+	s.mux.HandleFunc("/api/2.0/policies/env1", func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal(http.MethodPost, r.Method)
+		s.Assert().Equal("text/plain;language=rego", r.Header.Get("Content-Type"))
+		s.Assert().Equal("ws1", r.URL.Query().Get("authWsId"))
+		body, err := io.ReadAll(r.Body)
+		s.Require().NoError(err)
+		if r.URL.Query().Get("appId") == "broken" {
+			http.Error(w, "invalid rego", http.StatusBadRequest)
+			return
+		}
+		s.Assert().Equal("package pol1", string(body))
+		s.writeJSON(w, map[string]any{"data": map[string]string{"id": "pol1"}})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	s.Require().NoError(service.ImportPolicy(context.Background(), "env1", "ws1", "app1", "package pol1"))
+
+	err := service.ImportPolicy(context.Background(), "env1", "ws1", "broken", "package pol1")
+	s.Require().Error(err)
+	s.Assert().Contains(err.Error(), "failed to import policy for broken: 400 Bad Request (request ID ")
+	s.Assert().Contains(err.Error(), "invalid rego")
+}
+
+func (s *ServiceTestSuite) TestImportTemplates() {
+	var imported []string
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		s.Assert().Equal(http.MethodPut, r.Method)
+		s.Assert().Equal("application/json", r.Header.Get("Content-Type"))
+		body, err := io.ReadAll(r.Body)
+		s.Require().NoError(err)
+		imported = append(imported, r.URL.Path+" "+string(body))
+		s.writeJSON(w, map[string]any{})
+	}
+	s.mux.HandleFunc("/api/1.0/asset-templates/env1/at1", handler)
+	s.mux.HandleFunc("/api/1.0/identity-templates/env1/User", handler)
+
+	service := plainid.NewService(s.cfg)
+
+	s.Require().NoError(service.ImportAssetTemplate(context.Background(), "env1", "at1", `{"id":"at1"}`))
+	s.Require().NoError(service.ImportIdentityTemplate(context.Background(), "env1", "User", `{"id":"User"}`))
+	s.Assert().Equal([]string{
+		`/api/1.0/asset-templates/env1/at1 {"id":"at1"}`,
+		`/api/1.0/identity-templates/env1/User {"id":"User"}`,
+	}, imported)
+
+	err := service.ImportIdentityTemplate(context.Background(), "env1", "Device", `{"id":"Device"}`)
+	s.Assert().ErrorContains(err, "failed to import identity template Device: PUT ")
+}
+
+func (s *ServiceTestSuite) TestEmptyResponsesReturnEmptySlices() {
+	for _, path := range []string{
+		"/env-mgmt/environment",
+		"/env-mgmt/1.0-int.1/authorization-workspaces/env1",
+		"/env-mgmt/1.0/identity-workspaces/env1",
+		"/internal-assets/4.0/asset-types",
+		"/policy-mgmt/1.0/applications/env1",
+		"/api/1.0/paa-groups/env1",
+	} {
+		s.mux.HandleFunc(path, func(w http.ResponseWriter, _ *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"data":null}`))
+		})
+	}
+
+	service := plainid.NewService(s.cfg)
+
+	envs, err := service.Environments(context.Background())
+	s.Require().NoError(err)
+	s.Assert().NotNil(envs)
+	s.Assert().Empty(envs)
+
+	wss, err := service.Workspaces(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Assert().NotNil(wss)
+	s.Assert().Empty(wss)
+
+	identities, err := service.Identities(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Assert().NotNil(identities)
+	s.Assert().Empty(identities)
+
+	assetTemplateIDs, err := service.AssetTemplateIDs(context.Background(), "ws1")
+	s.Require().NoError(err)
+	s.Assert().NotNil(assetTemplateIDs)
+	s.Assert().Empty(assetTemplateIDs)
+
+	apps, err := service.Applications(context.Background(), "env1", "ws1")
+	s.Require().NoError(err)
+	s.Assert().NotNil(apps)
+	s.Assert().Empty(apps)
+
+	groups, err := service.PAAGroups(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Assert().NotNil(groups)
+	s.Assert().Empty(groups)
+}
+
+func (s *ServiceTestSuite) TestConnection() {
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, map[string]any{"data": []plainid.Environment{{ID: "env1", Name: "Env 1"}}})
+	})
+	version := "1.4.2"
+	s.mux.HandleFunc("/api/1.0/version", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, map[string]string{"version": version})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	result, err := service.TestConnection(context.Background())
+	s.Require().NoError(err)
+	s.Assert().True(result.OK())
+	s.Require().Len(result.Checks, 3)
+	s.Assert().Equal("1 environments accessible", result.Checks[1].Message)
+	s.Assert().Equal("API version 1.4.2", result.Checks[2].Message)
+
+	version = "0.9.0"
+	result, err = service.TestConnection(context.Background())
+	s.Require().Error(err)
+	s.Assert().False(result.OK())
+	s.Assert().Contains(result.Checks[2].Message, "older than the supported")
+}
+
+func (s *ServiceTestSuite) TestConnectionInvalidCredentials() {
+	s.cfg.PlainID.TokenURL = s.server.URL + "/invalid/token"
+	service := plainid.NewService(s.cfg)
+
+	result, err := service.TestConnection(context.Background())
+	s.Require().Error(err)
+	s.Require().Len(result.Checks, 1, "checks after a failed one should not run")
+	s.Assert().Equal("token", result.Checks[0].Name)
+	s.Assert().False(result.Checks[0].OK)
+}
+
+func (s *ServiceTestSuite) TestApplicationsWildcardWorkspace() {
+	s.mux.HandleFunc("/policy-mgmt/1.0/applications/env1", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"data":[{"id":"app1","name":"App1","authWsId":"ws1"},{"id":"app2","name":"App2","authWsId":"ws2"}],"total":2}`))
+	})
+	s.mux.HandleFunc("/api/1.0/applications/env1/", func(w http.ResponseWriter, r *http.Request) {
+		id := strings.TrimPrefix(r.URL.Path, "/api/1.0/applications/env1/")
+		s.writeJSON(w, map[string]any{"data": map[string]string{"applicationId": id, "displayName": id}})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	apps, err := service.Applications(context.Background(), "env1", "*")
+	s.Require().NoError(err)
+	s.Require().Len(apps, 2)
+	s.Assert().Equal("ws1", apps[0].WSID)
+	s.Assert().Equal("ws2", apps[1].WSID)
+
+	apps, err = service.Applications(context.Background(), "env1", "ws2")
+	s.Require().NoError(err)
+	s.Require().Len(apps, 1)
+	s.Assert().Equal("app2", apps[0].ID)
+}
+
+func (s *ServiceTestSuite) TestAppAPIMapperMissing() {
+	s.mux.HandleFunc("/api/1.0/api-mapper-sets/env1/app1", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, map[string]any{"mappers": []any{}})
+	})
+
+	service := plainid.NewService(s.cfg)
+
+	mapper, err := service.AppAPIMapper(context.Background(), "env1", "app1")
+	s.Require().NoError(err)
+	s.Assert().JSONEq(`{"mappers":[]}`, mapper)
+
+	// The expected 404 is logged at debug level only
+	var logs strings.Builder
+	defer func(logger zerolog.Logger) { log.Logger = logger }(log.Logger)
+	log.Logger = zerolog.New(&logs).Level(zerolog.DebugLevel)
+
+	mapper, err = service.AppAPIMapper(context.Background(), "env1", "app2")
+	s.Require().NoError(err, "application without API mapper should not fail")
+	s.Assert().Empty(mapper)
+	s.Assert().Contains(logs.String(), `"status":404`)
+	s.Assert().NotContains(logs.String(), `"level":"error"`)
+}
+
+func (s *ServiceTestSuite) TestIndentedJSON() {
+	app := plainid.Application{ID: "app1", Name: "App1"}
+	appJSON, err := app.AsJSON()
+	s.Require().NoError(err)
+	s.Assert().Contains(appJSON, "\n  \"applicationId\": \"app1\",\n")
+	pretty, err := app.AsPrettyJSON()
+	s.Require().NoError(err)
+	s.Assert().Equal(appJSON, pretty)
+
+	group := plainid.PAAGroup{ID: "paa1", PAAGroupType: "SYNC"}
+	groupJSON, err := group.ToJSON()
+	s.Require().NoError(err)
+	s.Assert().Contains(groupJSON, "\n  \"id\": \"paa1\",\n")
+	pretty, err = group.AsPrettyJSON()
+	s.Require().NoError(err)
+	s.Assert().Equal(groupJSON, pretty)
+
+	// Raw JSON of PlainID is indented, other content is kept as it is
+	s.mux.HandleFunc("/api/1.0/asset-templates/env1/at1", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"at1","attributes":[]}`))
+	})
+	s.mux.HandleFunc("/api/1.0/asset-templates/env1/at2", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`not json`))
+	})
+	service := plainid.NewService(s.cfg)
+	template, err := service.AssetTemplate(context.Background(), "env1", "at1")
+	s.Require().NoError(err)
+	s.Assert().Equal("{\n  \"id\": \"at1\",\n  \"attributes\": []\n}", template)
+	template, err = service.AssetTemplate(context.Background(), "env1", "at2")
+	s.Require().NoError(err)
+	s.Assert().Equal("not json", template)
+}
+
+func (s *ServiceTestSuite) TestValidate() {
+	tests := []struct {
+		name     string
+		resource interface{ Validate() error }
+		missing  []string
+	}{
+		{"valid source", plainid.PAAGroupSource{ID: "s1", Name: "LDAP", Adapter: "ldap", Properties: map[string]any{"host": "ldap"}}, nil},
+		{"empty source", plainid.PAAGroupSource{}, []string{"sourceId", "name", "adapter", "properties"}},
+		{"source without adapter", plainid.PAAGroupSource{ID: "s1", Name: "LDAP", Properties: map[string]any{"host": "ldap"}}, []string{"adapter"}},
+		{"valid PAA group", plainid.PAAGroup{ID: "g1", PAAGroupType: "IDP"}, nil},
+		{"empty PAA group", plainid.PAAGroup{}, []string{"id", "paaGroupType"}},
+		{"PAA group without type", plainid.PAAGroup{ID: "g1"}, []string{"paaGroupType"}},
+		{"valid application", plainid.Application{ID: "app1", Name: "App1"}, nil},
+		{"empty application", plainid.Application{}, []string{"applicationId", "displayName"}},
+		{"application without name", plainid.Application{ID: "app1"}, []string{"displayName"}},
+	}
+	for _, tt := range tests {
+		s.Run(tt.name, func() {
+			err := tt.resource.Validate()
+			if tt.missing == nil {
+				s.Assert().NoError(err)
+				return
+			}
+			var validationErr *plainid.ValidationError
+			s.Require().ErrorAs(err, &validationErr)
+			s.Assert().Equal(tt.missing, validationErr.MissingFields)
+		})
+	}
+}
+
+func (s *ServiceTestSuite) TestPAAGroupsInvalidSource() {
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"id":"g1","paaGroupType":"IDP"}]}`))
+	})
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1/g1/sources", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[{"sourceId":"s1","name":"LDAP"}]}`))
+	})
+	s.mux.HandleFunc("/api/1.0/paa-groups/env1/g1/views", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[]}`))
+	})
+
+	var logs strings.Builder
+	defer func(logger zerolog.Logger) { log.Logger = logger }(log.Logger)
+	log.Logger = zerolog.New(&logs).Level(zerolog.WarnLevel)
+
+	// The invalid source does not fail the call, it is flagged and still backed up
+	groups, err := plainid.NewService(s.cfg).PAAGroups(context.Background(), "env1")
+	s.Require().NoError(err)
+	s.Require().Len(groups, 1)
+	s.Require().Len(groups[0].Sources, 1)
+	s.Assert().Equal("s1", groups[0].Sources[0].ID)
+	s.Assert().Contains(logs.String(), `"level":"warn"`)
+	s.Assert().Contains(logs.String(), `"paaGroupId":"g1"`)
+	s.Assert().Contains(logs.String(), `missing required fields: adapter, properties`)
+}
+
+func (s *ServiceTestSuite) TestTracing() {
+	s.mux.HandleFunc("/api/1.0/api-mapper-sets/env1/app1", func(w http.ResponseWriter, _ *http.Request) {
+		s.writeJSON(w, map[string]any{"mappers": []any{}})
+	})
+	s.mux.HandleFunc("/policy-mgmt/1.0/applications/env1", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":[],"total":0}`))
+	})
+	s.mux.HandleFunc("/env-mgmt/environment", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "boom", http.StatusBadRequest)
+	})
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	service := plainid.NewService(s.cfg, plainid.WithTracer(provider.Tracer("test")))
+
+	_, err := service.AppAPIMapper(context.Background(), "env1", "app1")
+	s.Require().NoError(err)
+	_, err = service.Applications(context.Background(), "env1", "ws1")
+	s.Require().NoError(err)
+	_, err = service.Environments(context.Background())
+	s.Require().Error(err)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+
+	mapper := spans["plainid.AppAPIMapper"]
+	s.Require().NotNil(mapper)
+	s.Assert().ElementsMatch([]attribute.KeyValue{
+		attribute.String("env_id", "env1"),
+		attribute.String("app_id", "app1"),
+	}, mapper.Attributes())
+	s.Assert().Equal(codes.Unset, mapper.Status().Code)
+
+	applications := spans["plainid.Applications"]
+	s.Require().NotNil(applications)
+	s.Assert().ElementsMatch([]attribute.KeyValue{
+		attribute.String("env_id", "env1"),
+		attribute.String("ws_id", "ws1"),
+	}, applications.Attributes())
+
+	// Errors are recorded on the span
+	environments := spans["plainid.Environments"]
+	s.Require().NotNil(environments)
+	s.Assert().Equal(codes.Error, environments.Status().Code)
+	s.Assert().Contains(environments.Status().Description, "400 Bad Request")
+	s.Require().Len(environments.Events(), 1)
+	s.Assert().Equal("exception", environments.Events()[0].Name)
+}
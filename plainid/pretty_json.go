@@ -0,0 +1,19 @@
+package plainid
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// jsonIndent is the indentation of the JSON returned by the service, so a changed nested field
+// shows as a single line in git diffs
+const jsonIndent = "  "
+
+// prettyJSON indents raw JSON returned by PlainID. Content that is not JSON is returned unchanged.
+func prettyJSON(raw string) string {
+	var buf bytes.Buffer
+	if err := json.Indent(&buf, []byte(raw), "", jsonIndent); err != nil {
+		return raw
+	}
+	return buf.String()
+}
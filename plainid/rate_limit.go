@@ -0,0 +1,37 @@
+package plainid
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/time/rate"
+)
+
+// rateLimitTransport holds requests so they are sent to PlainID at most at the configured rate,
+// concurrent backups of workspaces and applications share the limit
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	limiter *rate.Limiter
+}
+
+// newRateLimitTransport limits the requests of base to rps per second, base is returned as is
+// when rps is not positive
+func newRateLimitTransport(base http.RoundTripper, rps float64) http.RoundTripper {
+	if rps <= 0 {
+		return base
+	}
+	// A burst of one spreads the requests evenly over every second
+	return &rateLimitTransport{base: base, limiter: rate.NewLimiter(rate.Limit(rps), 1)}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	if err := t.limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	if held := time.Since(start); held >= time.Millisecond {
+		log.Debug().Str("method", apiMethod(req.Context())).Dur("held", held).Msgf("Request to %s held by the rate limit", req.URL.Path)
+	}
+	return t.base.RoundTrip(req)
+}
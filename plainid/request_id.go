@@ -0,0 +1,87 @@
+package plainid
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestIDHeader carries the request ID to PlainID so client logs can be matched with server access logs
+const RequestIDHeader = "X-Request-ID"
+
+// WithUserAgent sets the User-Agent header of PlainID API calls
+func WithUserAgent(userAgent string) Option {
+	return func(s *Service) {
+		s.userAgent = userAgent
+	}
+}
+
+// WithRequestIDPrefix prefixes the request ID of every PlainID API call, so all the calls of a
+// backup run can be found in the PlainID logs
+func WithRequestIDPrefix(prefix string) Option {
+	return func(s *Service) {
+		s.requestIDPrefix = prefix
+	}
+}
+
+// requestIDTransport tags every PlainID API call with a new request ID and the user agent, and logs it
+type requestIDTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	// prefix is prepended to every request ID, separated by a dash
+	prefix string
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	requestID := uuid.NewString()
+	if t.prefix != "" {
+		requestID = t.prefix + "-" + requestID
+	}
+
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, requestID)
+	if t.userAgent != "" {
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+
+	log.Debug().Str("requestId", requestID).Str("method", req.Method).Str("url", req.URL.String()).Msg("Calling PlainID API")
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		log.Error().Err(err).Str("requestId", requestID).Str("url", req.URL.String()).Msg("PlainID API call failed")
+		return nil, err
+	}
+	// The request carrying the request ID is kept for the error messages, see responseStatus
+	resp.Request = req
+	// Callers expect some error statuses, such as 404 for resources that do not exist, they log
+	// the ones that fail the call with the request ID of responseStatus
+	event := log.Debug().Str("requestId", requestID).Str("url", req.URL.String()).Int("status", resp.StatusCode)
+	if serverID := resp.Header.Get(RequestIDHeader); serverID != "" && serverID != requestID {
+		event = event.Str("serverRequestId", serverID)
+	}
+	event.Msg("PlainID API responded")
+	return resp, nil
+}
+
+// responseStatus returns the status of resp with the request ID it was sent with, and the request
+// ID returned by PlainID if it is a different one, so support can find the call in the server logs
+func responseStatus(resp *http.Response) string {
+	var requestID string
+	if resp.Request != nil {
+		requestID = resp.Request.Header.Get(RequestIDHeader)
+	}
+	serverID := resp.Header.Get(RequestIDHeader)
+
+	switch {
+	case serverID != "" && serverID != requestID && requestID != "":
+		return fmt.Sprintf("%s (request ID %s, server request ID %s)", resp.Status, requestID, serverID)
+	case serverID != "" && requestID == "":
+		return fmt.Sprintf("%s (server request ID %s)", resp.Status, serverID)
+	case requestID != "":
+		return fmt.Sprintf("%s (request ID %s)", resp.Status, requestID)
+	default:
+		return resp.Status
+	}
+}
@@ -0,0 +1,100 @@
+package plainid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/plainid/git-backup/config"
+	"github.com/plainid/git-backup/internal/circuitbreaker"
+	"github.com/rs/zerolog/log"
+)
+
+// doWithRetry sends req with client and retries it with exponential backoff while it fails with
+// 429, a 5xx status or a connection error, up to retry.MaxAttempts attempts. POST requests are only
+// retried on 429, which PlainID answers before processing them: after a 5xx or a lost connection it
+// may have created the resource already. On 429 the Retry-After header is honoured. Retries stop
+// when the context of req is done.
+func doWithRetry(client *http.Client, retry config.RetryConfig, req *http.Request) (*http.Response, error) {
+	delay := retry.InitialDelay
+	if retry.MaxDelay > 0 {
+		delay = min(delay, retry.MaxDelay)
+	}
+	for attempt := 1; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= retry.MaxAttempts || !retryable(req, resp, err) {
+			return resp, err
+		}
+
+		wait := delay
+		var reason string
+		if err != nil {
+			reason = err.Error()
+		} else {
+			reason = resp.Status
+			if after, ok := retryAfter(resp); ok {
+				wait = after
+			}
+			// The connection is only reused once the body is read
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		log.Debug().Str("url", req.URL.String()).Int("attempt", attempt).Dur("delay", wait).
+			Msgf("PlainID request failed (%s), retrying", reason)
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+		delay *= 2
+		if retry.MaxDelay > 0 {
+			delay = min(delay, retry.MaxDelay)
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("failed to rewind request body: %w", err)
+			}
+			req.Body = body
+		}
+	}
+}
+
+// retryable checks if req, which returned resp and err, may succeed when sent again without
+// applying its changes twice
+func retryable(req *http.Request, resp *http.Response, err error) bool {
+	idempotent := req.Method != http.MethodPost && req.Method != http.MethodPatch
+	if err != nil {
+		// An open circuit fails every attempt, cancelled requests must not be sent again
+		return idempotent && !errors.Is(err, circuitbreaker.ErrOpen) && !errors.Is(err, context.Canceled) &&
+			!errors.Is(err, context.DeadlineExceeded)
+	}
+	return resp.StatusCode == http.StatusTooManyRequests || (idempotent && resp.StatusCode >= http.StatusInternalServerError)
+}
+
+// retryAfter returns the delay requested by the Retry-After header of a 429 response,
+// given either in seconds or as an HTTP date
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp.StatusCode != http.StatusTooManyRequests {
+		return 0, false
+	}
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0), true
+	}
+	return 0, false
+}
@@ -0,0 +1,37 @@
+package plainid
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tokenRefreshTransport sits below the OAuth2 transport and logs when the access token
+// sent to PlainID changes, which happens when a long backup outlives the token lifetime.
+type tokenRefreshTransport struct {
+	base http.RoundTripper
+
+	mu            sync.Mutex
+	authorization string
+}
+
+func (t *tokenRefreshTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Token requests share this transport and authenticate with the client credentials instead
+	authorization := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return t.base.RoundTrip(req)
+	}
+
+	t.mu.Lock()
+	previous := t.authorization
+	t.authorization = authorization
+	t.mu.Unlock()
+
+	if previous != "" && authorization != previous {
+		log.Info().Msg("OAuth2 access token refreshed during backup")
+	}
+
+	return t.base.RoundTrip(req)
+}
@@ -0,0 +1,48 @@
+package plainid
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenRefreshTransport(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer server.Close()
+
+	var logs bytes.Buffer
+	logger := log.Logger
+	log.Logger = zerolog.New(&logs)
+	defer func() { log.Logger = logger }()
+
+	transport := &tokenRefreshTransport{base: http.DefaultTransport}
+	send := func(token string) {
+		req, err := http.NewRequest("GET", server.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := transport.RoundTrip(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	send("first")
+	send("first")
+	assert.Empty(t, logs.String(), "first token should not be reported as a refresh")
+
+	req, err := http.NewRequest("GET", server.URL, nil)
+	require.NoError(t, err)
+	req.SetBasicAuth("client-id", "client-secret")
+	resp, err := transport.RoundTrip(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Empty(t, logs.String(), "token requests should be ignored")
+
+	send("second")
+	assert.Contains(t, logs.String(), "OAuth2 access token refreshed during backup")
+}
@@ -0,0 +1,46 @@
+package plainid
+
+import (
+	"context"
+
+	"github.com/plainid/git-backup/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the default OpenTelemetry tracer
+const TracerName = "git-backup"
+
+// Option configures optional Service behaviour
+type Option func(*Service)
+
+// WithTracer sets the OpenTelemetry tracer used for PlainID API spans
+func WithTracer(tracer trace.Tracer) Option {
+	return func(s *Service) {
+		s.tracer = tracer
+	}
+}
+
+// startSpan starts a span for a public Service method and counts the call, the method name is
+// kept in the context for the metrics of its requests
+func (s Service) startSpan(ctx context.Context, method string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	metrics.APICalls.WithLabelValues(method).Inc()
+	ctx = context.WithValue(ctx, apiMethodKey{}, method)
+	return s.tracer.Start(ctx, "plainid."+method, trace.WithAttributes(attrs...))
+}
+
+// endSpan records the error, if any, on the span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// defaultTracer returns the tracer of the globally registered provider
+func defaultTracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
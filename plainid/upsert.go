@@ -0,0 +1,152 @@
+package plainid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ImportApplication restores an application to a workspace, it is UpsertApplication for callers
+// that do not need the ID of the application in PlainID
+func (s Service) ImportApplication(ctx context.Context, envID, wsID string, app Application) error {
+	_, err := s.UpsertApplication(ctx, envID, wsID, app)
+	return err
+}
+
+// UpsertApplication restores an application to a workspace without creating duplicates and returns
+// its ID in PlainID. An application that still exists, by ID or else by name, is updated in place
+// with PUT. Otherwise it was deleted and is created again with POST, PlainID then assigns it a new
+// ID, which policies of the application must be imported into.
+func (s Service) UpsertApplication(ctx context.Context, envID, wsID string, app Application) (appID string, err error) {
+	ctx, span := s.startSpan(ctx, "UpsertApplication", attribute.String("env_id", envID), attribute.String("ws_id", wsID), attribute.String("app_id", app.ID))
+	defer func() { endSpan(span, err) }()
+
+	exists, err := s.applicationExists(ctx, envID, app.ID)
+	if err != nil {
+		return "", err
+	}
+
+	existingID := app.ID
+	if !exists {
+		// The application may have been recreated under another ID since the backup
+		existingID = ""
+		apps, err := s.Applications(ctx, envID, wsID)
+		if err != nil {
+			return "", fmt.Errorf("failed to look up application %s by name: %w", app.Name, err)
+		}
+		for _, existing := range apps {
+			if existing.Name == app.Name {
+				existingID = existing.ID
+				break
+			}
+		}
+	}
+
+	// The workspace is not part of the application JSON, it is sent along for creation
+	type applicationRequest struct {
+		Application
+		WSID string `json:"authWsId"`
+	}
+
+	if existingID != "" {
+		if existingID != app.ID {
+			log.Info().Str("appId", app.ID).Str("existingAppId", existingID).Msgf("Application %s found under another ID, updating it", app.Name)
+		}
+		app.ID = existingID
+		baseURL := fmt.Sprintf("%s/api/1.0/applications/%s/%s", s.cfg.PlainID.BaseURL, envID, existingID)
+		if _, err := s.sendJSON(ctx, http.MethodPut, baseURL, applicationRequest{app, wsID}); err != nil {
+			return "", fmt.Errorf("failed to update application %s: %w", existingID, err)
+		}
+		return existingID, nil
+	}
+
+	// PlainID assigns the ID of created applications
+	backupID := app.ID
+	app.ID = ""
+	baseURL := fmt.Sprintf("%s/api/1.0/applications/%s", s.cfg.PlainID.BaseURL, envID)
+	body, err := s.sendJSON(ctx, http.MethodPost, baseURL, applicationRequest{app, wsID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create application %s: %w", app.Name, err)
+	}
+
+	type AppResponse struct {
+		Data Application `json:"data"`
+	}
+	var appResponse AppResponse
+	if err := json.Unmarshal(body, &appResponse); err != nil {
+		return "", fmt.Errorf("failed to parse created application response: %w", err)
+	}
+	if appResponse.Data.ID == "" {
+		return "", fmt.Errorf("created application %s has no ID in the response", app.Name)
+	}
+	log.Info().Str("appId", backupID).Str("newAppId", appResponse.Data.ID).Msgf("Application %s was deleted, recreated it with a new ID", app.Name)
+	return appResponse.Data.ID, nil
+}
+
+// applicationExists checks if an application with the given ID exists in the environment
+func (s Service) applicationExists(ctx context.Context, envID, appID string) (bool, error) {
+	if appID == "" {
+		return false, nil
+	}
+
+	baseURL := fmt.Sprintf("%s/api/1.0/applications/%s/%s", s.cfg.PlainID.BaseURL, envID, appID)
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), http.MethodGet, baseURL, nil)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		body, err := readBody(resp.Body)
+		if err != nil {
+			return false, err
+		}
+		return false, fmt.Errorf("failed to look up application %s: %s %s", appID, responseStatus(resp), body)
+	}
+}
+
+// sendJSON sends payload as JSON with the given method and returns the response body of a successful request
+func (s Service) sendJSON(ctx context.Context, method, baseURL string, payload any) ([]byte, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, baseURL, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s %s: %s %s", method, baseURL, responseStatus(resp), body)
+	}
+	return body, nil
+}
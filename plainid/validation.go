@@ -0,0 +1,74 @@
+package plainid
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ValidationError reports the required fields that are missing on a PlainID resource
+type ValidationError struct {
+	Resource      string
+	ID            string
+	MissingFields []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid %s %q: missing required fields: %s", e.Resource, e.ID, strings.Join(e.MissingFields, ", "))
+}
+
+// Validate checks that the PAA group source has all required fields
+func (s PAAGroupSource) Validate() error {
+	var missingFields []string
+
+	if s.ID == "" {
+		missingFields = append(missingFields, "sourceId")
+	}
+	if s.Name == "" {
+		missingFields = append(missingFields, "name")
+	}
+	if s.Adapter == "" {
+		missingFields = append(missingFields, "adapter")
+	}
+	if len(s.Properties) == 0 {
+		missingFields = append(missingFields, "properties")
+	}
+
+	if len(missingFields) > 0 {
+		return &ValidationError{Resource: "PAA group source", ID: s.ID, MissingFields: missingFields}
+	}
+	return nil
+}
+
+// Validate checks that the PAA group has all required fields
+func (p PAAGroup) Validate() error {
+	var missingFields []string
+
+	if p.ID == "" {
+		missingFields = append(missingFields, "id")
+	}
+	if p.PAAGroupType == "" {
+		missingFields = append(missingFields, "paaGroupType")
+	}
+
+	if len(missingFields) > 0 {
+		return &ValidationError{Resource: "PAA group", ID: p.ID, MissingFields: missingFields}
+	}
+	return nil
+}
+
+// Validate checks that the application has all required fields
+func (s Application) Validate() error {
+	var missingFields []string
+
+	if s.ID == "" {
+		missingFields = append(missingFields, "applicationId")
+	}
+	if s.Name == "" {
+		missingFields = append(missingFields, "displayName")
+	}
+
+	if len(missingFields) > 0 {
+		return &ValidationError{Resource: "application", ID: s.ID, MissingFields: missingFields}
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package plainid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// WsAPIMapper returns the API mapper set shared by the applications of a workspace.
+// The endpoint is not part of every PlainID release: instances without it (404), like workspaces
+// without a mapper, return an empty string.
+func (s Service) WsAPIMapper(ctx context.Context, envID, wsID string) (mapper string, err error) {
+	ctx, span := s.startSpan(ctx, "WsAPIMapper", attribute.String("env_id", envID), attribute.String("ws_id", wsID))
+	defer func() { endSpan(span, err) }()
+
+	baseURL := fmt.Sprintf("%s/api/1.0/api-mapper-sets/%s/workspace/%s", s.cfg.PlainID.BaseURL, envID, wsID)
+
+	req, err := http.NewRequestWithContext(expectStatus(ctx, http.StatusNotFound), "GET", baseURL, nil)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := doWithRetry(s.client, s.cfg.Retry, req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := readBody(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		log.Debug().Msgf("No workspace API mapper found for workspace %s, skipping", wsID)
+		return "", nil
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download workspace api mapper for %s: %s %s", wsID, responseStatus(resp), body)
+	}
+
+	return string(body), nil
+}
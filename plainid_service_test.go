@@ -1,6 +1,7 @@
 package main_test
 
 import (
+	"context"
 	"testing"
 
 	"github.com/plainid/git-backup/config"
@@ -30,7 +31,7 @@ func (s *PlainIDServiceTestSuite) TestPAAGroups() {
 	service := plainid.NewService(s.cfg)
 
 	// Call PAAGroups method
-	result, err := service.PAAGroups(s.cfg.PlainID.Envs[0].ID)
+	result, err := service.PAAGroups(context.Background(), s.cfg.PlainID.Envs[0].ID)
 	s.Require().NoError(err, "PAAGroups should not return an error")
 	s.Assert().NotEmpty(result, "PAAGroups should return non-empty result")
 
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/plainid/git-backup/config"
+)
+
+// defaultSSHUser is the SSH user of repository URLs that do not name one
+const defaultSSHUser = "git"
+
+// NewAuth returns the git authentication method matching the git configuration
+func NewAuth(gitCfg config.GitConfig) (transport.AuthMethod, error) {
+	if gitCfg.UsesGitHubApp() {
+		return NewGitHubAppAuth(gitCfg.GitHubAppID, gitCfg.GitHubInstallationID, gitCfg.GitHubPrivateKeyPath, gitCfg.SOCKSProxy)
+	}
+
+	// The SSH key only applies to SSH URLs, HTTPS repositories keep using the token
+	if gitCfg.UsesSSHKey() {
+		auth, err := ssh.NewPublicKeysFromFile(sshUser(gitCfg.Repo), gitCfg.SSHKeyPath, gitCfg.SSHKeyPassphrase)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load SSH key %s: %w", gitCfg.SSHKeyPath, err)
+		}
+		return auth, nil
+	}
+
+	if gitCfg.AuthMethod == config.GitAuthMethodBearer {
+		return &http.TokenAuth{Token: gitCfg.Token}, nil
+	}
+
+	username := gitCfg.AuthUsername
+	if username == "" {
+		username = config.DefaultGitAuthUsername
+	}
+
+	return &http.BasicAuth{
+		Username: username,
+		Password: gitCfg.Token,
+	}, nil
+}
+
+// sshUser returns the user of an SSH repository URL, ssh://user@host/path or user@host:path
+func sshUser(repoURL string) string {
+	if strings.HasPrefix(repoURL, "ssh://") {
+		if u, err := url.Parse(repoURL); err == nil && u.User != nil && u.User.Username() != "" {
+			return u.User.Username()
+		}
+		return defaultSSHUser
+	}
+	if user, _, ok := strings.Cut(repoURL, "@"); ok && user != "" {
+		return user
+	}
+	return defaultSSHUser
+}
@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	nethttp "net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/plainid/git-backup/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewAuthSSHKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	keyPath := filepath.Join(t.TempDir(), "id_rsa")
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	require.NoError(t, os.WriteFile(keyPath, keyPEM, 0600))
+
+	tests := []struct {
+		repo string
+		user string
+	}{
+		{repo: "git@gitlab.example.com:org/repo.git", user: "git"},
+		{repo: "ssh://backup@gitea.example.com:2222/org/repo.git", user: "backup"},
+		{repo: "ssh://gitea.example.com/org/repo.git", user: "git"},
+	}
+	for _, tt := range tests {
+		auth, err := NewAuth(config.GitConfig{Repo: tt.repo, SSHKeyPath: keyPath})
+		require.NoError(t, err, tt.repo)
+		require.IsType(t, &ssh.PublicKeys{}, auth, tt.repo)
+		assert.Equal(t, tt.user, auth.(*ssh.PublicKeys).User, tt.repo)
+	}
+
+	// HTTPS repositories keep using the token
+	auth, err := NewAuth(config.GitConfig{Repo: "https://gitlab.example.com/org/repo.git", Token: "token", SSHKeyPath: keyPath})
+	require.NoError(t, err)
+	assert.Equal(t, &http.BasicAuth{Username: config.DefaultGitAuthUsername, Password: "token"}, auth)
+
+	_, err = NewAuth(config.GitConfig{Repo: "git@gitlab.example.com:org/repo.git", SSHKeyPath: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "failed to load SSH key")
+}
+
+func TestNewAuthBearer(t *testing.T) {
+	gitCfg := config.GitConfig{Repo: "https://github.com/org/repo.git", Token: "token", AuthMethod: config.GitAuthMethodBearer}
+	auth, err := NewAuth(gitCfg)
+	require.NoError(t, err)
+	assert.Equal(t, &http.TokenAuth{Token: "token"}, auth)
+
+	req, err := nethttp.NewRequest(nethttp.MethodGet, gitCfg.Repo, nil)
+	require.NoError(t, err)
+	auth.(http.AuthMethod).SetAuth(req)
+	assert.Equal(t, "Bearer token", req.Header.Get("Authorization"))
+
+	gitCfg.AuthMethod = config.GitAuthMethodBasic
+	auth, err = NewAuth(gitCfg)
+	require.NoError(t, err)
+	assert.Equal(t, &http.BasicAuth{Username: config.DefaultGitAuthUsername, Password: "token"}, auth)
+}
@@ -0,0 +1,148 @@
+package repository
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/packfile"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// bundleSignature is the header of a v2 git bundle
+const bundleSignature = "# v2 git bundle\n"
+
+// CloneMirror fetches all references of the remote repository into a new bare repository at localPath,
+// with HEAD pointing to branchName. Unlike a clone it does not depend on the remote HEAD.
+// The remote is reached through the SOCKS5 proxy at proxyAddr if set.
+func CloneMirror(remoteURL, branchName string, auth transport.AuthMethod, localPath, proxyAddr string) (*git.Repository, error) {
+	repo, err := git.PlainInit(localPath, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize mirror repository: %w", err)
+	}
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{remoteURL},
+		Fetch: []config.RefSpec{"+refs/*:refs/*"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create remote: %w", err)
+	}
+
+	err = repo.Fetch(&git.FetchOptions{
+		Auth:         auth,
+		Tags:         git.NoTags,
+		ProxyOptions: ProxyOptions(proxyAddr),
+	})
+	if err != nil && !errors.Is(err, git.NoErrAlreadyUpToDate) {
+		return nil, fmt.Errorf("failed to mirror repository: %w", err)
+	}
+
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branchName))
+	if err := repo.Storer.SetReference(head); err != nil {
+		return nil, fmt.Errorf("failed to set mirror HEAD: %w", err)
+	}
+	return repo, nil
+}
+
+// CreateBundle writes all branches and tags of the repository to a git bundle file,
+// the equivalent of `git bundle create <outputPath> --all`.
+// The bundle can be restored with `git clone <outputPath>`; it is only self-contained
+// if the repository is not a shallow clone.
+func CreateBundle(repo *git.Repository, outputPath string) (err error) {
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+
+	var bundleRefs []*plumbing.Reference
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() == plumbing.HashReference && (ref.Name().IsBranch() || ref.Name().IsTag() || ref.Name().IsNote()) {
+			bundleRefs = append(bundleRefs, ref)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list references: %w", err)
+	}
+	if len(bundleRefs) == 0 {
+		return errors.New("failed to create bundle: repository has no branches or tags")
+	}
+
+	// Parents cut off by a shallow clone are prerequisites the bundle does not contain
+	shallows, err := repo.Storer.Shallow()
+	if err != nil {
+		return fmt.Errorf("failed to read shallow commits: %w", err)
+	}
+	var prerequisites []*object.Commit
+	for _, shallow := range shallows {
+		commit, err := repo.CommitObject(shallow)
+		if err != nil {
+			return fmt.Errorf("failed to read shallow commit %s: %w", shallow, err)
+		}
+		prerequisites = append(prerequisites, commit)
+	}
+
+	var hashes []plumbing.Hash
+	objects, err := repo.Storer.IterEncodedObjects(plumbing.AnyObject)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	err = objects.ForEach(func(obj plumbing.EncodedObject) error {
+		hashes = append(hashes, obj.Hash())
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	file, err := os.Create(outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle file: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("failed to close bundle file: %w", closeErr)
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	if _, err := w.WriteString(bundleSignature); err != nil {
+		return fmt.Errorf("failed to write bundle header: %w", err)
+	}
+	for _, commit := range prerequisites {
+		for _, parent := range commit.ParentHashes {
+			if _, err := fmt.Fprintf(w, "-%s\n", parent); err != nil {
+				return fmt.Errorf("failed to write bundle header: %w", err)
+			}
+		}
+	}
+	if head, err := repo.Head(); err == nil {
+		if _, err := fmt.Fprintf(w, "%s %s\n", head.Hash(), plumbing.HEAD); err != nil {
+			return fmt.Errorf("failed to write bundle header: %w", err)
+		}
+	}
+	for _, ref := range bundleRefs {
+		if _, err := fmt.Fprintf(w, "%s %s\n", ref.Hash(), ref.Name()); err != nil {
+			return fmt.Errorf("failed to write bundle header: %w", err)
+		}
+	}
+	if _, err := w.WriteString("\n"); err != nil {
+		return fmt.Errorf("failed to write bundle header: %w", err)
+	}
+
+	if _, err := packfile.NewEncoder(w, repo.Storer, false).Encode(hashes, 10); err != nil {
+		return fmt.Errorf("failed to write bundle packfile: %w", err)
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write bundle file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package repository
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	nethttp "net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/rs/zerolog/log"
+)
+
+// GitHubAPIURL is the GitHub API used to create installation tokens
+const GitHubAPIURL = "https://api.github.com"
+
+// gitHubTokenRefreshMargin is how long before expiry an installation token is refreshed.
+// Installation tokens are valid for 60 minutes.
+const gitHubTokenRefreshMargin = 5 * time.Minute
+
+// gitHubAPITimeout bounds an installation token request, so a GitHub outage cannot hang git operations
+const gitHubAPITimeout = 30 * time.Second
+
+// gitHubAppAuth authenticates git operations with a GitHub App installation token,
+// refreshing the token shortly before it expires
+type gitHubAppAuth struct {
+	appID          int64
+	installationID int64
+	privateKey     *rsa.PrivateKey
+	apiURL         string
+	client         *nethttp.Client
+
+	mu        sync.Mutex
+	auth      *http.BasicAuth
+	expiresAt time.Time
+}
+
+// NewGitHubAppAuth creates git authentication backed by GitHub App installation tokens. The tokens
+// are requested through the SOCKS5 proxy at proxyAddr, like git operations, unless it is empty.
+func NewGitHubAppAuth(appID, installationID int64, privateKeyPath, proxyAddr string) (transport.AuthMethod, error) {
+	keyPEM, err := os.ReadFile(privateKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GitHub App private key: %w", err)
+	}
+
+	privateKey, err := parseRSAPrivateKey(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := gitHubAPIClient(proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+	return newGitHubAppAuth(appID, installationID, privateKey, GitHubAPIURL, client)
+}
+
+// newGitHubAppAuth creates the GitHub App authentication requesting tokens from the API at apiURL
+func newGitHubAppAuth(appID, installationID int64, privateKey *rsa.PrivateKey, apiURL string, client *nethttp.Client) (*gitHubAppAuth, error) {
+	a := &gitHubAppAuth{
+		appID:          appID,
+		installationID: installationID,
+		privateKey:     privateKey,
+		apiURL:         apiURL,
+		client:         client,
+	}
+
+	// Fetch the first token eagerly so configuration problems surface immediately
+	if _, err := a.basicAuth(); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (a *gitHubAppAuth) Name() string {
+	return "github-app"
+}
+
+func (a *gitHubAppAuth) String() string {
+	return fmt.Sprintf("%s - app:%d installation:%d", a.Name(), a.appID, a.installationID)
+}
+
+// SetAuth sets the installation token on the request, refreshing it if needed
+func (a *gitHubAppAuth) SetAuth(r *nethttp.Request) {
+	auth, err := a.basicAuth()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to refresh GitHub App installation token")
+		if auth == nil {
+			return
+		}
+	}
+	auth.SetAuth(r)
+}
+
+// basicAuth returns the cached installation token credentials, refreshing them before expiry.
+// On refresh failure the previous credentials are returned alongside the error.
+func (a *gitHubAppAuth) basicAuth() (*http.BasicAuth, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.auth != nil && time.Until(a.expiresAt) > gitHubTokenRefreshMargin {
+		return a.auth, nil
+	}
+
+	token, expiresAt, err := a.installationToken()
+	if err != nil {
+		return a.auth, err
+	}
+
+	log.Debug().Time("expiresAt", expiresAt).Msg("Obtained GitHub App installation token")
+	a.auth = &http.BasicAuth{
+		Username: "x-access-token",
+		Password: token,
+	}
+	a.expiresAt = expiresAt
+	return a.auth, nil
+}
+
+// installationToken exchanges a signed app JWT for an installation access token
+func (a *gitHubAppAuth) installationToken() (string, time.Time, error) {
+	jwt, err := a.signJWT(time.Now())
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	tokenURL := fmt.Sprintf("%s/app/installations/%d/access_tokens", a.apiURL, a.installationID)
+	ctx, cancel := context.WithTimeout(context.Background(), gitHubAPITimeout)
+	defer cancel()
+	req, err := nethttp.NewRequestWithContext(ctx, nethttp.MethodPost, tokenURL, nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+jwt)
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to request GitHub App installation token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	if resp.StatusCode != nethttp.StatusCreated {
+		return "", time.Time{}, fmt.Errorf("failed to create GitHub App installation token: %s %s", resp.Status, body)
+	}
+
+	var tokenResp struct {
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
+	}
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to parse GitHub App installation token response: %w", err)
+	}
+
+	return tokenResp.Token, tokenResp.ExpiresAt, nil
+}
+
+// gitHubAPIClient returns the client of the GitHub API, connecting through the SOCKS5 proxy at
+// proxyAddr when it is set
+func gitHubAPIClient(proxyAddr string) (*nethttp.Client, error) {
+	httpTransport := nethttp.DefaultTransport.(*nethttp.Transport).Clone()
+	if proxy := ProxyOptions(proxyAddr); proxy.URL != "" {
+		proxyURL, err := url.Parse(proxy.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid git.socks-proxy %s: %w", proxyAddr, err)
+		}
+		httpTransport.Proxy = nethttp.ProxyURL(proxyURL)
+	}
+	return &nethttp.Client{Transport: httpTransport, Timeout: gitHubAPITimeout}, nil
+}
+
+// signJWT creates the RS256 signed JWT identifying the GitHub App
+func (a *gitHubAppAuth) signJWT(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+
+	claims, err := json.Marshal(map[string]any{
+		// Backdate to allow for clock drift, as recommended by GitHub
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": strconv.FormatInt(a.appID, 10),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, a.privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign GitHub App JWT: %w", err)
+	}
+
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// parseRSAPrivateKey parses a PEM encoded PKCS#1 or PKCS#8 RSA private key
+func parseRSAPrivateKey(keyPEM []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("failed to decode GitHub App private key: no PEM data found")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse GitHub App private key: %w", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("GitHub App private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHubAPI answers installation token requests of installation 42 signed by key, with tokens
+// numbered from 1 expiring after validity
+func fakeGitHubAPI(t *testing.T, key *rsa.PrivateKey, validity time.Duration) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		if r.Method != nethttp.MethodPost || r.URL.Path != "/app/installations/42/access_tokens" {
+			nethttp.NotFound(w, r)
+			return
+		}
+
+		// The JWT must be signed with the app key and issued by the app
+		parts := strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), ".")
+		require.Len(t, parts, 3)
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(t, err)
+		if rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature) != nil {
+			nethttp.Error(w, "bad signature", nethttp.StatusUnauthorized)
+			return
+		}
+		claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+		require.NoError(t, err)
+		assert.Contains(t, string(claims), `"iss":"7"`)
+
+		n := requests.Add(1)
+		w.WriteHeader(nethttp.StatusCreated)
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"token":      fmt.Sprintf("token-%d", n),
+			"expires_at": time.Now().Add(validity).UTC().Format(time.RFC3339),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestGitHubAppAuth(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server, requests := fakeGitHubAPI(t, key, time.Hour)
+
+	auth, err := newGitHubAppAuth(7, 42, key, server.URL, server.Client())
+	require.NoError(t, err)
+	assert.EqualValues(t, 1, requests.Load(), "the first token should be fetched eagerly")
+
+	req, err := nethttp.NewRequest(nethttp.MethodGet, "https://github.com/org/repo.git", nil)
+	require.NoError(t, err)
+	auth.SetAuth(req)
+	user, password, ok := req.BasicAuth()
+	require.True(t, ok)
+	assert.Equal(t, "x-access-token", user)
+	assert.Equal(t, "token-1", password)
+	assert.EqualValues(t, 1, requests.Load(), "a valid token should be reused")
+
+	// A token about to expire is refreshed
+	auth.expiresAt = time.Now().Add(gitHubTokenRefreshMargin - time.Second)
+	auth.SetAuth(req)
+	_, password, _ = req.BasicAuth()
+	assert.Equal(t, "token-2", password)
+	assert.EqualValues(t, 2, requests.Load())
+}
+
+func TestGitHubAppAuthRefreshFailure(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server, _ := fakeGitHubAPI(t, key, time.Hour)
+
+	auth, err := newGitHubAppAuth(7, 42, key, server.URL, server.Client())
+	require.NoError(t, err)
+
+	// The previous token is kept when the refresh fails
+	server.Close()
+	auth.expiresAt = time.Now()
+	req, err := nethttp.NewRequest(nethttp.MethodGet, "https://github.com/org/repo.git", nil)
+	require.NoError(t, err)
+	auth.SetAuth(req)
+	_, password, _ := req.BasicAuth()
+	assert.Equal(t, "token-1", password)
+
+	// Another key is rejected
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	server, _ = fakeGitHubAPI(t, key, time.Hour)
+	_, err = newGitHubAppAuth(7, 42, other, server.URL, server.Client())
+	assert.ErrorContains(t, err, "401 Unauthorized")
+}
+
+func TestGitHubAPIClient(t *testing.T) {
+	client, err := gitHubAPIClient("")
+	require.NoError(t, err)
+	assert.Equal(t, gitHubAPITimeout, client.Timeout)
+
+	client, err = gitHubAPIClient("proxy.example.com:1080")
+	require.NoError(t, err)
+	req, err := nethttp.NewRequest(nethttp.MethodPost, GitHubAPIURL, nil)
+	require.NoError(t, err)
+	proxyURL, err := client.Transport.(*nethttp.Transport).Proxy(req)
+	require.NoError(t, err)
+	assert.Equal(t, "socks5://proxy.example.com:1080", proxyURL.String())
+}
+
+func TestParseRSAPrivateKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	parsed, err := parseRSAPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed), "PKCS#1")
+
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	parsed, err = parseRSAPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	require.NoError(t, err)
+	assert.True(t, key.Equal(parsed), "PKCS#8")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pkcs8, err = x509.MarshalPKCS8PrivateKey(ecKey)
+	require.NoError(t, err)
+	_, err = parseRSAPrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8}))
+	assert.ErrorContains(t, err, "not an RSA key")
+
+	_, err = parseRSAPrivateKey([]byte("not a key"))
+	assert.ErrorContains(t, err, "no PEM data found")
+
+	_, err = NewGitHubAppAuth(7, 42, filepath.Join(t.TempDir(), "missing.pem"), "")
+	assert.ErrorContains(t, err, "failed to read GitHub App private key")
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// NotesRef is the default git notes reference, as shown by `git log --notes`
+const NotesRef = plumbing.ReferenceName("refs/notes/commits")
+
+// NotesRefSpec pushes and fetches the notes reference
+var NotesRefSpec = config.RefSpec(fmt.Sprintf("+%s:%s", NotesRef, NotesRef))
+
+// FetchNotes fetches the notes reference from origin so new notes extend the existing history.
+// A remote without notes is not an error.
+func FetchNotes(repo *git.Repository, auth transport.AuthMethod, proxyAddr string) error {
+	err := repo.Fetch(&git.FetchOptions{
+		Auth:         auth,
+		RefSpecs:     []config.RefSpec{NotesRefSpec},
+		ProxyOptions: ProxyOptions(proxyAddr),
+	})
+	if err == nil ||
+		errors.Is(err, git.NoErrAlreadyUpToDate) ||
+		errors.Is(err, transport.ErrEmptyRemoteRepository) ||
+		errors.Is(err, git.NoMatchingRefSpecError{}) {
+		return nil
+	}
+	return fmt.Errorf("failed to fetch notes: %w", err)
+}
+
+// AddNote attaches a note to the target commit, replacing any previous note on it.
+// It is the equivalent of `git notes add -f`.
+func AddNote(repo *git.Repository, target plumbing.Hash, note string, sig object.Signature) error {
+	var parents []plumbing.Hash
+	var entries []object.TreeEntry
+
+	// Extend the existing notes history if there is one
+	ref, err := repo.Reference(NotesRef, true)
+	switch {
+	case err == nil:
+		notesCommit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to read notes commit: %w", err)
+		}
+		notesTree, err := notesCommit.Tree()
+		if err != nil {
+			return fmt.Errorf("failed to read notes tree: %w", err)
+		}
+		for _, entry := range notesTree.Entries {
+			if entry.Name != target.String() {
+				entries = append(entries, entry)
+			}
+		}
+		parents = append(parents, notesCommit.Hash)
+	case !errors.Is(err, plumbing.ErrReferenceNotFound):
+		return fmt.Errorf("failed to read notes reference: %w", err)
+	}
+
+	blob := repo.Storer.NewEncodedObject()
+	blob.SetType(plumbing.BlobObject)
+	w, err := blob.Writer()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write([]byte(note)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	blobHash, err := repo.Storer.SetEncodedObject(blob)
+	if err != nil {
+		return fmt.Errorf("failed to store note: %w", err)
+	}
+
+	// Notes are stored as blobs named after the annotated commit
+	entries = append(entries, object.TreeEntry{Name: target.String(), Mode: filemode.Regular, Hash: blobHash})
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name < entries[j].Name
+	})
+
+	treeHash, err := storeObject(repo, &object.Tree{Entries: entries})
+	if err != nil {
+		return fmt.Errorf("failed to store notes tree: %w", err)
+	}
+
+	commitHash, err := storeObject(repo, &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      fmt.Sprintf("Notes added for %s", target),
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store notes commit: %w", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(NotesRef, commitHash)); err != nil {
+		return fmt.Errorf("failed to update notes reference: %w", err)
+	}
+	return nil
+}
+
+// storeObject encodes the object into the repository storage
+func storeObject(repo *git.Repository, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	encoded := repo.Storer.NewEncodedObject()
+	if err := obj.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(encoded)
+}
@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// ProxyOptions returns the options to reach the remote through a SOCKS5 proxy at proxyAddr
+// (host:port or socks5://host:port). No proxy is used when proxyAddr is empty.
+func ProxyOptions(proxyAddr string) transport.ProxyOptions {
+	if proxyAddr == "" {
+		return transport.ProxyOptions{}
+	}
+	if !strings.Contains(proxyAddr, "://") {
+		proxyAddr = "socks5://" + proxyAddr
+	}
+	return transport.ProxyOptions{URL: proxyAddr}
+}
+
+// CloneRemoteViaProxy is CloneRemote connecting to the remote through a SOCKS5 proxy,
+// for environments where direct outbound connections to the git host are blocked
+func CloneRemoteViaProxy(remoteURL, branchName string, auth transport.AuthMethod, localPath, proxyAddr string, depth int) (*git.Repository, error) {
+	return cloneRemote(remoteURL, branchName, auth, localPath, depth, ProxyOptions(proxyAddr))
+}
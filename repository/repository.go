@@ -9,16 +9,18 @@ import (
 	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/transport"
-	"github.com/go-git/go-git/v5/plumbing/transport/http" // For HTTPS authentication
+	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/rs/zerolog/log"
 )
 
-// CreateTempDir creates a temporary directory for git operations
-func CreateTempDir() (string, error) {
-	tempDir, err := os.MkdirTemp("", "git-backup-*")
+// CreateTempDir creates a temporary directory for git operations in parent,
+// or in the default directory for temporary files ($TMPDIR on Unix) if parent is empty
+func CreateTempDir(parent string) (string, error) {
+	tempDir, err := os.MkdirTemp(parent, "git-backup-*")
 	if err != nil {
 		return "", fmt.Errorf("failed to create temporary directory: %w", err)
 	}
+	log.Debug().Str("path", tempDir).Msg("Temporary directory created")
 	return tempDir, nil
 }
 
@@ -31,7 +33,14 @@ func CleanupTempDir(path string) {
 	}
 }
 
-func CloneRemote(remoteURL, branchName, token, localPath string) (*git.Repository, error) {
+// CloneRemote clones the branch of the remote repository into localPath,
+// initializing a new repository if the remote is empty.
+// Only the last depth commits are fetched, the full history if depth is 0.
+func CloneRemote(remoteURL, branchName string, auth transport.AuthMethod, localPath string, depth int) (*git.Repository, error) {
+	return cloneRemote(remoteURL, branchName, auth, localPath, depth, transport.ProxyOptions{})
+}
+
+func cloneRemote(remoteURL, branchName string, auth transport.AuthMethod, localPath string, depth int, proxy transport.ProxyOptions) (*git.Repository, error) {
 	// First, check if repository already exists locally
 	repo, err := git.PlainOpen(localPath)
 	if err == nil {
@@ -43,13 +52,11 @@ func CloneRemote(remoteURL, branchName, token, localPath string) (*git.Repositor
 	repo, err = git.PlainClone(localPath, false, &git.CloneOptions{
 		URL:           remoteURL,
 		SingleBranch:  true,
-		Depth:         1,
+		Depth:         depth,
 		ReferenceName: plumbing.ReferenceName(fmt.Sprintf("refs/heads/%s", branchName)),
 		Progress:      log.Logger,
-		Auth: &http.BasicAuth{
-			Username: "oauth2",
-			Password: token,
-		},
+		Auth:          auth,
+		ProxyOptions:  proxy,
 	})
 
 	if err != nil {
@@ -58,7 +65,7 @@ func CloneRemote(remoteURL, branchName, token, localPath string) (*git.Repositor
 		if errors.Is(err, transport.ErrEmptyRemoteRepository) ||
 			errors.Is(err, plumbing.ErrReferenceNotFound) {
 			log.Info().Msg("Empty or new repository, initializing it")
-			return initializeRepository(remoteURL, branchName, token, localPath)
+			return initializeRepository(remoteURL, branchName, localPath)
 		}
 		return nil, fmt.Errorf("failed to clone repository: %w", err)
 	}
@@ -66,8 +73,8 @@ func CloneRemote(remoteURL, branchName, token, localPath string) (*git.Repositor
 	return repo, nil
 }
 
-// initializeRepository creates a new local repository and sets up the remote
-func initializeRepository(remoteURL, branchName, token, localPath string) (*git.Repository, error) {
+// initializeRepository creates a new local repository on branchName and sets up the remote
+func initializeRepository(remoteURL, branchName, localPath string) (*git.Repository, error) {
 	// Make sure directory exists
 	if err := os.MkdirAll(localPath, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create directory: %w", err)
@@ -79,6 +86,12 @@ func initializeRepository(remoteURL, branchName, token, localPath string) (*git.
 		return nil, fmt.Errorf("failed to initialize repository: %w", err)
 	}
 
+	// Point HEAD to the configured branch instead of git's default, like `git init -b <branch>`
+	head := plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName(branchName))
+	if err := repo.Storer.SetReference(head); err != nil {
+		return nil, fmt.Errorf("failed to set default branch: %w", err)
+	}
+
 	_, err = repo.CreateRemote(&config.RemoteConfig{
 		Name: "origin",
 		URLs: []string{remoteURL},
@@ -90,3 +103,23 @@ func initializeRepository(remoteURL, branchName, token, localPath string) (*git.
 	log.Info().Msgf("Initialized new repository with remote: %s", remoteURL)
 	return repo, nil
 }
+
+// ListRemote lists the references of the remote repository without fetching anything, like
+// git ls-remote. An empty remote repository has no references, which is not an error.
+func ListRemote(remoteURL string, auth transport.AuthMethod, proxyAddr string) ([]*plumbing.Reference, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{
+		Name: git.DefaultRemoteName,
+		URLs: []string{remoteURL},
+	})
+	refs, err := remote.List(&git.ListOptions{
+		Auth:         auth,
+		ProxyOptions: ProxyOptions(proxyAddr),
+	})
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote references: %w", err)
+	}
+	return refs, nil
+}
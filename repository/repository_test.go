@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloneRemoteEmptyUsesConfiguredBranch(t *testing.T) {
+	remoteDir := t.TempDir()
+	_, err := git.PlainInit(remoteDir, true)
+	require.NoError(t, err)
+
+	repo, err := CloneRemote(remoteDir, "main", nil, filepath.Join(t.TempDir(), "clone"), 1)
+	require.NoError(t, err)
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	require.NoError(t, err)
+	assert.Equal(t, plumbing.NewBranchReferenceName("main"), head.Target())
+}
+
+func TestCloneRemoteFullHistory(t *testing.T) {
+	remoteDir := t.TempDir()
+	remote, err := git.PlainInit(remoteDir, false)
+	require.NoError(t, err)
+	require.NoError(t, remote.Storer.SetReference(
+		plumbing.NewSymbolicReference(plumbing.HEAD, plumbing.NewBranchReferenceName("main"))))
+	wt, err := remote.Worktree()
+	require.NoError(t, err)
+	for _, msg := range []string{"first backup", "second backup"} {
+		_, err := wt.Commit(msg, &git.CommitOptions{
+			AllowEmptyCommits: true,
+			Author:            &object.Signature{Name: "test", Email: "test@example.com"},
+		})
+		require.NoError(t, err)
+	}
+
+	repo, err := CloneRemote(remoteDir, "main", nil, filepath.Join(t.TempDir(), "clone"), 0)
+	require.NoError(t, err)
+
+	commits, err := repo.Log(&git.LogOptions{})
+	require.NoError(t, err)
+	count := 0
+	require.NoError(t, commits.ForEach(func(*object.Commit) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 2, count, "depth 0 should clone the full history")
+}
+
+func TestCreateTempDirInParent(t *testing.T) {
+	parent := t.TempDir()
+	tempDir, err := CreateTempDir(parent)
+	require.NoError(t, err)
+	assert.Equal(t, parent, filepath.Dir(tempDir))
+	assert.DirExists(t, tempDir)
+}
+
+func TestProxyOptions(t *testing.T) {
+	assert.Equal(t, "", ProxyOptions("").URL)
+	assert.Equal(t, "socks5://proxy:1080", ProxyOptions("proxy:1080").URL)
+	assert.Equal(t, "socks5h://proxy:1080", ProxyOptions("socks5h://proxy:1080").URL)
+}
+
+func TestLatestSemVerTag(t *testing.T) {
+	repo, err := git.PlainInit(t.TempDir(), false)
+	require.NoError(t, err)
+
+	latest, err := LatestSemVerTag(repo)
+	require.NoError(t, err)
+	assert.Equal(t, "v0.0.0", latest.String())
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+	commit, err := wt.Commit("backup", &git.CommitOptions{
+		AllowEmptyCommits: true,
+		Author:            &object.Signature{Name: "test", Email: "test@example.com"},
+	})
+	require.NoError(t, err)
+	for _, tag := range []string{"v1.2.3", "v1.10.0", "v2.0.0-rc1", "20240101-120000"} {
+		_, err := repo.CreateTag(tag, commit, nil)
+		require.NoError(t, err)
+	}
+
+	latest, err = LatestSemVerTag(repo)
+	require.NoError(t, err)
+	assert.Equal(t, SemVer{Major: 1, Minor: 10}, latest)
+
+	next, err := latest.Bump(SemVerPatch)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.10.1", next.String())
+	next, err = latest.Bump(SemVerMinor)
+	require.NoError(t, err)
+	assert.Equal(t, "v1.11.0", next.String())
+	next, err = latest.Bump(SemVerMajor)
+	require.NoError(t, err)
+	assert.Equal(t, "v2.0.0", next.String())
+	_, err = latest.Bump("build")
+	assert.Error(t, err)
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/mod/semver"
+)
+
+// Semantic version components that can be incremented
+const (
+	SemVerMajor = "major"
+	SemVerMinor = "minor"
+	SemVerPatch = "patch"
+)
+
+// SemVer is a vMAJOR.MINOR.PATCH release version
+type SemVer struct {
+	Major, Minor, Patch int
+}
+
+func (v SemVer) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Bump returns the version with the component incremented and the lower components reset
+func (v SemVer) Bump(component string) (SemVer, error) {
+	switch component {
+	case SemVerMajor:
+		return SemVer{Major: v.Major + 1}, nil
+	case SemVerMinor:
+		return SemVer{Major: v.Major, Minor: v.Minor + 1}, nil
+	case SemVerPatch:
+		return SemVer{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}, nil
+	default:
+		return SemVer{}, fmt.Errorf("invalid semantic version component %q, must be major, minor or patch", component)
+	}
+}
+
+// ParseSemVer parses a vMAJOR.MINOR.PATCH tag name. Pre-release and build suffixes are not supported.
+func ParseSemVer(name string) (SemVer, bool) {
+	if !semver.IsValid(name) || semver.Canonical(name) != name || semver.Prerelease(name) != "" {
+		return SemVer{}, false
+	}
+
+	parts := strings.Split(strings.TrimPrefix(name, "v"), ".")
+	var components [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return SemVer{}, false
+		}
+		components[i] = n
+	}
+	return SemVer{Major: components[0], Minor: components[1], Patch: components[2]}, true
+}
+
+// LatestSemVerTag returns the highest vMAJOR.MINOR.PATCH tag of the repository, v0.0.0 if there is none
+func LatestSemVerTag(repo *git.Repository) (SemVer, error) {
+	tags, err := repo.Tags()
+	if err != nil {
+		return SemVer{}, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	latest := "v0.0.0"
+	err = tags.ForEach(func(ref *plumbing.Reference) error {
+		name := ref.Name().Short()
+		if _, ok := ParseSemVer(name); ok && semver.Compare(name, latest) > 0 {
+			latest = name
+		}
+		return nil
+	})
+	if err != nil {
+		return SemVer{}, fmt.Errorf("failed to list tags: %w", err)
+	}
+
+	version, _ := ParseSemVer(latest)
+	return version, nil
+}